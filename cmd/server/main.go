@@ -1,14 +1,22 @@
 package main
 
 import (
+        "context"
         "log"
+        "net/http"
         "os"
 
+        "edgefleet-commander/internal/broker"
+        "edgefleet-commander/internal/coap"
+        "edgefleet-commander/internal/collector"
         "edgefleet-commander/internal/config"
         "edgefleet-commander/internal/database"
+        "edgefleet-commander/internal/events"
         "edgefleet-commander/internal/handlers"
+        "edgefleet-commander/internal/ingest"
         "edgefleet-commander/internal/middleware"
         "edgefleet-commander/internal/services"
+        "edgefleet-commander/internal/spool"
 
         "github.com/gin-contrib/cors"
         "github.com/gin-gonic/gin"
@@ -30,17 +38,145 @@ func main() {
                 log.Fatal("Failed to connect to Redis:", err)
         }
 
+        // Services publish domain events here for the push stream. With Redis
+        // available the bus is shared with the other replicas over Pub/Sub.
+        bus := events.NewBus(cfg.InstanceID)
+        if client := db.GetClient(); client != nil {
+                relay := events.NewRedisRelay(client, cfg.EventsChannel, bus)
+                go relay.Run(context.Background())
+        }
+
+        // Device secrets are encrypted at rest with CREDENTIAL_KEY. Outside
+        // production a well-known key stands in so the server starts as is.
+        if cfg.CredentialKey == "" || cfg.CredentialKey == "change-this-secret" {
+                if cfg.Environment == "production" {
+                        log.Fatal("CREDENTIAL_KEY (or SESSION_SECRET) must be set to a secret value in production")
+                }
+                log.Println("Warning: CREDENTIAL_KEY is not set; device secrets are encrypted with a well-known development key")
+                cfg.CredentialKey = "change-this-secret"
+        }
+
         // Initialize services
-        deviceService := services.NewDeviceService(db)
-        telemetryService := services.NewTelemetryService(db)
-        alertService := services.NewAlertService(db)
+        deviceService := services.NewDeviceService(db, bus)
+        profileService := services.NewProfileService(db)
+        credentialService, err := services.NewCredentialService(db, cfg.CredentialKey)
+        if err != nil {
+                log.Fatal("Failed to create credential service:", err)
+        }
+        certificateService, err := services.NewCertificateService(db, cfg.DeviceIDPattern, cfg.CredentialKey)
+        if err != nil {
+                log.Fatal("Invalid MTLS_DEVICE_ID_PATTERN:", err)
+        }
+        signingService := services.NewSigningService(db, cfg.SignatureSkew)
+        enrollmentService := services.NewEnrollmentService(db, bus, deviceService, credentialService, certificateService)
+        modbusService := services.NewModbusService(db)
+        snmpService := services.NewSNMPService(db)
+        telemetryService := services.NewTelemetryService(db, bus, profileService, services.TelemetryOptions{
+                MaxFutureSkew: cfg.MaxFutureSkew,
+                MaxAge:        cfg.MaxReadingAge,
+                DedupeWindow:  cfg.DedupeWindow,
+        })
+        incidentService := services.NewIncidentService(db, bus, cfg.IncidentWindow)
+        alertService := services.NewAlertService(db, incidentService, bus, cfg.DedupeWindow, cfg.BulkMaxAlerts)
         statsService := services.NewStatsService(db)
 
+        // Telemetry that can't be stored while Redis is down waits on disk
+        var telemetrySpool *spool.Spool
+        if cfg.SpoolDir != "off" {
+                telemetrySpool, err = spool.Open(cfg.SpoolDir, cfg.SpoolMaxBytes)
+                if err != nil {
+                        log.Fatal("Failed to open telemetry spool:", err)
+                }
+                if stats := telemetrySpool.Stats(); stats.Records > 0 {
+                        log.Printf("Telemetry spool holds %d readings to replay", stats.Readings)
+                }
+        }
+
+        // HTTP telemetry is written by a pool of workers in batches
+        pipeline := ingest.New(telemetryService, telemetrySpool, ingest.Options{
+                QueueSize: cfg.IngestQueueSize,
+                Workers:   cfg.IngestWorkers,
+                MaxBatch:  cfg.IngestMaxBatch,
+                Linger:    cfg.IngestLinger,
+                Timeout:   cfg.IngestTimeout,
+        })
+        go pipeline.Run(context.Background())
+
+        // Poll devices configured for Modbus TCP or SNMP
+        if db.GetClient() != nil {
+                modbusPoller := collector.NewModbusPoller(modbusService, telemetryService, alertService, collector.ModbusOptions{
+                        Owner:            cfg.InstanceID,
+                        Timeout:          cfg.ModbusTimeout,
+                        FailureThreshold: cfg.ModbusFailures,
+                })
+                go modbusPoller.Run(context.Background())
+
+                snmpPoller := collector.NewSNMPPoller(snmpService, telemetryService, alertService, collector.SNMPOptions{
+                        Owner:            cfg.InstanceID,
+                        Timeout:          cfg.SNMPTimeout,
+                        FailureThreshold: cfg.SNMPFailures,
+                })
+                go snmpPoller.Run(context.Background())
+        }
+
+        // SNMP traps from configured devices become alerts
+        if cfg.SNMPTrapAddr != "" {
+                trapReceiver, err := collector.ListenTraps(cfg.SNMPTrapAddr, snmpService, alertService)
+                if err != nil {
+                        log.Fatal("Failed to start SNMP trap receiver:", err)
+                }
+                go trapReceiver.Run(context.Background())
+                log.Printf("SNMP trap receiver listening on %s", cfg.SNMPTrapAddr)
+        }
+
+        // Devices can also publish over MQTT when a listen address is set
+        if cfg.MQTTAddr != "" {
+                mqttBroker, err := broker.New(broker.Options{
+                        Addr:          cfg.MQTTAddr,
+                        AdminUsername: cfg.MQTTAdminUser,
+                        AdminPassword: cfg.MQTTAdminPass,
+                }, credentialService, deviceService, telemetryService, signingService)
+                if err != nil {
+                        log.Fatal("Failed to create MQTT broker:", err)
+                }
+                if err := mqttBroker.Start(); err != nil {
+                        log.Fatal("Failed to start MQTT broker:", err)
+                }
+                log.Printf("MQTT broker listening on %s", cfg.MQTTAddr)
+        }
+
+        // Constrained devices can use CoAP, plain and/or over DTLS
+        if cfg.CoAPAddr != "" || cfg.CoAPSecureAddr != "" {
+                coapServer := coap.New(coap.Options{
+                        Addr:          cfg.CoAPAddr,
+                        SecureAddr:    cfg.CoAPSecureAddr,
+                        MaxBatchItems: cfg.BatchMaxItems,
+                }, credentialService, deviceService, telemetryService, signingService)
+                if err := coapServer.Start(); err != nil {
+                        log.Fatal("Failed to start CoAP server:", err)
+                }
+                log.Printf("CoAP server listening on %q (DTLS %q)", cfg.CoAPAddr, cfg.CoAPSecureAddr)
+        }
+
         // Initialize handlers
-        deviceHandler := handlers.NewDeviceHandler(deviceService)
-        telemetryHandler := handlers.NewTelemetryHandler(telemetryService)
+        deviceHandler := handlers.NewDeviceHandler(deviceService, credentialService, certificateService)
+        profileHandler := handlers.NewProfileHandler(profileService)
+        credentialHandler := handlers.NewCredentialHandler(credentialService)
+        certificateHandler := handlers.NewCertificateHandler(certificateService)
+        enrollmentHandler := handlers.NewEnrollmentHandler(enrollmentService)
+        signingHandler := handlers.NewSigningHandler(signingService)
+        modbusHandler := handlers.NewModbusHandler(modbusService)
+        snmpHandler := handlers.NewSNMPHandler(snmpService)
+        telemetryHandler := handlers.NewTelemetryHandler(telemetryService, pipeline, cfg.BatchMaxItems, cfg.BatchMaxBytes)
+        influxHandler := handlers.NewInfluxHandler(pipeline, cfg.InfluxDeviceTag, cfg.BatchMaxItems, cfg.BatchMaxBytes)
+        otlpHandler := handlers.NewOTLPHandler(pipeline, cfg.OTLPDeviceAttr, cfg.BatchMaxItems, cfg.BatchMaxBytes)
         alertHandler := handlers.NewAlertHandler(alertService)
-        statsHandler := handlers.NewStatsHandler(statsService)
+        incidentHandler := handlers.NewIncidentHandler(incidentService)
+        statsHandler := handlers.NewStatsHandler(statsService, pipeline)
+        healthHandler := handlers.NewHealthHandler(db, pipeline)
+
+        allowedOrigins := []string{"http://localhost:3000", "http://localhost:5173"}
+        streamHandler := handlers.NewStreamHandler(bus, allowedOrigins)
 
         // Setup Gin router
         if cfg.Environment == "production" {
@@ -51,7 +187,7 @@ func main() {
 
         // CORS middleware
         r.Use(cors.New(cors.Config{
-                AllowOrigins:     []string{"http://localhost:3000", "http://localhost:5173"},
+                AllowOrigins:     allowedOrigins,
                 AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
                 AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
                 AllowCredentials: true,
@@ -61,6 +197,25 @@ func main() {
         r.Use(middleware.Logger())
         r.Use(middleware.ErrorHandler())
 
+        // Ingestion routes take a device credential, which binds the data to
+        // that device. DEVICE_AUTH=optional also admits anonymous writes for
+        // devices that haven't been issued credentials yet.
+        var deviceAuthRequired bool
+        switch cfg.DeviceAuth {
+        case "required":
+                deviceAuthRequired = true
+        case "optional":
+                log.Println("Ingestion admits anonymous writes for devices without credentials; set DEVICE_AUTH=required once all devices have them")
+        default:
+                log.Fatalf("DEVICE_AUTH must be required or optional, not %q", cfg.DeviceAuth)
+        }
+        deviceAuth := middleware.DeviceAuth(credentialService, certificateService, deviceAuthRequired)
+        // Devices with a signing key may sign their payloads, or must when
+        // the key requires it
+        signature := middleware.TelemetrySignature(signingService, cfg.BatchMaxBytes)
+        // Issuing and revoking device secrets is for operators only
+        admin := middleware.AdminAuth(cfg.AdminToken)
+
         // Serve static files (React build)
         r.Static("/assets", "./dist/public/assets")
         r.StaticFile("/", "./dist/public/index.html")
@@ -75,19 +230,84 @@ func main() {
                 api.GET("/devices/:id", deviceHandler.GetDevice)
                 api.PUT("/devices/:id", deviceHandler.UpdateDevice)
                 api.DELETE("/devices/:id", deviceHandler.DeleteDevice)
+                api.GET("/devices/:id/children", deviceHandler.GetChildDevices)
+                api.GET("/devices/:id/credentials", credentialHandler.GetCredential)
+                api.POST("/devices/:id/credentials", admin, credentialHandler.IssueCredential)
+                api.DELETE("/devices/:id/credentials", admin, credentialHandler.RevokeCredential)
+                api.GET("/devices/:id/certificates", certificateHandler.GetCertificates)
+                api.POST("/devices/:id/certificates", admin, certificateHandler.IssueCertificate)
+                api.DELETE("/devices/:id/certificates/:serial", admin, certificateHandler.RevokeCertificate)
+                api.GET("/devices/:id/signing", signingHandler.GetSigningKey)
+                api.PUT("/devices/:id/signing", admin, signingHandler.SetSigningKey)
+                api.DELETE("/devices/:id/signing", admin, signingHandler.DeleteSigningKey)
+                api.GET("/pki/ca.pem", certificateHandler.GetCA)
+                api.GET("/pki/crl", certificateHandler.GetCRL)
+
+                // Device self-enrollment; devices authenticate with bootstrap
+                // and poll tokens rather than device credentials
+                api.POST("/enroll", enrollmentHandler.Enroll)
+                api.GET("/enroll/:id", enrollmentHandler.PollEnrollment)
+                api.GET("/enrollments", enrollmentHandler.GetEnrollments)
+                api.GET("/enrollments/:id", enrollmentHandler.GetEnrollment)
+                api.POST("/enrollments/:id/approve", admin, enrollmentHandler.ApproveEnrollment)
+                api.POST("/enrollments/:id/reject", admin, enrollmentHandler.RejectEnrollment)
+                api.GET("/enrollment-tokens", enrollmentHandler.GetBootstrapTokens)
+                api.POST("/enrollment-tokens", admin, enrollmentHandler.CreateBootstrapToken)
+                api.DELETE("/enrollment-tokens/:id", admin, enrollmentHandler.DeleteBootstrapToken)
+                api.GET("/devices/:id/modbus", modbusHandler.GetConfig)
+                api.PUT("/devices/:id/modbus", modbusHandler.SaveConfig)
+                api.DELETE("/devices/:id/modbus", modbusHandler.DeleteConfig)
+                api.GET("/modbus", modbusHandler.GetConfigs)
+
+                api.GET("/devices/:id/snmp", snmpHandler.GetConfig)
+                api.PUT("/devices/:id/snmp", snmpHandler.SaveConfig)
+                api.DELETE("/devices/:id/snmp", snmpHandler.DeleteConfig)
+                api.GET("/snmp", snmpHandler.GetConfigs)
+
+                // Device profile routes (one per device type)
+                api.GET("/device-profiles", profileHandler.GetProfiles)
+                api.GET("/device-profiles/:type", profileHandler.GetProfile)
+                api.PUT("/device-profiles/:type", profileHandler.SaveProfile)
+                api.DELETE("/device-profiles/:type", profileHandler.DeleteProfile)
 
                 // Telemetry routes
                 api.GET("/telemetry", telemetryHandler.GetAllTelemetry)
                 api.GET("/telemetry/device/:id", telemetryHandler.GetDeviceTelemetry)
-                api.POST("/telemetry", telemetryHandler.CreateTelemetry)
+                api.GET("/telemetry/device/:id/metrics", telemetryHandler.GetDeviceMetricNames)
+                api.GET("/telemetry/device/:id/metrics/:metric", telemetryHandler.GetMetricSeries)
+                api.POST("/telemetry", deviceAuth, signature, telemetryHandler.CreateTelemetry)
+                api.POST("/telemetry/batch", deviceAuth, signature, telemetryHandler.CreateTelemetryBatch)
+                api.POST("/write", deviceAuth, signature, influxHandler.Write)
+                api.POST("/otlp/v1/metrics", deviceAuth, signature, otlpHandler.ExportMetrics)
 
                 // Alert routes
                 api.GET("/alerts", alertHandler.GetAlerts)
                 api.POST("/alerts", alertHandler.CreateAlert)
+                api.POST("/alerts/bulk", alertHandler.BulkAlerts)
                 api.PUT("/alerts/:id/acknowledge", alertHandler.AcknowledgeAlert)
+                api.PUT("/alerts/:id/resolve", alertHandler.ResolveAlert)
+                api.GET("/alerts/:id/correlated", alertHandler.GetCorrelatedAlerts)
+
+                // Incident routes
+                api.GET("/incidents", incidentHandler.GetIncidents)
+                api.POST("/incidents", incidentHandler.CreateIncident)
+                api.GET("/incidents/:id", incidentHandler.GetIncident)
+                api.GET("/incidents/:id/alerts", incidentHandler.GetIncidentAlerts)
+                api.POST("/incidents/:id/alerts", incidentHandler.AddAlerts)
+                api.GET("/incidents/:id/timeline", incidentHandler.GetTimeline)
+                api.POST("/incidents/:id/comments", incidentHandler.AddComment)
+                api.PUT("/incidents/:id/status", incidentHandler.UpdateStatus)
 
                 // Stats routes
                 api.GET("/stats", statsHandler.GetStats)
+                api.GET("/stats/alerts", statsHandler.GetAlertStats)
+                api.GET("/stats/ingest", statsHandler.GetIngestStats)
+
+                // Health
+                api.GET("/health", healthHandler.GetHealth)
+
+                // Event stream (SSE, or WebSocket on upgrade)
+                api.GET("/stream", streamHandler.Stream)
         }
 
         // Fallback to serve React app for client-side routing
@@ -100,8 +320,23 @@ func main() {
                 port = "5000"
         }
 
+        // With a certificate configured the API is served over TLS, and
+        // devices may authenticate with client certificates
+        if cfg.TLSCertFile != "" {
+                tlsConfig, err := serverTLSConfig(cfg, certificateService)
+                if err != nil {
+                        log.Fatal("Failed to configure TLS:", err)
+                }
+                server := &http.Server{Addr: ":" + port, Handler: r, TLSConfig: tlsConfig}
+                log.Printf("Server starting on port %s (TLS, client certificates %s)", port, cfg.TLSClientAuth)
+                if err := server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+                        log.Fatal("Failed to start server:", err)
+                }
+                return
+        }
+
         log.Printf("Server starting on port %s", port)
         if err := r.Run(":" + port); err != nil {
                 log.Fatal("Failed to start server:", err)
         }
-}
\ No newline at end of file
+}
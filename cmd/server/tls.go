@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"edgefleet-commander/internal/config"
+	"edgefleet-commander/internal/services"
+)
+
+// serverTLSConfig verifies client certificates against the internal device CA
+// and, if configured, an external CA bundle. TLS_CLIENT_AUTH is "optional"
+// (verify a certificate if one is presented), "require" or "none".
+func serverTLSConfig(cfg *config.Config, certificates *services.CertificateService) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch cfg.TLSClientAuth {
+	case "none":
+		return tlsConfig, nil
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("TLS_CLIENT_AUTH must be none, optional or require, not %q", cfg.TLSClientAuth)
+	}
+
+	pool := x509.NewCertPool()
+	ca, err := certificates.CACertificate()
+	if err != nil {
+		return nil, err
+	}
+	pool.AddCert(ca)
+	if cfg.TLSClientCAFile != "" {
+		bundle, err := os.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSClientCAFile)
+		}
+	}
+	tlsConfig.ClientCAs = pool
+	return tlsConfig, nil
+}
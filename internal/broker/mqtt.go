@@ -0,0 +1,285 @@
+// Package broker runs the embedded MQTT broker devices publish telemetry and
+// status to, either on the edgefleet/ topics or as Sparkplug B.
+package broker
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"edgefleet-commander/internal/sparkplug"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// TopicPrefix is the root of every device topic:
+//
+//	edgefleet/<deviceId>/telemetry  reading JSON (object or array)
+//	edgefleet/<deviceId>/status     online, "online" or {"status": "online"}
+const TopicPrefix = "edgefleet"
+
+// deviceStatuses are the statuses a device may report about itself
+var deviceStatuses = map[string]bool{"online": true, "offline": true, "warning": true, "critical": true}
+
+// Options configures the broker
+type Options struct {
+	Addr string
+	// AdminUsername and AdminPassword, when set, allow one client full
+	// access to every topic (e.g. for bridging or debugging)
+	AdminUsername string
+	AdminPassword string
+}
+
+type Broker struct {
+	server *mqtt.Server
+}
+
+// New creates a broker whose devices authenticate with their device ID as
+// username and their device credential as password.
+func New(options Options, credentials *services.CredentialService, devices *services.DeviceService, telemetry *services.TelemetryService, signing *services.SigningService) (*Broker, error) {
+	server := mqtt.New(&mqtt.Options{InlineClient: true})
+	hook := &deviceHook{
+		server:      server,
+		options:     options,
+		credentials: credentials,
+		devices:     devices,
+		telemetry:   telemetry,
+		signing:     signing,
+		sparkplug:   newSparkplugState(),
+	}
+	if err := server.AddHook(hook, nil); err != nil {
+		return nil, fmt.Errorf("failed to add MQTT hook: %w", err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: options.Addr})); err != nil {
+		return nil, fmt.Errorf("failed to add MQTT listener: %w", err)
+	}
+	return &Broker{server: server}, nil
+}
+
+// Start begins accepting connections in the background
+func (b *Broker) Start() error {
+	return b.server.Serve()
+}
+
+func (b *Broker) Close() error {
+	return b.server.Close()
+}
+
+// deviceHook authenticates clients, confines devices to their own topics and
+// hands their messages to the services
+type deviceHook struct {
+	mqtt.HookBase
+	server      *mqtt.Server
+	options     Options
+	credentials *services.CredentialService
+	devices     *services.DeviceService
+	telemetry   *services.TelemetryService
+	signing     *services.SigningService
+	sparkplug   *sparkplugState
+}
+
+func (h *deviceHook) ID() string {
+	return "edgefleet-devices"
+}
+
+func (h *deviceHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnectAuthenticate,
+		mqtt.OnACLCheck,
+		mqtt.OnPublish,
+		mqtt.OnWillSent,
+	}, []byte{b})
+}
+
+func (h *deviceHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	username := string(pk.Connect.Username)
+	password := string(pk.Connect.Password)
+	if h.isAdmin(username) {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(h.options.AdminPassword)) == 1
+	}
+
+	deviceID, err := strconv.Atoi(username)
+	if err != nil || deviceID <= 0 || strconv.Itoa(deviceID) != username {
+		return false
+	}
+	ok, err := h.credentials.VerifyCredential(deviceID, password)
+	if err != nil {
+		log.Printf("MQTT: failed to verify credential for device %d: %v", deviceID, err)
+		return false
+	}
+	return ok
+}
+
+// OnACLCheck lets a device publish only to its own telemetry and status
+// topics and subscribe only below its own prefix. Sparkplug B topics have
+// rules of their own.
+func (h *deviceHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	username := string(cl.Properties.Username)
+	if h.isAdmin(username) {
+		return true
+	}
+	if strings.HasPrefix(topic, sparkplug.Namespace+"/") {
+		deviceID, err := h.clientDeviceID(username)
+		return err == nil && h.sparkplugACL(deviceID, topic, write)
+	}
+
+	own := TopicPrefix + "/" + username + "/"
+	if write {
+		return topic == own+"telemetry" || topic == own+"status"
+	}
+	return strings.HasPrefix(topic, own)
+}
+
+func (h *deviceHook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet, error) {
+	if cl.Net.Inline {
+		return pk, nil
+	}
+	if err := h.handleMessage(cl, pk); err != nil {
+		log.Printf("MQTT: rejected %s from client %s: %v", pk.TopicName, cl.ID, err)
+		var validationErr *services.ValidationError
+		if errors.As(err, &validationErr) {
+			return pk, packets.ErrPayloadFormatInvalid
+		}
+		return pk, packets.ErrImplementationSpecificError
+	}
+	return pk, nil
+}
+
+// OnWillSent applies a client's will message once the broker has sent it,
+// so a device can have itself marked offline (or its edge node dead) when
+// its connection drops
+func (h *deviceHook) OnWillSent(cl *mqtt.Client, pk packets.Packet) {
+	if !h.OnACLCheck(cl, pk.TopicName, true) {
+		return
+	}
+	if err := h.handleMessage(cl, pk); err != nil {
+		log.Printf("MQTT: rejected will %s from client %s: %v", pk.TopicName, cl.ID, err)
+	}
+}
+
+func (h *deviceHook) handleMessage(cl *mqtt.Client, pk packets.Packet) error {
+	if topic, ok := sparkplug.ParseTopic(pk.TopicName); ok {
+		clientDeviceID, err := h.clientDeviceID(string(cl.Properties.Username))
+		if err != nil {
+			return err
+		}
+		return h.handleSparkplug(clientDeviceID, topic, pk.Payload)
+	}
+
+	deviceID, kind, ok := parseTopic(pk.TopicName)
+	if !ok {
+		return nil
+	}
+	switch kind {
+	case "telemetry":
+		return h.handleTelemetry(deviceID, pk.Payload)
+	case "status":
+		return h.handleStatus(deviceID, pk.Payload)
+	}
+	return nil
+}
+
+// handleTelemetry stores a reading, or a JSON array of readings, for the
+// device named in the topic
+func (h *deviceHook) handleTelemetry(deviceID int, payload []byte) error {
+	if err := h.signing.AllowUnsigned(deviceID); err != nil {
+		return err
+	}
+	payload = bytes.TrimSpace(payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		var batch []models.Telemetry
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			return &services.ValidationError{Reason: fmt.Sprintf("invalid telemetry: %v", err)}
+		}
+		for i := range batch {
+			if err := claimReading(&batch[i], deviceID); err != nil {
+				return err
+			}
+		}
+		itemErrs, err := h.telemetry.CreateTelemetryBatch(batch)
+		if err != nil {
+			return err
+		}
+		for i, itemErr := range itemErrs {
+			if itemErr != nil {
+				log.Printf("MQTT: rejected reading %d from device %d: %v", i, deviceID, itemErr)
+			}
+		}
+		return nil
+	}
+
+	var reading models.Telemetry
+	if err := json.Unmarshal(payload, &reading); err != nil {
+		return &services.ValidationError{Reason: fmt.Sprintf("invalid telemetry: %v", err)}
+	}
+	if err := claimReading(&reading, deviceID); err != nil {
+		return err
+	}
+	return h.telemetry.CreateTelemetry(&reading)
+}
+
+func (h *deviceHook) handleStatus(deviceID int, payload []byte) error {
+	status := strings.TrimSpace(string(payload))
+	switch {
+	case strings.HasPrefix(status, "{"):
+		var body struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(payload, &body); err != nil {
+			return &services.ValidationError{Reason: fmt.Sprintf("invalid status: %v", err)}
+		}
+		status = body.Status
+	case strings.HasPrefix(status, `"`):
+		if err := json.Unmarshal(payload, &status); err != nil {
+			return &services.ValidationError{Reason: fmt.Sprintf("invalid status: %v", err)}
+		}
+	}
+	if !deviceStatuses[status] {
+		return &services.ValidationError{Reason: fmt.Sprintf("invalid status %q", status)}
+	}
+	device, err := h.devices.GetDeviceByID(deviceID)
+	if err != nil {
+		return err
+	}
+	if device.Status == "decommissioned" {
+		return &services.ValidationError{Reason: fmt.Sprintf("device %d is decommissioned", deviceID)}
+	}
+	return h.devices.UpdateDeviceStatus(deviceID, status)
+}
+
+func (h *deviceHook) isAdmin(username string) bool {
+	return h.options.AdminUsername != "" && h.options.AdminPassword != "" && username == h.options.AdminUsername
+}
+
+// claimReading attributes a reading to the topic's device. A payload naming
+// a different device is refused rather than silently reassigned. MQTT
+// payloads can't carry a signature, so readings are stored as unsigned.
+func claimReading(t *models.Telemetry, deviceID int) error {
+	if t.DeviceID != 0 && t.DeviceID != deviceID {
+		return &services.ValidationError{Reason: fmt.Sprintf("reading for device %d published on device %d topic", t.DeviceID, deviceID)}
+	}
+	t.DeviceID = deviceID
+	t.Signature = models.SignatureUnsigned
+	return nil
+}
+
+// parseTopic splits edgefleet/<deviceId>/<kind>
+func parseTopic(topic string) (int, string, bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 3 || parts[0] != TopicPrefix {
+		return 0, "", false
+	}
+	deviceID, err := strconv.Atoi(parts[1])
+	if err != nil || deviceID <= 0 {
+		return 0, "", false
+	}
+	return deviceID, parts[2], true
+}
@@ -0,0 +1,353 @@
+package broker
+
+import (
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"edgefleet-commander/internal/sparkplug"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rebirthInterval limits how often an edge node is asked to rebirth
+const rebirthInterval = 10 * time.Second
+
+// sparkplugNode is what the broker knows about an edge node between its
+// birth and death certificates
+type sparkplugNode struct {
+	mu       sync.Mutex
+	deviceID int
+	bdSeq    *float64
+	aliases  sparkplug.Aliases
+	devices  map[string]*sparkplugDevice
+}
+
+type sparkplugDevice struct {
+	deviceID int
+	aliases  sparkplug.Aliases
+}
+
+// sparkplugState tracks the edge nodes whose births the broker has seen
+type sparkplugState struct {
+	mu       sync.Mutex
+	nodes    map[string]*sparkplugNode
+	rebirths map[string]time.Time
+}
+
+func newSparkplugState() *sparkplugState {
+	return &sparkplugState{
+		nodes:    map[string]*sparkplugNode{},
+		rebirths: map[string]time.Time{},
+	}
+}
+
+func (s *sparkplugState) node(key string) *sparkplugNode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nodes[key]
+}
+
+func (s *sparkplugState) setNode(key string, node *sparkplugNode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if node == nil {
+		delete(s.nodes, key)
+		return
+	}
+	s.nodes[key] = node
+}
+
+// handleSparkplug applies a Sparkplug B message. clientDeviceID is the
+// device the publishing client authenticated as, or 0 for the admin client.
+func (h *deviceHook) handleSparkplug(clientDeviceID int, topic sparkplug.Topic, data []byte) error {
+	switch topic.MessageType {
+	case sparkplug.NBIRTH, sparkplug.NDATA, sparkplug.NDEATH, sparkplug.DBIRTH, sparkplug.DDATA, sparkplug.DDEATH:
+	default:
+		// Commands are for the edge nodes, not the server
+		return nil
+	}
+
+	payload, err := sparkplug.Decode(data)
+	if err != nil {
+		return &services.ValidationError{Reason: err.Error()}
+	}
+
+	if topic.MessageType == sparkplug.NBIRTH {
+		return h.sparkplugNodeBirth(clientDeviceID, topic, payload)
+	}
+
+	node := h.sparkplug.node(topic.NodeKey())
+	if node == nil {
+		if topic.MessageType != sparkplug.NDEATH && topic.MessageType != sparkplug.DDEATH {
+			h.requestRebirth(topic)
+		}
+		return nil
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if clientDeviceID != 0 && node.deviceID != clientDeviceID {
+		return &services.ValidationError{Reason: fmt.Sprintf("edge node %s belongs to device %d", topic.NodeKey(), node.deviceID)}
+	}
+
+	switch topic.MessageType {
+	case sparkplug.NDATA:
+		if err := node.aliases.Resolve(payload.Metrics); err != nil {
+			h.requestRebirth(topic)
+			return nil
+		}
+		return h.storeSparkplugReading(node.deviceID, payload)
+
+	case sparkplug.NDEATH:
+		// A death certificate from an earlier session must not take down
+		// the node that has since been reborn
+		if bdSeq := findBdSeq(payload); bdSeq != nil && node.bdSeq != nil && *bdSeq != *node.bdSeq {
+			return nil
+		}
+		for _, device := range node.devices {
+			h.setDeviceStatus(device.deviceID, "offline")
+		}
+		h.setDeviceStatus(node.deviceID, "offline")
+		h.sparkplug.setNode(topic.NodeKey(), nil)
+		return nil
+
+	case sparkplug.DBIRTH:
+		device, err := h.sparkplugDevice(node, topic)
+		if err != nil {
+			return err
+		}
+		aliases := sparkplug.Aliases{}
+		aliases.Learn(payload.Metrics)
+		node.devices[topic.DeviceID] = &sparkplugDevice{deviceID: device.ID, aliases: aliases}
+		h.setDeviceStatus(device.ID, "online")
+		h.storeBirthReading(device.ID, payload)
+		return nil
+
+	case sparkplug.DDATA:
+		device, ok := node.devices[topic.DeviceID]
+		if !ok {
+			h.requestRebirth(topic)
+			return nil
+		}
+		if err := device.aliases.Resolve(payload.Metrics); err != nil {
+			h.requestRebirth(topic)
+			return nil
+		}
+		return h.storeSparkplugReading(device.deviceID, payload)
+
+	case sparkplug.DDEATH:
+		if device, ok := node.devices[topic.DeviceID]; ok {
+			h.setDeviceStatus(device.deviceID, "offline")
+			delete(node.devices, topic.DeviceID)
+		}
+		return nil
+	}
+	return nil
+}
+
+// sparkplugNodeBirth binds the edge node to a device, registering one if
+// needed, and starts a fresh session for it. A node first born from a device
+// client's connection is bound to that device; one born through the admin
+// client (e.g. a bridge) gets a gateway device of its own.
+func (h *deviceHook) sparkplugNodeBirth(clientDeviceID int, topic sparkplug.Topic, payload *sparkplug.Payload) error {
+	externalID := "sparkplug:" + topic.NodeKey()
+	device, err := h.devices.FindDeviceByExternalID(externalID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case device != nil:
+		if clientDeviceID != 0 && device.ID != clientDeviceID {
+			return &services.ValidationError{Reason: fmt.Sprintf("edge node %s belongs to device %d", topic.NodeKey(), device.ID)}
+		}
+	case clientDeviceID != 0:
+		if device, err = h.devices.GetDeviceByID(clientDeviceID); err != nil {
+			return err
+		}
+		if err := h.devices.BindExternalID(externalID, device.ID); err != nil {
+			return err
+		}
+	default:
+		device, err = h.devices.CreateDevice(&models.InsertDevice{
+			Name:     topic.EdgeNodeID,
+			Type:     "gateway",
+			Location: topic.GroupID,
+			Status:   "online",
+		})
+		if err != nil {
+			return err
+		}
+		if err := h.devices.BindExternalID(externalID, device.ID); err != nil {
+			return err
+		}
+		log.Printf("Sparkplug: registered edge node %s as device %d", topic.NodeKey(), device.ID)
+	}
+
+	aliases := sparkplug.Aliases{}
+	aliases.Learn(payload.Metrics)
+	h.sparkplug.setNode(topic.NodeKey(), &sparkplugNode{
+		deviceID: device.ID,
+		bdSeq:    findBdSeq(payload),
+		aliases:  aliases,
+		devices:  map[string]*sparkplugDevice{},
+	})
+	h.setDeviceStatus(device.ID, "online")
+	h.storeBirthReading(device.ID, payload)
+	return nil
+}
+
+// sparkplugDevice finds or registers the device a DBIRTH describes, as a
+// child of its edge node
+func (h *deviceHook) sparkplugDevice(node *sparkplugNode, topic sparkplug.Topic) (*models.Device, error) {
+	externalID := "sparkplug:" + topic.DeviceKey()
+	device, err := h.devices.FindDeviceByExternalID(externalID)
+	if err != nil || device != nil {
+		return device, err
+	}
+
+	parent, err := h.devices.GetDeviceByID(node.deviceID)
+	if err != nil {
+		return nil, err
+	}
+	device, err = h.devices.CreateDevice(&models.InsertDevice{
+		Name:     topic.DeviceID,
+		Type:     "sparkplug",
+		Location: parent.Location,
+		Status:   "online",
+		ParentID: &parent.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := h.devices.BindExternalID(externalID, device.ID); err != nil {
+		return nil, err
+	}
+	log.Printf("Sparkplug: registered device %s as device %d", topic.DeviceKey(), device.ID)
+	return device, nil
+}
+
+// storeBirthReading keeps the values a birth certificate reports. The birth
+// itself has already been applied, so a rejected reading is only logged.
+func (h *deviceHook) storeBirthReading(deviceID int, payload *sparkplug.Payload) {
+	if err := h.storeSparkplugReading(deviceID, payload); err != nil {
+		log.Printf("Sparkplug: birth reading for device %d not stored: %v", deviceID, err)
+	}
+}
+
+// storeSparkplugReading stores the numeric metrics of a payload as one
+// reading. Control metrics, nulls and non-numeric values are skipped.
+func (h *deviceHook) storeSparkplugReading(deviceID int, payload *sparkplug.Payload) error {
+	if err := h.signing.AllowUnsigned(deviceID); err != nil {
+		return err
+	}
+	reading := models.Telemetry{DeviceID: deviceID, Signature: models.SignatureUnsigned}
+	if payload.Timestamp > 0 {
+		reading.Timestamp = time.UnixMilli(int64(payload.Timestamp))
+	}
+
+	stored := 0
+	for _, m := range payload.Metrics {
+		if m.IsNull || !m.Numeric || m.Name == "bdSeq" ||
+			strings.HasPrefix(m.Name, "Node Control/") || strings.HasPrefix(m.Name, "Device Control/") {
+			continue
+		}
+		name := services.MetricName(m.Name)
+		if name == "" {
+			continue
+		}
+		services.SetMetric(&reading, name, m.Value, m.Properties["engUnit"])
+		stored++
+	}
+	if stored == 0 {
+		return nil
+	}
+	return h.telemetry.CreateTelemetry(&reading)
+}
+
+// setDeviceStatus records a status learned from birth and death
+// certificates. Decommissioned devices keep their status.
+func (h *deviceHook) setDeviceStatus(deviceID int, status string) {
+	device, err := h.devices.GetDeviceByID(deviceID)
+	if err != nil || device.Status == status || device.Status == "decommissioned" {
+		return
+	}
+	if err := h.devices.UpdateDeviceStatus(deviceID, status); err != nil {
+		log.Printf("Sparkplug: failed to mark device %d %s: %v", deviceID, status, err)
+	}
+}
+
+// requestRebirth asks an edge node to republish its births, which is how
+// Sparkplug recovers from data arriving for a session the host hasn't seen
+func (h *deviceHook) requestRebirth(topic sparkplug.Topic) {
+	key := topic.NodeKey()
+	h.sparkplug.mu.Lock()
+	if time.Since(h.sparkplug.rebirths[key]) < rebirthInterval {
+		h.sparkplug.mu.Unlock()
+		return
+	}
+	h.sparkplug.rebirths[key] = time.Now()
+	h.sparkplug.mu.Unlock()
+
+	commandTopic := strings.Join([]string{sparkplug.Namespace, topic.GroupID, sparkplug.NCMD, topic.EdgeNodeID}, "/")
+	payload := sparkplug.RebirthRequest(uint64(time.Now().UnixMilli()))
+	if err := h.server.Publish(commandTopic, payload, false, 0); err != nil {
+		log.Printf("Sparkplug: failed to request rebirth of %s: %v", key, err)
+	}
+}
+
+// sparkplugACL decides access to Sparkplug topics for a device client.
+// Devices may publish node and device messages (ownership of the edge node
+// is checked per message) and subscribe to commands for edge nodes that are
+// theirs or not yet bound, plus host STATE.
+func (h *deviceHook) sparkplugACL(deviceID int, topic string, write bool) bool {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 2 || parts[0] != sparkplug.Namespace {
+		return false
+	}
+	if parts[1] == "STATE" {
+		return !write
+	}
+	if len(parts) < 4 {
+		return false
+	}
+	messageType := parts[2]
+	isCommand := messageType == sparkplug.NCMD || messageType == sparkplug.DCMD
+	if write {
+		return !isCommand
+	}
+	if !isCommand || strings.ContainsAny(parts[1]+parts[3], "+#") {
+		return false
+	}
+
+	device, err := h.devices.FindDeviceByExternalID("sparkplug:" + parts[1] + "/" + parts[3])
+	if err != nil {
+		log.Printf("Sparkplug: failed to check edge node owner: %v", err)
+		return false
+	}
+	return device == nil || device.ID == deviceID
+}
+
+func findBdSeq(payload *sparkplug.Payload) *float64 {
+	for _, m := range payload.Metrics {
+		if m.Name == "bdSeq" && m.Numeric {
+			value := m.Value
+			return &value
+		}
+	}
+	return nil
+}
+
+// clientDeviceID is the device a client authenticated as, or 0 for the admin
+func (h *deviceHook) clientDeviceID(username string) (int, error) {
+	if h.isAdmin(username) {
+		return 0, nil
+	}
+	deviceID, err := strconv.Atoi(username)
+	if err != nil {
+		return 0, errors.New("client is not a device")
+	}
+	return deviceID, nil
+}
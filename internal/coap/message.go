@@ -0,0 +1,242 @@
+// Package coap serves the CoAP (RFC 7252) resources constrained devices use
+// to report telemetry and heartbeats, over plain UDP or DTLS with pre-shared
+// keys.
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Message types
+const (
+	Confirmable     = 0
+	NonConfirmable  = 1
+	Acknowledgement = 2
+	Reset           = 3
+)
+
+// Codes, written class.detail as in the RFC
+const (
+	CodeEmpty = 0x00
+	CodeGET   = 0x01
+	CodePOST  = 0x02
+	CodePUT   = 0x03
+
+	CodeCreated                  = 0x41 // 2.01
+	CodeChanged                  = 0x44 // 2.04
+	CodeContent                  = 0x45 // 2.05
+	CodeBadRequest               = 0x80 // 4.00
+	CodeUnauthorized             = 0x81 // 4.01
+	CodeBadOption                = 0x82 // 4.02
+	CodeForbidden                = 0x83 // 4.03
+	CodeNotFound                 = 0x84 // 4.04
+	CodeMethodNotAllowed         = 0x85 // 4.05
+	CodeRequestEntityTooLarge    = 0x8D // 4.13
+	CodeUnsupportedContentFormat = 0x8F // 4.15
+	CodeInternalServerError      = 0xA0 // 5.00
+	CodeServiceUnavailable       = 0xA3 // 5.03
+)
+
+// Option numbers
+const (
+	OptionURIHost       = 3
+	OptionURIPort       = 7
+	OptionURIPath       = 11
+	OptionContentFormat = 12
+	OptionURIQuery      = 15
+	OptionAccept        = 17
+	OptionSize1         = 60
+)
+
+// Content formats
+const (
+	FormatText = 0
+	FormatJSON = 50
+	FormatCBOR = 60
+)
+
+const payloadMarker = 0xFF
+
+// Option is one option instance; repeatable options appear several times
+type Option struct {
+	Number uint16
+	Value  []byte
+}
+
+type Message struct {
+	Type      uint8
+	Code      uint8
+	MessageID uint16
+	Token     []byte
+	Options   []Option
+	Payload   []byte
+}
+
+var errMalformed = errors.New("malformed CoAP message")
+
+// Parse decodes a datagram
+func Parse(data []byte) (*Message, error) {
+	if len(data) < 4 || data[0]>>6 != 1 {
+		return nil, errMalformed
+	}
+	m := &Message{
+		Type:      (data[0] >> 4) & 0x03,
+		Code:      data[1],
+		MessageID: binary.BigEndian.Uint16(data[2:]),
+	}
+	tokenLength := int(data[0] & 0x0F)
+	if tokenLength > 8 || len(data) < 4+tokenLength {
+		return nil, errMalformed
+	}
+	m.Token = data[4 : 4+tokenLength]
+	data = data[4+tokenLength:]
+
+	var number uint16
+	for len(data) > 0 {
+		if data[0] == payloadMarker {
+			if len(data) == 1 {
+				return nil, errMalformed
+			}
+			m.Payload = data[1:]
+			break
+		}
+		delta, length := int(data[0]>>4), int(data[0]&0x0F)
+		data = data[1:]
+		var err error
+		if delta, data, err = optionNibble(delta, data); err != nil {
+			return nil, err
+		}
+		if length, data, err = optionNibble(length, data); err != nil {
+			return nil, err
+		}
+		if int(number)+delta > 0xFFFF || len(data) < length {
+			return nil, errMalformed
+		}
+		number += uint16(delta)
+		m.Options = append(m.Options, Option{Number: number, Value: data[:length]})
+		data = data[length:]
+	}
+	return m, nil
+}
+
+// optionNibble reads the extended form of an option delta or length
+func optionNibble(nibble int, data []byte) (int, []byte, error) {
+	switch nibble {
+	case 13:
+		if len(data) < 1 {
+			return 0, nil, errMalformed
+		}
+		return int(data[0]) + 13, data[1:], nil
+	case 14:
+		if len(data) < 2 {
+			return 0, nil, errMalformed
+		}
+		return int(binary.BigEndian.Uint16(data)) + 269, data[2:], nil
+	case 15:
+		return 0, nil, errMalformed
+	}
+	return nibble, data, nil
+}
+
+// Marshal encodes the message, sorting its options
+func (m *Message) Marshal() []byte {
+	out := []byte{1<<6 | m.Type<<4 | uint8(len(m.Token)), m.Code, 0, 0}
+	binary.BigEndian.PutUint16(out[2:], m.MessageID)
+	out = append(out, m.Token...)
+
+	options := append([]Option(nil), m.Options...)
+	sort.SliceStable(options, func(i, j int) bool { return options[i].Number < options[j].Number })
+	var previous uint16
+	for _, o := range options {
+		deltaNibble, deltaExt := extendOption(int(o.Number - previous))
+		lengthNibble, lengthExt := extendOption(len(o.Value))
+		out = append(out, byte(deltaNibble<<4|lengthNibble))
+		out = append(out, deltaExt...)
+		out = append(out, lengthExt...)
+		out = append(out, o.Value...)
+		previous = o.Number
+	}
+
+	if len(m.Payload) > 0 {
+		out = append(out, payloadMarker)
+		out = append(out, m.Payload...)
+	}
+	return out
+}
+
+func extendOption(v int) (int, []byte) {
+	switch {
+	case v < 13:
+		return v, nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		ext := make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(v-269))
+		return 14, ext
+	}
+}
+
+// Path joins the Uri-Path options
+func (m *Message) Path() string {
+	var segments []string
+	for _, o := range m.Options {
+		if o.Number == OptionURIPath {
+			segments = append(segments, string(o.Value))
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Query returns the value of a key=value Uri-Query option
+func (m *Message) Query(key string) string {
+	for _, o := range m.Options {
+		if o.Number == OptionURIQuery {
+			if k, v, ok := strings.Cut(string(o.Value), "="); ok && k == key {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// Uint returns the value of a uint option and whether it is present
+func (m *Message) Uint(number uint16) (uint32, bool) {
+	for _, o := range m.Options {
+		if o.Number == number {
+			var v uint32
+			for _, b := range o.Value {
+				v = v<<8 | uint32(b)
+			}
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// UintOption encodes a uint option value in the fewest bytes
+func UintOption(number uint16, v uint32) Option {
+	var value []byte
+	for ; v > 0; v >>= 8 {
+		value = append([]byte{byte(v)}, value...)
+	}
+	return Option{Number: number, Value: value}
+}
+
+// unsupportedCritical returns the first critical (odd-numbered) option the
+// server doesn't understand, which a request must be rejected for
+func (m *Message) unsupportedCritical() (uint16, bool) {
+	for _, o := range m.Options {
+		switch o.Number {
+		case OptionURIHost, OptionURIPort, OptionURIPath, OptionURIQuery, OptionAccept:
+			continue
+		}
+		if o.Number&1 == 1 {
+			return o.Number, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,559 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"edgefleet-commander/internal/codec"
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pion/dtls/v3"
+)
+
+const (
+	// maxDatagram is the largest request accepted; batches may need more
+	// than the 1152 bytes the RFC recommends for a single message
+	maxDatagram = 65535
+
+	// exchangeLifetime is how long a message ID is remembered so that
+	// retransmissions get the original response instead of being applied
+	// twice (EXCHANGE_LIFETIME, RFC 7252 section 4.8.2)
+	exchangeLifetime = 247 * time.Second
+
+	handshakeTimeout = 10 * time.Second
+
+	// sessionIdle closes DTLS sessions a device has stopped using
+	sessionIdle = 5 * time.Minute
+)
+
+// deviceStatuses are the statuses a device may report in a heartbeat
+var deviceStatuses = map[string]bool{"online": true, "offline": true, "warning": true, "critical": true}
+
+// Options configures the server. Either address may be empty to disable
+// that transport.
+type Options struct {
+	// Addr is the plain CoAP address. Requests carry id and token
+	// Uri-Query parameters.
+	Addr string
+	// SecureAddr is the DTLS address. The PSK identity is the device ID and
+	// the key is HMAC-SHA256(token, "edgefleet-coap-psk").
+	SecureAddr    string
+	MaxBatchItems int
+}
+
+type Server struct {
+	options     Options
+	credentials *services.CredentialService
+	devices     *services.DeviceService
+	telemetry   *services.TelemetryService
+	signing     *services.SigningService
+
+	conn      net.PacketConn
+	secure    net.Listener
+	exchanges *exchangeCache
+	messageID atomic.Uint32
+}
+
+// session is a device authenticated by a DTLS handshake
+type session struct {
+	deviceID int
+	key      []byte
+}
+
+func New(options Options, credentials *services.CredentialService, devices *services.DeviceService, telemetry *services.TelemetryService, signing *services.SigningService) *Server {
+	return &Server{
+		options:     options,
+		credentials: credentials,
+		devices:     devices,
+		telemetry:   telemetry,
+		signing:     signing,
+		exchanges:   &exchangeCache{entries: map[string]*exchange{}},
+	}
+}
+
+// Start binds the configured listeners and serves them in the background
+func (s *Server) Start() error {
+	if s.options.Addr != "" {
+		conn, err := net.ListenPacket("udp", s.options.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for CoAP: %w", err)
+		}
+		s.conn = conn
+		go s.serve()
+	}
+
+	if s.options.SecureAddr != "" {
+		addr, err := net.ResolveUDPAddr("udp", s.options.SecureAddr)
+		if err != nil {
+			s.Close()
+			return fmt.Errorf("invalid CoAP DTLS address: %w", err)
+		}
+		listener, err := dtls.Listen("udp", addr, &dtls.Config{
+			PSK: s.presharedKey,
+			CipherSuites: []dtls.CipherSuiteID{
+				dtls.TLS_PSK_WITH_AES_128_CCM_8,
+				dtls.TLS_PSK_WITH_AES_128_GCM_SHA256,
+				dtls.TLS_PSK_WITH_AES_128_CBC_SHA256,
+			},
+			ExtendedMasterSecret: dtls.RequestExtendedMasterSecret,
+		})
+		if err != nil {
+			s.Close()
+			return fmt.Errorf("failed to listen for CoAP over DTLS: %w", err)
+		}
+		s.secure = listener
+		go s.serveSecure()
+	}
+
+	go s.exchanges.expire()
+	return nil
+}
+
+func (s *Server) Close() error {
+	var errs []error
+	if s.conn != nil {
+		errs = append(errs, s.conn.Close())
+	}
+	if s.secure != nil {
+		errs = append(errs, s.secure.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("CoAP: read failed: %v", err)
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		go func() {
+			if response := s.handle(data, addr.String(), nil); response != nil {
+				if _, err := s.conn.WriteTo(response, addr); err != nil {
+					log.Printf("CoAP: failed to respond to %s: %v", addr, err)
+				}
+			}
+		}()
+	}
+}
+
+func (s *Server) serveSecure() {
+	for {
+		conn, err := s.secure.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("CoAP: DTLS accept failed: %v", err)
+			continue
+		}
+		go s.serveSession(conn.(*dtls.Conn))
+	}
+}
+
+// serveSession handles the requests of one DTLS session in order
+func (s *Server) serveSession(conn *dtls.Conn) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	err := conn.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	state, ok := conn.ConnectionState()
+	if !ok {
+		return
+	}
+	deviceID, err := parseDeviceID(string(state.IdentityHint))
+	if err != nil {
+		return
+	}
+	key, err := s.credentials.PresharedKey(deviceID)
+	if err != nil || key == nil {
+		return
+	}
+	sess := &session{deviceID: deviceID, key: key}
+
+	peer := "dtls:" + conn.RemoteAddr().String()
+	buf := make([]byte, maxDatagram)
+	for {
+		conn.SetReadDeadline(time.Now().Add(sessionIdle))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if response := s.handle(buf[:n], peer, sess); response != nil {
+			if _, err := conn.Write(response); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// presharedKey looks up the key for the identity a client offers
+func (s *Server) presharedKey(identity []byte) ([]byte, error) {
+	deviceID, err := parseDeviceID(string(identity))
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.credentials.PresharedKey(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("device %d has no credential", deviceID)
+	}
+	return key, nil
+}
+
+// handle answers one datagram from peer. It returns nil when nothing should
+// be sent back.
+func (s *Server) handle(data []byte, peer string, sess *session) []byte {
+	request, err := Parse(data)
+	if err != nil || request.Type == Acknowledgement || request.Type == Reset {
+		return nil
+	}
+	if request.Code == CodeEmpty || request.Code>>5 != 0 {
+		// A CON ping, or a response where a request was expected, gets a
+		// Reset
+		if request.Type == Confirmable {
+			return (&Message{Type: Reset, MessageID: request.MessageID}).Marshal()
+		}
+		return nil
+	}
+
+	key := peer + "#" + strconv.Itoa(int(request.MessageID))
+	if cached, seen := s.exchanges.begin(key); seen {
+		return cached
+	}
+
+	response := s.respond(request, sess)
+	response.Token = request.Token
+	if request.Type == Confirmable {
+		response.Type = Acknowledgement
+		response.MessageID = request.MessageID
+	} else {
+		response.Type = NonConfirmable
+		response.MessageID = uint16(s.messageID.Add(1))
+	}
+	out := response.Marshal()
+	s.exchanges.finish(key, out)
+	return out
+}
+
+func (s *Server) respond(request *Message, sess *session) *Message {
+	if number, ok := request.unsupportedCritical(); ok {
+		return diagnostic(CodeBadOption, fmt.Sprintf("unsupported option %d", number))
+	}
+
+	var resource func(deviceID int, request *Message) *Message
+	switch request.Path() {
+	case "telemetry":
+		resource = s.postTelemetry
+	case "heartbeat":
+		resource = s.postHeartbeat
+	default:
+		return diagnostic(CodeNotFound, "")
+	}
+	if request.Code != CodePOST && request.Code != CodePUT {
+		return diagnostic(CodeMethodNotAllowed, "")
+	}
+
+	deviceID, err := s.authenticate(request, sess)
+	if err != nil {
+		log.Printf("CoAP: %v", err)
+		return diagnostic(CodeInternalServerError, "")
+	}
+	if deviceID == 0 {
+		return diagnostic(CodeUnauthorized, "")
+	}
+	return resource(deviceID, request)
+}
+
+// authenticate returns the device a request is from, or 0 if its
+// credentials don't check out. DTLS sessions are rechecked against the
+// current key so that a rotated or revoked credential takes effect at once.
+func (s *Server) authenticate(request *Message, sess *session) (int, error) {
+	if sess != nil {
+		key, err := s.credentials.PresharedKey(sess.deviceID)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare(key, sess.key) != 1 {
+			return 0, nil
+		}
+		return sess.deviceID, nil
+	}
+
+	deviceID, err := parseDeviceID(request.Query("id"))
+	if err != nil {
+		return 0, nil
+	}
+	ok, err := s.credentials.VerifyCredential(deviceID, request.Query("token"))
+	if err != nil || !ok {
+		return 0, err
+	}
+	return deviceID, nil
+}
+
+// postTelemetry stores a reading, or a batch of readings sent as an array
+func (s *Server) postTelemetry(deviceID int, request *Message) *Message {
+	if err := s.signing.AllowUnsigned(deviceID); err != nil {
+		return serviceError(err)
+	}
+	body, response := jsonPayload(request)
+	if response != nil {
+		return response
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []models.Telemetry
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return diagnostic(CodeBadRequest, fmt.Sprintf("invalid telemetry: %v", err))
+		}
+		if len(batch) == 0 {
+			return diagnostic(CodeBadRequest, "batch contains no readings")
+		}
+		if len(batch) > s.options.MaxBatchItems {
+			return diagnostic(CodeRequestEntityTooLarge, fmt.Sprintf("batch exceeds %d readings", s.options.MaxBatchItems))
+		}
+		for i := range batch {
+			if err := claimReading(&batch[i], deviceID); err != nil {
+				return serviceError(err)
+			}
+		}
+		itemErrs, replayed, err := s.telemetry.CreateTelemetryBatchDeduped(batch)
+		if err != nil {
+			return serviceError(err)
+		}
+
+		result := models.TelemetryBatchResponse{Results: make([]models.TelemetryBatchResult, len(batch))}
+		for i := range batch {
+			item := models.TelemetryBatchResult{Index: i}
+			if itemErrs[i] != nil {
+				item.Status = "rejected"
+				item.Error = itemErrs[i].Error()
+				result.Rejected++
+			} else {
+				item.Status = "created"
+				if replayed[i] {
+					item.Status = "duplicate"
+				}
+				item.ID = batch[i].ID
+				item.Flags = batch[i].Flags
+				result.Accepted++
+			}
+			result.Results[i] = item
+		}
+		return encoded(CodeCreated, request, result)
+	}
+
+	var reading models.Telemetry
+	if err := json.Unmarshal(body, &reading); err != nil {
+		return diagnostic(CodeBadRequest, fmt.Sprintf("invalid telemetry: %v", err))
+	}
+	if err := claimReading(&reading, deviceID); err != nil {
+		return serviceError(err)
+	}
+	if err := s.telemetry.CreateTelemetry(&reading); err != nil {
+		return serviceError(err)
+	}
+	return &Message{Code: CodeCreated}
+}
+
+// postHeartbeat records that a device is alive. An empty heartbeat brings an
+// offline device back online and leaves any other status alone; one with a
+// status sets it.
+func (s *Server) postHeartbeat(deviceID int, request *Message) *Message {
+	var status string
+	if len(request.Payload) > 0 {
+		format, _ := request.Uint(OptionContentFormat)
+		if format == FormatText {
+			status = strings.TrimSpace(string(request.Payload))
+		} else {
+			body, response := jsonPayload(request)
+			if response != nil {
+				return response
+			}
+			var heartbeat struct {
+				Status string `json:"status"`
+			}
+			if err := json.Unmarshal(body, &heartbeat); err != nil {
+				return diagnostic(CodeBadRequest, fmt.Sprintf("invalid heartbeat: %v", err))
+			}
+			status = heartbeat.Status
+		}
+		if !deviceStatuses[status] {
+			return diagnostic(CodeBadRequest, fmt.Sprintf("invalid status %q", status))
+		}
+	}
+
+	device, err := s.devices.GetDeviceByID(deviceID)
+	if err != nil {
+		return serviceError(err)
+	}
+	if device.Status == "decommissioned" {
+		return diagnostic(CodeForbidden, fmt.Sprintf("device %d is decommissioned", deviceID))
+	}
+	if status == "" {
+		if device.Status != "offline" {
+			return &Message{Code: CodeChanged}
+		}
+		status = "online"
+	}
+	if status != device.Status {
+		if err := s.devices.UpdateDeviceStatus(deviceID, status); err != nil {
+			return serviceError(err)
+		}
+	}
+	return &Message{Code: CodeChanged}
+}
+
+// jsonPayload returns the request body as JSON, converting CBOR. Requests
+// without a Content-Format are taken to be JSON.
+func jsonPayload(request *Message) ([]byte, *Message) {
+	format, ok := request.Uint(OptionContentFormat)
+	switch {
+	case !ok || format == FormatJSON:
+		return request.Payload, nil
+	case format == FormatCBOR:
+		body, err := codec.CBORToJSON(request.Payload)
+		if err != nil {
+			return nil, diagnostic(CodeBadRequest, err.Error())
+		}
+		return body, nil
+	default:
+		return nil, diagnostic(CodeUnsupportedContentFormat, "")
+	}
+}
+
+// encoded builds a response carrying v as CBOR when the client asked for it
+// (or sent CBOR without an Accept option), and as JSON otherwise
+func encoded(code uint8, request *Message, v interface{}) *Message {
+	format, ok := request.Uint(OptionAccept)
+	if !ok {
+		format, _ = request.Uint(OptionContentFormat)
+	}
+	var payload []byte
+	var err error
+	if format == FormatCBOR {
+		payload, err = cbor.Marshal(v)
+	} else {
+		format = FormatJSON
+		payload, err = json.Marshal(v)
+	}
+	if err != nil {
+		return diagnostic(CodeInternalServerError, "")
+	}
+	return &Message{
+		Code:    code,
+		Options: []Option{UintOption(OptionContentFormat, format)},
+		Payload: payload,
+	}
+}
+
+// diagnostic is an error response with a human-readable reason
+func diagnostic(code uint8, reason string) *Message {
+	return &Message{Code: code, Payload: []byte(reason)}
+}
+
+func serviceError(err error) *Message {
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		return diagnostic(CodeBadRequest, err.Error())
+	}
+	if errors.Is(err, services.ErrMessageInFlight) {
+		return diagnostic(CodeServiceUnavailable, err.Error())
+	}
+	if errors.Is(err, services.ErrSignatureRequired) {
+		return diagnostic(CodeUnauthorized, err.Error())
+	}
+	log.Printf("CoAP: %v", err)
+	return diagnostic(CodeInternalServerError, "")
+}
+
+// claimReading attributes a reading to the authenticated device. A payload
+// naming a different device is refused rather than silently reassigned. CoAP
+// payloads can't carry a signature, so readings are stored as unsigned.
+func claimReading(t *models.Telemetry, deviceID int) error {
+	if t.DeviceID != 0 && t.DeviceID != deviceID {
+		return &services.ValidationError{Reason: fmt.Sprintf("reading for device %d sent by device %d", t.DeviceID, deviceID)}
+	}
+	t.DeviceID = deviceID
+	t.Signature = models.SignatureUnsigned
+	return nil
+}
+
+// parseDeviceID accepts only the canonical decimal form of a device ID
+func parseDeviceID(s string) (int, error) {
+	deviceID, err := strconv.Atoi(s)
+	if err != nil || deviceID <= 0 || strconv.Itoa(deviceID) != s {
+		return 0, fmt.Errorf("invalid device ID %q", s)
+	}
+	return deviceID, nil
+}
+
+// exchangeCache remembers recent requests by peer and message ID
+type exchangeCache struct {
+	mu      sync.Mutex
+	entries map[string]*exchange
+}
+
+type exchange struct {
+	expires  time.Time
+	response []byte
+}
+
+// begin registers a request. For a repeat it reports seen along with the
+// original response, which is nil while that request is still in progress.
+func (c *exchangeCache) begin(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && time.Now().Before(e.expires) {
+		return e.response, true
+	}
+	c.entries[key] = &exchange{expires: time.Now().Add(exchangeLifetime)}
+	return nil, false
+}
+
+func (c *exchangeCache) finish(key string, response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.response = response
+	}
+}
+
+func (c *exchangeCache) expire() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		c.mu.Lock()
+		for key, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
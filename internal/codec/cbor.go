@@ -0,0 +1,29 @@
+// Package codec decodes the compact telemetry encodings devices may send
+// instead of JSON: CBOR, converted to JSON so it takes the same decoding path,
+// and the protobuf schema in telemetry.proto.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborDecoder decodes maps with string keys so they can be re-encoded as JSON
+var cborDecoder, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+
+// CBORToJSON converts a CBOR document to JSON. Epoch (tag 1) and RFC 3339
+// (tag 0) times become RFC 3339 strings.
+func CBORToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := cborDecoder.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid CBOR: %w", err)
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CBOR: %w", err)
+	}
+	return body, nil
+}
@@ -0,0 +1,151 @@
+package codec
+
+import (
+	"edgefleet-commander/internal/models"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DecodeReading decodes a Reading message from telemetry.proto
+func DecodeReading(data []byte) (models.Telemetry, error) {
+	var t models.Telemetry
+	err := walk(data, func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			t.DeviceID = int(uint32(v))
+		case num >= 2 && num <= 6 && typ == protowire.Fixed64Type:
+			value := math.Float64frombits(v)
+			switch num {
+			case 2:
+				t.BatteryLevel = value
+			case 3:
+				t.Temperature = value
+			case 4:
+				t.CPUUsage = value
+			case 5:
+				t.MemoryUsage = value
+			case 6:
+				t.MemoryTotal = value
+			}
+		case num == 7 && typ == protowire.VarintType:
+			if ms := int64(v); ms != 0 {
+				t.Timestamp = time.UnixMilli(ms).UTC()
+			}
+		case num == 8 && typ == protowire.BytesType:
+			name, metric, err := decodeMetricEntry(raw)
+			if err != nil {
+				return err
+			}
+			if t.Metrics == nil {
+				t.Metrics = models.Metrics{}
+			}
+			t.Metrics[name] = metric
+		case num == 9 && typ == protowire.BytesType:
+			t.MessageID = string(raw)
+		case num <= 9:
+			return fmt.Errorf("field %d has wrong wire type", num)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Telemetry{}, fmt.Errorf("invalid reading: %w", err)
+	}
+	return t, nil
+}
+
+// ErrTooManyReadings is returned by DecodeReadingBatch for batches over the
+// limit
+var ErrTooManyReadings = errors.New("batch exceeds the maximum number of readings")
+
+// DecodeReadingBatch splits a ReadingBatch into its readings. A reading that
+// doesn't decode gets an error in its slot; the batch only fails if its own
+// framing is broken.
+func DecodeReadingBatch(data []byte, maxItems int) ([]models.Telemetry, []error, error) {
+	var batch []models.Telemetry
+	var itemErrs []error
+	err := walk(data, func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		if len(batch) >= maxItems {
+			return ErrTooManyReadings
+		}
+		t, err := DecodeReading(raw)
+		batch = append(batch, t)
+		itemErrs = append(itemErrs, err)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrTooManyReadings) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("invalid protobuf batch: %w", err)
+	}
+	return batch, itemErrs, nil
+}
+
+// decodeMetricEntry decodes one entry of the metrics map
+func decodeMetricEntry(data []byte) (string, models.Metric, error) {
+	var name string
+	var metric models.Metric
+	err := walk(data, func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			name = string(raw)
+		case num == 2 && typ == protowire.BytesType:
+			return walk(raw, func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					metric.Value = math.Float64frombits(v)
+				case num == 2 && typ == protowire.BytesType:
+					metric.Unit = string(raw)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return name, metric, err
+}
+
+// walk calls fn for each field of a protobuf message. Varint and fixed
+// values are passed in v; length-delimited values in raw.
+func walk(data []byte, fn func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var raw []byte
+		var v uint64
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(data)
+			v = uint64(v32)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			raw, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, raw, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
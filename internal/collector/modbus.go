@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"edgefleet-commander/internal/modbus"
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"fmt"
+	"time"
+)
+
+// ModbusOptions tunes the Modbus poller
+type ModbusOptions struct {
+	// Owner identifies this replica when claiming polls
+	Owner   string
+	Timeout time.Duration
+	// FailureThreshold is how many polls in a row must fail before an alert
+	// is raised
+	FailureThreshold int
+}
+
+// ModbusPoller reads the register maps of devices with a Modbus config
+type ModbusPoller struct {
+	poller
+	modbus  *services.ModbusService
+	options ModbusOptions
+}
+
+func NewModbusPoller(modbusService *services.ModbusService, telemetry *services.TelemetryService, alerts *services.AlertService, options ModbusOptions) *ModbusPoller {
+	p := &ModbusPoller{modbus: modbusService, options: options}
+	p.poller = poller{
+		protocol:         "Modbus",
+		owner:            options.Owner,
+		failureThreshold: options.FailureThreshold,
+		load:             p.loadTargets,
+		recorder:         modbusService,
+		telemetry:        telemetry,
+		alerts:           alerts,
+	}
+	return p
+}
+
+func (p *ModbusPoller) loadTargets() ([]target, error) {
+	configs, err := p.modbus.GetAllConfigs()
+	if err != nil {
+		return nil, err
+	}
+	targets := []target{}
+	for _, config := range configs {
+		if !config.Enabled {
+			continue
+		}
+		config := config
+		targets = append(targets, target{
+			deviceID: config.DeviceID,
+			host:     config.Host,
+			interval: time.Duration(config.IntervalSeconds) * time.Second,
+			read:     func() (*models.Telemetry, error) { return p.read(config) },
+		})
+	}
+	return targets, nil
+}
+
+// read connects to the device and reads every mapped register into a reading
+func (p *ModbusPoller) read(config models.ModbusConfig) (*models.Telemetry, error) {
+	client, err := modbus.Dial(config.Host, byte(config.UnitID), p.options.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	reading := &models.Telemetry{DeviceID: config.DeviceID}
+	for _, register := range config.Registers {
+		value, err := readRegister(client, register)
+		if err != nil {
+			return nil, fmt.Errorf("%s (address %d): %w", register.Metric, register.Address, err)
+		}
+		scale := register.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		services.SetMetric(reading, register.Metric, value*scale+register.Offset, register.Unit)
+	}
+	return reading, nil
+}
+
+func readRegister(client *modbus.Client, register models.ModbusRegister) (float64, error) {
+	switch register.Table {
+	case "coil", "discrete":
+		function := byte(modbus.ReadCoils)
+		if register.Table == "discrete" {
+			function = modbus.ReadDiscreteInputs
+		}
+		bits, err := client.ReadBits(function, register.Address, 1)
+		if err != nil {
+			return 0, err
+		}
+		if bits[0] {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	function := byte(modbus.ReadHoldingRegisters)
+	if register.Table == "input" {
+		function = modbus.ReadInputRegisters
+	}
+	registers, err := client.ReadRegisters(function, register.Address, modbus.RegisterCount(register.Type))
+	if err != nil {
+		return 0, err
+	}
+	return modbus.DecodeRegisters(registers, register.Type, register.WordOrder)
+}
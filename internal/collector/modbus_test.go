@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"context"
+	"edgefleet-commander/internal/models"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startModbusServer serves holding registers on a local port. While failing
+// is set every request gets a server device failure exception.
+func startModbusServer(t *testing.T, holding []uint16, failing *atomic.Bool) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request := make([]byte, 12)
+				for {
+					if _, err := io.ReadFull(conn, request); err != nil {
+						return
+					}
+					function := request[7]
+					address := int(binary.BigEndian.Uint16(request[8:]))
+					quantity := int(binary.BigEndian.Uint16(request[10:]))
+					pdu := []byte{function | 0x80, 0x04}
+					if !failing.Load() && function == 0x03 && address+quantity <= len(holding) {
+						pdu = []byte{function, byte(quantity * 2)}
+						for _, register := range holding[address : address+quantity] {
+							pdu = binary.BigEndian.AppendUint16(pdu, register)
+						}
+					}
+					response := make([]byte, 7)
+					copy(response, request[:2])
+					binary.BigEndian.PutUint16(response[4:], uint16(len(pdu)+1))
+					response[6] = request[6]
+					if _, err := conn.Write(append(response, pdu...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+type fakeRecorder struct {
+	status models.PollStatus
+}
+
+func (r *fakeRecorder) ClaimPoll(deviceID int, owner string, interval time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (r *fakeRecorder) RecordSuccess(deviceID int, at time.Time) (*models.PollStatus, error) {
+	r.status.ConsecutiveFailures = 0
+	r.status.LastError = ""
+	status := r.status
+	return &status, nil
+}
+
+func (r *fakeRecorder) RecordFailure(deviceID int, at time.Time, pollErr error) (*models.PollStatus, error) {
+	r.status.ConsecutiveFailures++
+	r.status.LastError = pollErr.Error()
+	status := r.status
+	return &status, nil
+}
+
+func (r *fakeRecorder) SetAlert(deviceID int, alertID *int) error {
+	r.status.AlertID = alertID
+	return nil
+}
+
+type fakeTelemetry struct {
+	readings []models.Telemetry
+}
+
+func (f *fakeTelemetry) CreateTelemetry(telemetry *models.Telemetry) error {
+	f.readings = append(f.readings, *telemetry)
+	return nil
+}
+
+type fakeAlerts struct {
+	created  []models.Alert
+	resolved []uint
+}
+
+func (f *fakeAlerts) CreateAlert(alert *models.Alert) error {
+	alert.ID = len(f.created) + 1
+	f.created = append(f.created, *alert)
+	return nil
+}
+
+func (f *fakeAlerts) ResolveAlert(id uint) error {
+	f.resolved = append(f.resolved, id)
+	return nil
+}
+
+func TestModbusPollerFailureAlert(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	addr := startModbusServer(t, []uint16{0xFFF6}, &failing)
+
+	config := models.ModbusConfig{
+		DeviceID: 7,
+		Host:     addr,
+		UnitID:   1,
+		Registers: []models.ModbusRegister{
+			{Metric: "pressure_kpa", Table: "holding", Address: 0, Type: "int16", Scale: 0.5, Unit: "kPa"},
+		},
+	}
+	modbusPoller := &ModbusPoller{options: ModbusOptions{Timeout: time.Second}}
+	recorder := &fakeRecorder{}
+	telemetry := &fakeTelemetry{}
+	alerts := &fakeAlerts{}
+	p := &poller{
+		protocol:         "Modbus",
+		failureThreshold: 3,
+		recorder:         recorder,
+		telemetry:        telemetry,
+		alerts:           alerts,
+	}
+	target := target{
+		deviceID: config.DeviceID,
+		host:     config.Host,
+		interval: time.Second,
+		read:     func() (*models.Telemetry, error) { return modbusPoller.read(config) },
+	}
+
+	for i := 1; i <= 2; i++ {
+		p.poll(context.Background(), target)
+		if len(alerts.created) != 0 {
+			t.Fatalf("alert raised after %d failures, threshold is 3", i)
+		}
+	}
+	p.poll(context.Background(), target)
+	if len(alerts.created) != 1 {
+		t.Fatalf("%d alerts raised after 3 failures, want 1", len(alerts.created))
+	}
+	alert := alerts.created[0]
+	if alert.DeviceID != 7 || alert.Type != "Poll Failure" || !strings.Contains(alert.Message, "server device failure") {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+	if recorder.status.AlertID == nil || *recorder.status.AlertID != alert.ID {
+		t.Errorf("alert ID not recorded: %v", recorder.status.AlertID)
+	}
+
+	// Further failures don't raise another alert
+	p.poll(context.Background(), target)
+	if len(alerts.created) != 1 {
+		t.Errorf("%d alerts raised, want 1", len(alerts.created))
+	}
+
+	// A good poll stores the reading and resolves the alert
+	failing.Store(false)
+	p.poll(context.Background(), target)
+	if len(alerts.resolved) != 1 || alerts.resolved[0] != uint(alert.ID) {
+		t.Errorf("resolved alerts = %v, want [%d]", alerts.resolved, alert.ID)
+	}
+	if recorder.status.AlertID != nil {
+		t.Error("alert ID still recorded after recovery")
+	}
+	if len(telemetry.readings) != 1 {
+		t.Fatalf("%d readings stored, want 1", len(telemetry.readings))
+	}
+	metric := telemetry.readings[0].Metrics["pressure_kpa"]
+	if metric.Value != -5 || metric.Unit != "kPa" {
+		t.Errorf("pressure_kpa = %+v, want -5 kPa", metric)
+	}
+}
@@ -0,0 +1,195 @@
+// Package collector polls devices that can't push telemetry themselves and
+// stores what it reads through the telemetry service.
+package collector
+
+import (
+	"context"
+	"edgefleet-commander/internal/models"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// configRefresh is how often pollers pick up changed configs
+const configRefresh = 10 * time.Second
+
+// pollRecorder is where a poller claims polls and records their outcome
+type pollRecorder interface {
+	ClaimPoll(deviceID int, owner string, interval time.Duration) (bool, error)
+	RecordSuccess(deviceID int, at time.Time) (*models.PollStatus, error)
+	RecordFailure(deviceID int, at time.Time, pollErr error) (*models.PollStatus, error)
+	SetAlert(deviceID int, alertID *int) error
+}
+
+// telemetryStore is where a poller stores its readings
+type telemetryStore interface {
+	CreateTelemetry(telemetry *models.Telemetry) error
+}
+
+// alertStore is where a poller raises and resolves "Poll Failure" alerts
+type alertStore interface {
+	CreateAlert(alert *models.Alert) error
+	ResolveAlert(id uint) error
+}
+
+// target is one device a poller reads on a schedule
+type target struct {
+	deviceID int
+	host     string
+	interval time.Duration
+	read     func() (*models.Telemetry, error)
+}
+
+// poller runs the schedule shared by the protocol pollers: each target is
+// read once per interval across replicas, readings are stored as telemetry,
+// and a run of failures raises a "Poll Failure" alert that the next good
+// poll resolves
+type poller struct {
+	protocol         string
+	owner            string
+	failureThreshold int
+	load             func() ([]target, error)
+	recorder         pollRecorder
+	telemetry        telemetryStore
+	alerts           alertStore
+
+	mu       sync.Mutex
+	targets  map[int]target
+	nextPoll map[int]time.Time
+	polling  map[int]bool
+}
+
+// Run polls each target at its interval until ctx is done
+func (p *poller) Run(ctx context.Context) {
+	p.targets = map[int]target{}
+	p.nextPoll = map[int]time.Time{}
+	p.polling = map[int]bool{}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastRefresh time.Time
+	for {
+		now := time.Now()
+		if now.Sub(lastRefresh) >= configRefresh {
+			p.refresh()
+			lastRefresh = now
+		}
+		p.startDuePolls(ctx, now)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *poller) refresh() {
+	targets, err := p.load()
+	if err != nil {
+		log.Printf("%s: failed to load configs: %v", p.protocol, err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.targets = make(map[int]target, len(targets))
+	for _, t := range targets {
+		p.targets[t.deviceID] = t
+	}
+	for deviceID := range p.nextPoll {
+		if _, ok := p.targets[deviceID]; !ok {
+			delete(p.nextPoll, deviceID)
+		}
+	}
+}
+
+func (p *poller) startDuePolls(ctx context.Context, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for deviceID, t := range p.targets {
+		if p.polling[deviceID] || now.Before(p.nextPoll[deviceID]) {
+			continue
+		}
+		p.nextPoll[deviceID] = now.Add(t.interval)
+		p.polling[deviceID] = true
+		go func(t target) {
+			defer func() {
+				p.mu.Lock()
+				delete(p.polling, t.deviceID)
+				p.mu.Unlock()
+			}()
+			p.poll(ctx, t)
+		}(t)
+	}
+}
+
+// poll reads one device, unless another replica has already claimed this
+// interval's poll, and records the outcome
+func (p *poller) poll(ctx context.Context, t target) {
+	// The claim lapses a little before the next poll is due so that timer
+	// jitter doesn't make replicas skip an interval
+	claimed, err := p.recorder.ClaimPoll(t.deviceID, p.owner, t.interval*9/10)
+	if err != nil {
+		log.Printf("%s: %v", p.protocol, err)
+		return
+	}
+	if !claimed || ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	reading, err := t.read()
+	if err == nil {
+		reading.DeviceID = t.deviceID
+		reading.Timestamp = now
+		err = p.telemetry.CreateTelemetry(reading)
+		if err != nil {
+			err = fmt.Errorf("reading rejected: %w", err)
+		}
+	}
+
+	if err != nil {
+		status, recordErr := p.recorder.RecordFailure(t.deviceID, now, err)
+		if recordErr != nil {
+			log.Printf("%s: %v", p.protocol, recordErr)
+			return
+		}
+		if status.ConsecutiveFailures >= p.failureThreshold && status.AlertID == nil {
+			p.raiseAlert(t, status)
+		}
+		return
+	}
+
+	status, err := p.recorder.RecordSuccess(t.deviceID, now)
+	if err != nil {
+		log.Printf("%s: %v", p.protocol, err)
+		return
+	}
+	if status.AlertID != nil {
+		if err := p.alerts.ResolveAlert(uint(*status.AlertID)); err != nil && err.Error() != "alert not found" {
+			log.Printf("%s: failed to resolve alert %d: %v", p.protocol, *status.AlertID, err)
+		}
+		if err := p.recorder.SetAlert(t.deviceID, nil); err != nil {
+			log.Printf("%s: %v", p.protocol, err)
+		}
+	}
+}
+
+func (p *poller) raiseAlert(t target, status *models.PollStatus) {
+	alert := &models.Alert{
+		DeviceID: t.deviceID,
+		Type:     "Poll Failure",
+		Message:  fmt.Sprintf("%s poll of %s failed %d times in a row: %s", p.protocol, t.host, status.ConsecutiveFailures, status.LastError),
+		Severity: "warning",
+	}
+	if err := p.alerts.CreateAlert(alert); err != nil {
+		log.Printf("%s: failed to raise alert for device %d: %v", p.protocol, t.deviceID, err)
+		return
+	}
+	if err := p.recorder.SetAlert(t.deviceID, &alert.ID); err != nil {
+		log.Printf("%s: %v", p.protocol, err)
+	}
+}
@@ -0,0 +1,204 @@
+package collector
+
+import (
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// SNMPOptions tunes the SNMP poller
+type SNMPOptions struct {
+	// Owner identifies this replica when claiming polls
+	Owner   string
+	Timeout time.Duration
+	// FailureThreshold is how many polls in a row must fail before an alert
+	// is raised
+	FailureThreshold int
+}
+
+// SNMPPoller reads the mapped OIDs of devices with an SNMP config
+type SNMPPoller struct {
+	poller
+	snmp    *services.SNMPService
+	options SNMPOptions
+}
+
+func NewSNMPPoller(snmpService *services.SNMPService, telemetry *services.TelemetryService, alerts *services.AlertService, options SNMPOptions) *SNMPPoller {
+	p := &SNMPPoller{snmp: snmpService, options: options}
+	p.poller = poller{
+		protocol:         "SNMP",
+		owner:            options.Owner,
+		failureThreshold: options.FailureThreshold,
+		load:             p.loadTargets,
+		recorder:         snmpService,
+		telemetry:        telemetry,
+		alerts:           alerts,
+	}
+	return p
+}
+
+func (p *SNMPPoller) loadTargets() ([]target, error) {
+	configs, err := p.snmp.GetAllConfigs()
+	if err != nil {
+		return nil, err
+	}
+	targets := []target{}
+	for _, config := range configs {
+		if !config.Enabled {
+			continue
+		}
+		config := config
+		targets = append(targets, target{
+			deviceID: config.DeviceID,
+			host:     config.Host,
+			interval: time.Duration(config.IntervalSeconds) * time.Second,
+			read:     func() (*models.Telemetry, error) { return p.read(config) },
+		})
+	}
+	return targets, nil
+}
+
+// read GETs every mapped OID, as many per request as the agent allows, into
+// a reading
+func (p *SNMPPoller) read(config models.SNMPConfig) (*models.Telemetry, error) {
+	client, err := snmpClient(config)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = p.options.Timeout
+	client.Retries = 1
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	defer client.Conn.Close()
+
+	reading := &models.Telemetry{DeviceID: config.DeviceID}
+	for start := 0; start < len(config.OIDs); start += client.MaxOids {
+		mappings := config.OIDs[start:min(start+client.MaxOids, len(config.OIDs))]
+		oids := make([]string, len(mappings))
+		for i, m := range mappings {
+			oids[i] = m.OID
+		}
+		result, err := client.Get(oids)
+		if err != nil {
+			return nil, err
+		}
+		if result.Error != gosnmp.NoError {
+			return nil, fmt.Errorf("agent returned %v", result.Error)
+		}
+
+		values := make(map[string]gosnmp.SnmpPDU, len(result.Variables))
+		for _, v := range result.Variables {
+			values[strings.TrimPrefix(v.Name, ".")] = v
+		}
+		for _, m := range mappings {
+			v, ok := values[m.OID]
+			if !ok {
+				return nil, fmt.Errorf("%s (%s): missing from response", m.Metric, m.OID)
+			}
+			value, err := numericValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s (%s): %w", m.Metric, m.OID, err)
+			}
+			scale := m.Scale
+			if scale == 0 {
+				scale = 1
+			}
+			services.SetMetric(reading, m.Metric, value*scale+m.Offset, m.Unit)
+		}
+	}
+	return reading, nil
+}
+
+// numericValue converts a variable to a metric value. Octet strings are
+// accepted when they hold a number, as some agents report sensors that way.
+func numericValue(v gosnmp.SnmpPDU) (float64, error) {
+	switch v.Type {
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32:
+		value, _ := new(big.Float).SetInt(gosnmp.ToBigInt(v.Value)).Float64()
+		return value, nil
+	case gosnmp.OpaqueFloat:
+		return float64(v.Value.(float32)), nil
+	case gosnmp.OpaqueDouble:
+		return v.Value.(float64), nil
+	case gosnmp.OctetString:
+		value, err := strconv.ParseFloat(strings.TrimSpace(string(v.Value.([]byte))), 64)
+		if err != nil {
+			return 0, fmt.Errorf("string value is not a number")
+		}
+		return value, nil
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return 0, fmt.Errorf("no such object")
+	default:
+		return 0, fmt.Errorf("unsupported value type %v", v.Type)
+	}
+}
+
+// snmpClient sets up a client for the config's agent and credentials
+func snmpClient(config models.SNMPConfig) (*gosnmp.GoSNMP, error) {
+	host, portStr, err := net.SplitHostPort(config.Host)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+
+	client := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(port),
+		Transport: "udp",
+		MaxOids:   gosnmp.MaxOids,
+	}
+	switch config.Version {
+	case "2c":
+		client.Version = gosnmp.Version2c
+		client.Community = config.Community
+	case "3":
+		if config.User == nil {
+			return nil, fmt.Errorf("SNMP v3 config has no user")
+		}
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags, client.SecurityParameters = usmParameters(*config.User)
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version %q", config.Version)
+	}
+	return client, nil
+}
+
+func usmParameters(user models.SNMPUser) (gosnmp.SnmpV3MsgFlags, *gosnmp.UsmSecurityParameters) {
+	params := &gosnmp.UsmSecurityParameters{UserName: user.Username}
+	flags := gosnmp.NoAuthNoPriv
+	if user.SecurityLevel == "authNoPriv" || user.SecurityLevel == "authPriv" {
+		flags = gosnmp.AuthNoPriv
+		params.AuthenticationPassphrase = user.AuthPassphrase
+		params.AuthenticationProtocol = map[string]gosnmp.SnmpV3AuthProtocol{
+			"MD5":    gosnmp.MD5,
+			"SHA":    gosnmp.SHA,
+			"SHA224": gosnmp.SHA224,
+			"SHA256": gosnmp.SHA256,
+			"SHA384": gosnmp.SHA384,
+			"SHA512": gosnmp.SHA512,
+		}[user.AuthProtocol]
+	}
+	if user.SecurityLevel == "authPriv" {
+		flags = gosnmp.AuthPriv
+		params.PrivacyPassphrase = user.PrivPassphrase
+		params.PrivacyProtocol = map[string]gosnmp.SnmpV3PrivProtocol{
+			"DES":    gosnmp.DES,
+			"AES":    gosnmp.AES,
+			"AES192": gosnmp.AES192,
+			"AES256": gosnmp.AES256,
+		}[user.PrivProtocol]
+	}
+	return flags, params
+}
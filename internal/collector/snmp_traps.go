@@ -0,0 +1,263 @@
+package collector
+
+import (
+	"context"
+	"crypto/subtle"
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// snmpTrapOID is the varbind that carries a notification's identity
+const snmpTrapOID = "1.3.6.1.6.3.1.1.4.1.0"
+
+// sysUpTime is the first varbind of every notification
+const sysUpTime = "1.3.6.1.2.1.1.3.0"
+
+// maxTrapVarbinds caps how many varbinds are quoted in an alert message
+const maxTrapVarbinds = 8
+
+// standardTraps classifies the generic notifications of SNMPv2-MIB and
+// IF-MIB when a config has no rule for them
+var standardTraps = []models.SNMPTrapRule{
+	{OID: "1.3.6.1.6.3.1.1.5.1", Type: "Cold Start", Severity: "info"},
+	{OID: "1.3.6.1.6.3.1.1.5.2", Type: "Warm Start", Severity: "info"},
+	{OID: "1.3.6.1.6.3.1.1.5.3", Type: "Link Down", Severity: "warning"},
+	{OID: "1.3.6.1.6.3.1.1.5.4", Type: "Link Up", Severity: "info"},
+	{OID: "1.3.6.1.6.3.1.1.5.5", Type: "SNMP Authentication Failure", Severity: "warning"},
+}
+
+// TrapReceiver turns SNMP v2c and v3 notifications into alerts. A trap is
+// attributed to the device whose SNMP config has the sender's address, and
+// must carry that config's community or v3 user. Informs are acknowledged.
+type TrapReceiver struct {
+	conn   *net.UDPConn
+	snmp   *services.SNMPService
+	alerts *services.AlertService
+
+	mu          sync.Mutex
+	sources     map[string][]models.SNMPConfig
+	lastRefresh time.Time
+}
+
+// ListenTraps binds the trap port. Call Run to start receiving.
+func ListenTraps(addr string, snmpService *services.SNMPService, alerts *services.AlertService) (*TrapReceiver, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &TrapReceiver{conn: conn, snmp: snmpService, alerts: alerts}, nil
+}
+
+// Run receives traps until ctx is done
+func (r *TrapReceiver) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		r.conn.Close()
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("SNMP: trap receive failed: %v", err)
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		r.handle(data, from)
+	}
+}
+
+func (r *TrapReceiver) handle(data []byte, from *net.UDPAddr) {
+	configs := r.configsFor(from.IP)
+	if len(configs) == 0 {
+		log.Printf("SNMP: dropped trap from %s, which is not a configured device", from.IP)
+		return
+	}
+
+	for _, config := range configs {
+		packet, err := decodeTrap(data, config)
+		if err != nil {
+			continue
+		}
+		r.raiseAlert(config, packet, from)
+		if packet.PDUType == gosnmp.InformRequest {
+			r.acknowledge(packet, from)
+		}
+		return
+	}
+	log.Printf("SNMP: dropped trap from %s that failed authentication", from.IP)
+}
+
+// configsFor returns the configs whose agent is at ip, reloading them when
+// the cached set is stale
+func (r *TrapReceiver) configsFor(ip net.IP) []models.SNMPConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.lastRefresh) >= configRefresh {
+		r.refresh()
+	}
+	return r.sources[ip.String()]
+}
+
+func (r *TrapReceiver) refresh() {
+	configs, err := r.snmp.GetAllConfigs()
+	if err != nil {
+		log.Printf("SNMP: failed to load configs: %v", err)
+		return
+	}
+	r.lastRefresh = time.Now()
+	r.sources = map[string][]models.SNMPConfig{}
+	for _, config := range configs {
+		host, _, err := net.SplitHostPort(config.Host)
+		if err != nil {
+			continue
+		}
+		addrs, err := net.LookupIP(host)
+		if err != nil {
+			log.Printf("SNMP: cannot resolve %s for device %d: %v", host, config.DeviceID, err)
+			continue
+		}
+		for _, addr := range addrs {
+			r.sources[addr.String()] = append(r.sources[addr.String()], config)
+		}
+	}
+}
+
+// decodeTrap parses a notification with the config's credentials and checks
+// it was sent with them
+func decodeTrap(data []byte, config models.SNMPConfig) (*gosnmp.SnmpPacket, error) {
+	client, err := snmpClient(config)
+	if err != nil {
+		return nil, err
+	}
+	// The agent is the authoritative engine for traps, so keys are localised
+	// to the engine ID in each message rather than to ours
+	packet, err := client.UnmarshalTrap(data, true)
+	if err != nil {
+		return nil, err
+	}
+	if packet.Version != client.Version {
+		return nil, fmt.Errorf("trap is SNMP %v, config is %v", packet.Version, client.Version)
+	}
+	if packet.PDUType != gosnmp.SNMPv2Trap && packet.PDUType != gosnmp.InformRequest {
+		return nil, fmt.Errorf("not a notification")
+	}
+
+	switch packet.Version {
+	case gosnmp.Version2c:
+		if subtle.ConstantTimeCompare([]byte(packet.Community), []byte(config.Community)) != 1 {
+			return nil, fmt.Errorf("wrong community")
+		}
+	case gosnmp.Version3:
+		usm, ok := packet.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+		if !ok || usm.UserName != config.User.Username {
+			return nil, fmt.Errorf("unknown user")
+		}
+		// The message was verified at the level it declares, which must be
+		// at least the one configured
+		if packet.MsgFlags&gosnmp.AuthPriv < client.MsgFlags&gosnmp.AuthPriv {
+			return nil, fmt.Errorf("trap security level below configured level")
+		}
+	}
+	return packet, nil
+}
+
+func (r *TrapReceiver) raiseAlert(config models.SNMPConfig, packet *gosnmp.SnmpPacket, from *net.UDPAddr) {
+	var trapOID string
+	var details []string
+	for _, v := range packet.Variables {
+		name := strings.TrimPrefix(v.Name, ".")
+		switch name {
+		case sysUpTime:
+		case snmpTrapOID:
+			if oid, ok := v.Value.(string); ok {
+				trapOID = strings.TrimPrefix(oid, ".")
+			}
+		default:
+			if len(details) < maxTrapVarbinds {
+				details = append(details, name+"="+formatVarbind(v))
+			}
+		}
+	}
+	if trapOID == "" {
+		log.Printf("SNMP: dropped trap from %s without snmpTrapOID", from.IP)
+		return
+	}
+
+	rule := classifyTrap(config.Traps, trapOID)
+	message := fmt.Sprintf("SNMP trap %s from %s", trapOID, from.IP)
+	if len(details) > 0 {
+		message += ": " + strings.Join(details, ", ")
+	}
+	alert := &models.Alert{
+		DeviceID: config.DeviceID,
+		Type:     rule.Type,
+		Message:  message,
+		Severity: rule.Severity,
+	}
+	if err := r.alerts.CreateAlert(alert); err != nil {
+		log.Printf("SNMP: failed to create alert for trap from device %d: %v", config.DeviceID, err)
+	}
+}
+
+// classifyTrap picks the alert type and severity for a trap: the config's
+// rules first, then the standard notifications, else a generic warning
+func classifyTrap(rules []models.SNMPTrapRule, trapOID string) models.SNMPTrapRule {
+	for _, candidates := range [][]models.SNMPTrapRule{rules, standardTraps} {
+		for _, rule := range candidates {
+			if trapOID == rule.OID || strings.HasPrefix(trapOID, rule.OID+".") {
+				return rule
+			}
+		}
+	}
+	return models.SNMPTrapRule{OID: trapOID, Type: "SNMP Trap", Severity: "warning"}
+}
+
+func formatVarbind(v gosnmp.SnmpPDU) string {
+	switch value := v.Value.(type) {
+	case []byte:
+		if utf8.Valid(value) {
+			return fmt.Sprintf("%q", value)
+		}
+		return fmt.Sprintf("%x", value)
+	case string:
+		return strings.TrimPrefix(value, ".")
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// acknowledge answers an inform so the agent stops retransmitting it
+func (r *TrapReceiver) acknowledge(packet *gosnmp.SnmpPacket, from *net.UDPAddr) {
+	packet.PDUType = gosnmp.GetResponse
+	packet.Error = gosnmp.NoError
+	packet.ErrorIndex = 0
+	response, err := packet.MarshalMsg()
+	if err != nil {
+		log.Printf("SNMP: failed to encode inform response: %v", err)
+		return
+	}
+	if _, err := r.conn.WriteToUDP(response, from); err != nil {
+		log.Printf("SNMP: failed to send inform response to %s: %v", from.IP, err)
+	}
+}
@@ -1,28 +1,107 @@
 package config
 
-import "os"
+import (
+        "fmt"
+        "os"
+        "strconv"
+        "time"
+)
 
 type Config struct {
-        RedisURL      string
-        RedisHost     string
-        RedisPort     string
-        RedisPassword string
-        RedisDB       string
-        Environment   string
-        Port          string
-        SessionSecret string
+        RedisURL        string
+        RedisHost       string
+        RedisPort       string
+        RedisPassword   string
+        RedisDB         string
+        Environment     string
+        Port            string
+        SessionSecret   string
+        CredentialKey   string
+        AdminToken      string
+        IncidentWindow  time.Duration
+        InstanceID      string
+        EventsChannel   string
+        BatchMaxItems   int
+        BatchMaxBytes   int64
+        BulkMaxAlerts   int
+        MaxFutureSkew   time.Duration
+        MaxReadingAge   time.Duration
+        MQTTAddr        string
+        MQTTAdminUser   string
+        MQTTAdminPass   string
+        ModbusTimeout   time.Duration
+        ModbusFailures  int
+        SNMPTimeout     time.Duration
+        SNMPFailures    int
+        SNMPTrapAddr    string
+        CoAPAddr        string
+        CoAPSecureAddr  string
+        InfluxDeviceTag string
+        OTLPDeviceAttr  string
+        DedupeWindow    time.Duration
+        IngestQueueSize int
+        IngestWorkers   int
+        IngestMaxBatch  int
+        IngestLinger    time.Duration
+        IngestTimeout   time.Duration
+        SpoolDir        string
+        SpoolMaxBytes   int64
+        DeviceAuth      string
+        TLSCertFile     string
+        TLSKeyFile      string
+        TLSClientCAFile string
+        TLSClientAuth   string
+        DeviceIDPattern string
+        SignatureSkew   time.Duration
 }
 
 func Load() *Config {
         return &Config{
-                RedisURL:      getEnv("REDIS_URL", ""),
-                RedisHost:     getEnv("REDIS_HOST", "localhost"),
-                RedisPort:     getEnv("REDIS_PORT", "6379"),
-                RedisPassword: getEnv("REDIS_PASSWORD", ""),
-                RedisDB:       getEnv("REDIS_DB", "0"),
-                Environment:   getEnv("NODE_ENV", "development"),
-                Port:          getEnv("PORT", "8080"),
-                SessionSecret: getEnv("SESSION_SECRET", "change-this-secret"),
+                RedisURL:        getEnv("REDIS_URL", ""),
+                RedisHost:       getEnv("REDIS_HOST", "localhost"),
+                RedisPort:       getEnv("REDIS_PORT", "6379"),
+                RedisPassword:   getEnv("REDIS_PASSWORD", ""),
+                RedisDB:         getEnv("REDIS_DB", "0"),
+                Environment:     getEnv("NODE_ENV", "development"),
+                Port:            getEnv("PORT", "8080"),
+                SessionSecret:   getEnv("SESSION_SECRET", "change-this-secret"),
+                CredentialKey:   getEnv("CREDENTIAL_KEY", os.Getenv("SESSION_SECRET")),
+                AdminToken:      getEnv("ADMIN_TOKEN", ""),
+                IncidentWindow:  getEnvDuration("INCIDENT_CORRELATION_WINDOW", 10*time.Minute),
+                InstanceID:      getEnv("INSTANCE_ID", defaultInstanceID()),
+                EventsChannel:   getEnv("EVENTS_CHANNEL", "edgefleet:events"),
+                BatchMaxItems:   getEnvInt("TELEMETRY_BATCH_MAX_ITEMS", 10000),
+                BatchMaxBytes:   int64(getEnvInt("TELEMETRY_BATCH_MAX_BYTES", 16<<20)),
+                BulkMaxAlerts:   getEnvInt("ALERT_BULK_MAX_ITEMS", 1000),
+                MaxFutureSkew:   getEnvDuration("TELEMETRY_MAX_FUTURE_SKEW", 5*time.Minute),
+                MaxReadingAge:   getEnvDuration("TELEMETRY_MAX_AGE", 7*24*time.Hour),
+                MQTTAddr:        getEnv("MQTT_ADDR", ""),
+                MQTTAdminUser:   getEnv("MQTT_ADMIN_USERNAME", ""),
+                MQTTAdminPass:   getEnv("MQTT_ADMIN_PASSWORD", ""),
+                ModbusTimeout:   getEnvDuration("MODBUS_TIMEOUT", 3*time.Second),
+                ModbusFailures:  getEnvInt("MODBUS_FAILURE_THRESHOLD", 3),
+                SNMPTimeout:     getEnvDuration("SNMP_TIMEOUT", 3*time.Second),
+                SNMPFailures:    getEnvInt("SNMP_FAILURE_THRESHOLD", 3),
+                SNMPTrapAddr:    getEnv("SNMP_TRAP_ADDR", ""),
+                CoAPAddr:        getEnv("COAP_ADDR", ""),
+                CoAPSecureAddr:  getEnv("COAPS_ADDR", ""),
+                InfluxDeviceTag: getEnv("INFLUX_DEVICE_TAG", "device"),
+                OTLPDeviceAttr:  getEnv("OTLP_DEVICE_ATTRIBUTE", "device.id"),
+                DedupeWindow:    getEnvDuration("IDEMPOTENCY_WINDOW", 24*time.Hour),
+                IngestQueueSize: getEnvInt("INGEST_QUEUE_SIZE", 50000),
+                IngestWorkers:   getEnvInt("INGEST_WORKERS", 4),
+                IngestMaxBatch:  getEnvInt("INGEST_MAX_BATCH", 500),
+                IngestLinger:    getEnvDuration("INGEST_LINGER", 0),
+                IngestTimeout:   getEnvDuration("INGEST_TIMEOUT", 10*time.Second),
+                SpoolDir:        getEnv("SPOOL_DIR", "data/spool"),
+                SpoolMaxBytes:   int64(getEnvInt("SPOOL_MAX_BYTES", 1<<30)),
+                DeviceAuth:      getEnv("DEVICE_AUTH", "required"),
+                TLSCertFile:     getEnv("TLS_CERT_FILE", ""),
+                TLSKeyFile:      getEnv("TLS_KEY_FILE", ""),
+                TLSClientCAFile: getEnv("TLS_CLIENT_CA_FILE", ""),
+                TLSClientAuth:   getEnv("TLS_CLIENT_AUTH", "optional"),
+                DeviceIDPattern: getEnv("MTLS_DEVICE_ID_PATTERN", `^(?:urn:edgefleet:device:|device-)(\d+)$`),
+                SignatureSkew:   getEnvDuration("SIGNATURE_MAX_SKEW", 5*time.Minute),
         }
 }
 
@@ -31,4 +110,32 @@ func getEnv(key, defaultValue string) string {
                 return value
         }
         return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvInt(key string, defaultValue int) int {
+        if value := os.Getenv(key); value != "" {
+                if n, err := strconv.Atoi(value); err == nil {
+                        return n
+                }
+        }
+        return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+        if value := os.Getenv(key); value != "" {
+                if d, err := time.ParseDuration(value); err == nil {
+                        return d
+                }
+        }
+        return defaultValue
+}
+
+// defaultInstanceID names this replica after its host and process so events
+// from different replicas can be told apart
+func defaultInstanceID() string {
+        hostname, err := os.Hostname()
+        if err != nil {
+                hostname = "edgefleet"
+        }
+        return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -9,6 +9,7 @@ import (
         "log"
         "math/rand"
         "strconv"
+        "strings"
         "time"
 
         "github.com/go-redis/redis/v8"
@@ -64,6 +65,10 @@ func Initialize(cfg *config.Config) (*RedisClient, error) {
                 log.Printf("Warning: Failed to seed initial data: %v", err)
         }
 
+        if err := redisClient.migrateTelemetryIndexes(); err != nil {
+                log.Printf("Warning: Failed to migrate telemetry indexes: %v", err)
+        }
+
         return redisClient, nil
 }
 
@@ -87,6 +92,9 @@ func (r *RedisClient) seedInitialData() error {
 
         log.Println("Seeding Redis with IoT device data...")
 
+        // Sensors in Building A reach the network through the smart gateway
+        gatewayID := 6
+
         // Create sample devices
         devices := []models.Device{
                 {
@@ -96,6 +104,7 @@ func (r *RedisClient) seedInitialData() error {
                         Location:     "Building A - Floor 2",
                         Status:       "online",
                         RegisteredAt: time.Now().Add(-72 * time.Hour),
+                        ParentID:     &gatewayID,
                 },
                 {
                         ID:           2,
@@ -112,6 +121,7 @@ func (r *RedisClient) seedInitialData() error {
                         Location:     "Building A - Basement",
                         Status:       "warning",
                         RegisteredAt: time.Now().Add(-24 * time.Hour),
+                        ParentID:     &gatewayID,
                 },
                 {
                         ID:           4,
@@ -170,6 +180,8 @@ func (r *RedisClient) seedInitialData() error {
                                 MemoryUsage:  1024 + rand.Float64()*6144,
                                 MemoryTotal:  8192,
                                 Timestamp:    timestamp,
+                                ReceivedAt:   timestamp,
+                                Metrics:      sampleMetrics(device),
                         }
 
                         telemetryJSON, err := json.Marshal(telemetry)
@@ -178,8 +190,12 @@ func (r *RedisClient) seedInitialData() error {
                         }
 
                         r.client.HSet(r.ctx, fmt.Sprintf("telemetry:%d", telemetryID), "data", telemetryJSON)
-                        r.client.LPush(r.ctx, fmt.Sprintf("device:%d:telemetry", device.ID), telemetryID)
+                        r.client.ZAdd(r.ctx, fmt.Sprintf("device:%d:telemetry", device.ID), &redis.Z{Score: float64(timestamp.UnixMilli()), Member: telemetryID})
                         r.client.SAdd(r.ctx, "telemetry:all", telemetryID)
+                        for name := range telemetry.Metrics {
+                                r.client.ZAdd(r.ctx, fmt.Sprintf("device:%d:metric:%s", device.ID, name), &redis.Z{Score: float64(timestamp.UnixMilli()), Member: telemetryID})
+                                r.client.SAdd(r.ctx, fmt.Sprintf("device:%d:metrics", device.ID), name)
+                        }
 
                         telemetryID++
                 }
@@ -193,10 +209,11 @@ func (r *RedisClient) seedInitialData() error {
                 MemoryTotal:  8192,
                 Timestamp:    time.Now(),
             }
+            telemetry.ReceivedAt = telemetry.Timestamp
             telemetryJSON, err := json.Marshal(telemetry)
             if err == nil {
                 r.client.HSet(r.ctx, fmt.Sprintf("telemetry:%d", telemetryID), "data", telemetryJSON)
-                r.client.LPush(r.ctx, fmt.Sprintf("device:%d:telemetry", device.ID), telemetryID)
+                r.client.ZAdd(r.ctx, fmt.Sprintf("device:%d:telemetry", device.ID), &redis.Z{Score: float64(telemetry.Timestamp.UnixMilli()), Member: telemetryID})
                 r.client.SAdd(r.ctx, "telemetry:all", telemetryID)
                 telemetryID++
             }        
@@ -234,14 +251,97 @@ func (r *RedisClient) seedInitialData() error {
                 r.client.SAdd(r.ctx, "alerts:all", alert.ID)
         }
 
+        // Keep ID counters in step with the seeded records
+        r.client.Set(r.ctx, "devices:next_id", len(devices), 0)
+        r.client.Set(r.ctx, "telemetry:next_id", telemetryID-1, 0)
+        r.client.Set(r.ctx, "alerts:next_id", len(alerts), 0)
+
         log.Println("Redis seeded with sample IoT data")
         return nil
 }
 
+// migrateTelemetryIndexes converts per-device telemetry indexes written by
+// older versions (lists in arrival order) into sorted sets scored by reading
+// timestamp
+func (r *RedisClient) migrateTelemetryIndexes() error {
+        deviceIDs, err := r.client.SMembers(r.ctx, "devices:all").Result()
+        if err != nil {
+                return fmt.Errorf("failed to get device IDs: %w", err)
+        }
+
+        for _, idStr := range deviceIDs {
+                key := fmt.Sprintf("device:%s:telemetry", idStr)
+                keyType, err := r.client.Type(r.ctx, key).Result()
+                if err != nil {
+                        return fmt.Errorf("failed to inspect %s: %w", key, err)
+                }
+                if keyType != "list" {
+                        continue
+                }
+
+                telemetryIDs, err := r.client.LRange(r.ctx, key, 0, -1).Result()
+                if err != nil {
+                        return fmt.Errorf("failed to read %s: %w", key, err)
+                }
+                entries := make([]*redis.Z, 0, len(telemetryIDs))
+                for _, telemetryID := range telemetryIDs {
+                        telemetryData, err := r.client.HGet(r.ctx, fmt.Sprintf("telemetry:%s", telemetryID), "data").Result()
+                        if err != nil {
+                                continue
+                        }
+                        var t models.Telemetry
+                        if err := json.Unmarshal([]byte(telemetryData), &t); err != nil {
+                                continue
+                        }
+                        entries = append(entries, &redis.Z{Score: float64(t.Timestamp.UnixMilli()), Member: telemetryID})
+                }
+
+                tmpKey := key + ":migrating"
+                _, err = r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+                        pipe.Del(r.ctx, tmpKey)
+                        if len(entries) > 0 {
+                                pipe.ZAdd(r.ctx, tmpKey, entries...)
+                                pipe.Rename(r.ctx, tmpKey, key)
+                        } else {
+                                pipe.Del(r.ctx, key)
+                        }
+                        return nil
+                })
+                if err != nil {
+                        return fmt.Errorf("failed to migrate %s: %w", key, err)
+                }
+                log.Printf("Migrated %s to a time-ordered index (%d readings)", key, len(entries))
+        }
+        return nil
+}
+
+// sampleMetrics generates plausible readings for the measurement each seeded
+// device type exists to take
+func sampleMetrics(device models.Device) models.Metrics {
+        switch {
+        case strings.HasPrefix(device.Name, "Pressure Monitor"):
+                return models.Metrics{"pressure_kpa": {Value: 280 + rand.Float64()*60, Unit: "kPa"}}
+        case strings.HasPrefix(device.Name, "Flow Meter"):
+                return models.Metrics{"flow_lpm": {Value: 120 + rand.Float64()*40, Unit: "L/min"}}
+        case strings.HasPrefix(device.Name, "Vibration Sensor"):
+                return models.Metrics{"vibration_rms": {Value: 0.5 + rand.Float64()*3, Unit: "mm/s"}}
+        case strings.HasPrefix(device.Name, "Level Indicator"):
+                return models.Metrics{"level_pct": {Value: 10 + rand.Float64()*85, Unit: "%"}}
+        }
+        return nil
+}
+
 func (r *RedisClient) GetClient() *redis.Client {
         return r.client
 }
 
+// Ping reports whether Redis answers within timeout
+func (r *RedisClient) Ping(timeout time.Duration) error {
+        ctx, cancel := context.WithTimeout(r.ctx, timeout)
+        defer cancel()
+        return r.client.Ping(ctx).Err()
+}
+
 func (r *RedisClient) GetContext() context.Context {
         return r.ctx
 }
@@ -0,0 +1,227 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types published by the services
+const (
+	TelemetryCreated    = "telemetry.created"
+	DeviceCreated       = "device.created"
+	DeviceUpdated       = "device.updated"
+	DeviceDeleted       = "device.deleted"
+	DeviceStatusChanged = "device.status_changed"
+	AlertCreated        = "alert.created"
+	AlertAcknowledged   = "alert.acknowledged"
+	AlertResolved       = "alert.resolved"
+	AlertSnoozed        = "alert.snoozed"
+	AlertDeleted        = "alert.deleted"
+	IncidentCreated     = "incident.created"
+	IncidentUpdated     = "incident.updated"
+	EnrollmentRequested = "enrollment.requested"
+	EnrollmentDecided   = "enrollment.decided"
+)
+
+var knownTypes = map[string]bool{
+	TelemetryCreated:    true,
+	DeviceCreated:       true,
+	DeviceUpdated:       true,
+	DeviceDeleted:       true,
+	DeviceStatusChanged: true,
+	AlertCreated:        true,
+	AlertAcknowledged:   true,
+	AlertResolved:       true,
+	AlertSnoozed:        true,
+	AlertDeleted:        true,
+	IncidentCreated:     true,
+	IncidentUpdated:     true,
+	EnrollmentRequested: true,
+	EnrollmentDecided:   true,
+}
+
+// IsKnownType reports whether eventType is one the services publish
+func IsKnownType(eventType string) bool {
+	return knownTypes[eventType]
+}
+
+// Event is a typed domain event. DeviceID is 0 for events that don't concern
+// a single device. Source names the server instance that published it; IDs
+// are only unique per source.
+type Event struct {
+	ID        uint64      `json:"id"`
+	Source    string      `json:"source,omitempty"`
+	Type      string      `json:"type"`
+	DeviceID  int         `json:"deviceId,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Filter restricts a subscription to some event types and/or devices. An
+// empty set matches everything.
+type Filter struct {
+	Types     []string `json:"types"`
+	DeviceIDs []int    `json:"deviceIds"`
+}
+
+func (f Filter) matches(e Event) bool {
+	if len(f.Types) > 0 && !containsString(f.Types, e.Type) {
+		return false
+	}
+	if len(f.DeviceIDs) > 0 && !containsInt(f.DeviceIDs, e.DeviceID) {
+		return false
+	}
+	return true
+}
+
+// Bus fans events out to in-process subscribers. Publishing never blocks: a
+// subscriber that falls behind loses events rather than stalling ingestion.
+type Bus struct {
+	mu       sync.RWMutex
+	subs     map[*Subscription]struct{}
+	nextID   atomic.Uint64
+	source   string
+	outbound atomic.Pointer[Forwarder]
+}
+
+// Forwarder carries locally published events to other server instances
+type Forwarder interface {
+	Forward(e Event)
+}
+
+// NewBus creates a bus whose events are stamped with source, the name of
+// this server instance.
+func NewBus(source string) *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{}), source: source}
+}
+
+// Source returns the instance name stamped on locally published events
+func (b *Bus) Source() string {
+	return b.source
+}
+
+// SetForwarder sends every locally published event to f as well as to local
+// subscribers
+func (b *Bus) SetForwarder(f Forwarder) {
+	b.outbound.Store(&f)
+}
+
+// Publish wraps data in a new event and delivers it to every matching
+// subscriber. A nil bus discards events.
+func (b *Bus) Publish(eventType string, deviceID int, data interface{}) {
+	if b == nil {
+		return
+	}
+	e := Event{
+		ID:        b.nextID.Add(1),
+		Source:    b.source,
+		Type:      eventType,
+		DeviceID:  deviceID,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	b.Dispatch(e)
+	if f := b.outbound.Load(); f != nil {
+		(*f).Forward(e)
+	}
+}
+
+// Dispatch delivers an already stamped event to local subscribers only. It
+// is how events received from other instances enter the bus.
+func (b *Bus) Dispatch(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if !sub.Filter().matches(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe registers a subscriber with room for buffer pending events
+func (b *Bus) Subscribe(filter Filter, buffer int) *Subscription {
+	sub := &Subscription{bus: b, ch: make(chan Event, buffer), filter: filter}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Subscribers returns the number of active subscriptions
+func (b *Bus) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+type Subscription struct {
+	bus     *Bus
+	ch      chan Event
+	mu      sync.RWMutex
+	filter  Filter
+	dropped atomic.Uint64
+}
+
+// Events is closed when the subscription is closed
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Filter() Filter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filter
+}
+
+// SetFilter replaces the subscription's filter
+func (s *Subscription) SetFilter(filter Filter) {
+	s.mu.Lock()
+	s.filter = filter
+	s.mu.Unlock()
+}
+
+// Dropped reports how many events were discarded because the subscriber's
+// buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// relayBuffer is how many events may wait to be published to Redis
+	relayBuffer = 1024
+	// relayPublishTimeout bounds a single publish to Redis
+	relayPublishTimeout = 5 * time.Second
+)
+
+// RedisRelay fans events out between server instances over Redis Pub/Sub.
+// Every instance publishes its own events to the channel and dispatches
+// everyone else's to its local subscribers, so stream clients and in-process
+// consumers see the same events whichever replica they are attached to.
+type RedisRelay struct {
+	client  *redis.Client
+	channel string
+	bus     *Bus
+	outbox  chan outboundEvent
+	dropped atomic.Uint64
+}
+
+type outboundEvent struct {
+	eventType string
+	payload   []byte
+}
+
+// NewRedisRelay connects bus to channel. Call Run to start sending and
+// receiving.
+func NewRedisRelay(client *redis.Client, channel string, bus *Bus) *RedisRelay {
+	r := &RedisRelay{client: client, channel: channel, bus: bus, outbox: make(chan outboundEvent, relayBuffer)}
+	bus.SetForwarder(r)
+	return r
+}
+
+// Forward queues a locally generated event for the other instances without
+// blocking the publisher. Pub/Sub is fire-and-forget: if Redis is slow or
+// unreachable the event still reached local subscribers, and once the queue
+// is full further events are dropped for the others and counted.
+func (r *RedisRelay) Forward(e Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Warning: failed to encode %s event for fan-out: %v", e.Type, err)
+		return
+	}
+	select {
+	case r.outbox <- outboundEvent{eventType: e.Type, payload: payload}:
+	default:
+		if n := r.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("Warning: fan-out queue full, dropped %s event (%d dropped so far)", e.Type, n)
+		}
+	}
+}
+
+// Dropped reports how many events were not fanned out because the queue to
+// Redis was full
+func (r *RedisRelay) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+// Run publishes queued local events and dispatches events published by other
+// instances until ctx is done. The underlying subscription reconnects on its
+// own after network errors.
+func (r *RedisRelay) Run(ctx context.Context) {
+	go r.send(ctx)
+
+	pubsub := r.client.Subscribe(ctx, r.channel)
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var e remoteEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				log.Printf("Warning: ignoring malformed fan-out event: %v", err)
+				continue
+			}
+			if e.Source == r.bus.Source() {
+				continue
+			}
+			r.bus.Dispatch(Event{
+				ID:        e.ID,
+				Source:    e.Source,
+				Type:      e.Type,
+				DeviceID:  e.DeviceID,
+				Timestamp: e.Timestamp,
+				Data:      e.Data,
+			})
+		}
+	}
+}
+
+// send publishes queued events to Redis one at a time until ctx is done
+func (r *RedisRelay) send(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-r.outbox:
+			publishCtx, cancel := context.WithTimeout(ctx, relayPublishTimeout)
+			if err := r.client.Publish(publishCtx, r.channel, e.payload).Err(); err != nil {
+				log.Printf("Warning: failed to fan out %s event: %v", e.eventType, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// remoteEvent keeps the payload of a received event as raw JSON so it is
+// passed on to clients unchanged
+type remoteEvent struct {
+	Event
+	Data json.RawMessage `json:"data"`
+}
@@ -3,8 +3,10 @@ package handlers
 import (
 	"edgefleet-commander/internal/models"
 	"edgefleet-commander/internal/services"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -42,12 +44,26 @@ func (h *AlertHandler) CreateAlert(c *gin.Context) {
 		return
 	}
 
-	if err := h.alertService.CreateAlert(&alert); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := messageIDFromHeader(c, &alert.MessageID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	replayed, err := h.alertService.CreateAlertDeduped(&alert)
+	if err != nil {
+		var validationErr *services.ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrMessageInFlight):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
-	c.JSON(http.StatusCreated, alert)
+	c.JSON(createdStatus(c, replayed), alert)
 }
 
 func (h *AlertHandler) AcknowledgeAlert(c *gin.Context) {
@@ -64,4 +80,98 @@ func (h *AlertHandler) AcknowledgeAlert(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Alert acknowledged successfully"})
-}
\ No newline at end of file
+}
+
+func (h *AlertHandler) ResolveAlert(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert ID"})
+		return
+	}
+
+	if err := h.alertService.ResolveAlert(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alert resolved successfully"})
+}
+
+func (h *AlertHandler) BulkAlerts(c *gin.Context) {
+	var req models.BulkAlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var query *services.AlertQuery
+	if req.Filter != nil {
+		query = &services.AlertQuery{
+			DeviceID: req.Filter.DeviceID,
+			Severity: req.Filter.Severity,
+			Type:     req.Filter.Type,
+		}
+		if req.Filter.OlderThan != "" {
+			olderThan, err := time.ParseDuration(req.Filter.OlderThan)
+			if err != nil || olderThan <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid olderThan duration"})
+				return
+			}
+			query.OlderThan = olderThan
+		}
+		if *query == (services.AlertQuery{}) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Filter must set at least one criterion"})
+			return
+		}
+	}
+	if len(req.IDs) == 0 && query == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Either ids or filter is required"})
+		return
+	}
+
+	var snoozeFor time.Duration
+	if req.Action == "snooze" {
+		parsed, err := time.ParseDuration(req.SnoozeFor)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "snoozeFor must be a positive duration"})
+			return
+		}
+		snoozeFor = parsed
+	}
+
+	response, err := h.alertService.BulkAction(req.Action, req.IDs, query, snoozeFor)
+	if errors.Is(err, services.ErrBulkTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !response.Applied {
+		c.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *AlertHandler) GetCorrelatedAlerts(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert ID"})
+		return
+	}
+
+	alerts, err := h.alertService.GetCorrelatedAlerts(uint(id))
+	if err != nil {
+		if err.Error() == "alert not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CertificateHandler struct {
+	certificateService *services.CertificateService
+}
+
+func NewCertificateHandler(certificateService *services.CertificateService) *CertificateHandler {
+	return &CertificateHandler{certificateService: certificateService}
+}
+
+// IssueCertificate signs a client certificate for the device. An empty body
+// has the server generate the key pair, returned only in this response.
+func (h *CertificateHandler) IssueCertificate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	var request models.IssueCertificateRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	certificate, err := h.certificateService.IssueCertificate(int(id), request)
+	if err != nil {
+		var validationErr *services.ValidationError
+		switch {
+		case err.Error() == "device not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		case errors.As(err, &validationErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, certificate)
+}
+
+func (h *CertificateHandler) GetCertificates(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	certificates, err := h.certificateService.GetDeviceCertificates(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, certificates)
+}
+
+// RevokeCertificate puts the certificate on the CRL; the device can no
+// longer authenticate with it
+func (h *CertificateHandler) RevokeCertificate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	certificate, err := h.certificateService.RevokeCertificate(int(id), c.Param("serial"))
+	if err != nil {
+		if err.Error() == "certificate not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, certificate)
+}
+
+// GetCA serves the internal CA certificate as PEM
+func (h *CertificateHandler) GetCA(c *gin.Context) {
+	cert, err := h.certificateService.CACertificate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "application/x-pem-file", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+// GetCRL serves the internal CA's revocation list, as DER or with
+// ?format=pem as PEM
+func (h *CertificateHandler) GetCRL(c *gin.Context) {
+	crl, err := h.certificateService.CRL()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("format") == "pem" {
+		c.Data(http.StatusOK, "application/x-pem-file", pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}))
+		return
+	}
+	c.Data(http.StatusOK, "application/pkix-crl", crl)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/services"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CredentialHandler struct {
+	credentialService *services.CredentialService
+}
+
+func NewCredentialHandler(credentialService *services.CredentialService) *CredentialHandler {
+	return &CredentialHandler{credentialService: credentialService}
+}
+
+// maxCredentialGrace bounds how long a rotated-out token stays valid
+const maxCredentialGrace = 30 * 24 * time.Hour
+
+// IssueCredential generates a new token for the device, invalidating the
+// old one, or with ?grace=<duration> letting it work that much longer. The
+// token is shown only in this response.
+func (h *CredentialHandler) IssueCredential(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	var grace time.Duration
+	if graceStr := c.Query("grace"); graceStr != "" {
+		grace, err = time.ParseDuration(graceStr)
+		if err != nil || grace < 0 || grace > maxCredentialGrace {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("grace must be a duration up to %s", maxCredentialGrace)})
+			return
+		}
+	}
+
+	credential, err := h.credentialService.IssueCredential(int(id), grace)
+	if err != nil {
+		if err.Error() == "device not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, credential)
+}
+
+// GetCredential reports when the device's token was issued, without the
+// token itself
+func (h *CredentialHandler) GetCredential(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	credential, err := h.credentialService.GetCredential(int(id))
+	if err != nil {
+		if err.Error() == "credential not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device has no credential"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, credential)
+}
+
+func (h *CredentialHandler) RevokeCredential(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	if err := h.credentialService.RevokeCredential(int(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Credential revoked successfully"})
+}
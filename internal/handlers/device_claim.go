@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/middleware"
+	"edgefleet-commander/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// claimDevice attributes data to the device the request authenticated as.
+// named is the device the payload names, or 0 if it names none. Data naming
+// a different device is refused rather than silently reassigned. Without
+// device auth the named device is taken, unless it has credentials it should
+// have presented. Unsigned data for a device that requires signing is
+// refused either way.
+func claimDevice(c *gin.Context, named int) (int, error) {
+	deviceID, ok := middleware.AuthenticatedDevice(c)
+	if !ok {
+		if named != 0 {
+			if err := middleware.AllowAnonymous(c, named); err != nil {
+				return 0, err
+			}
+		}
+		deviceID = named
+	} else if named != 0 && named != deviceID {
+		return 0, fmt.Errorf("device %d may not write telemetry for device %d", deviceID, named)
+	}
+	if deviceID != 0 {
+		if err := middleware.RequireSignature(c, deviceID); err != nil {
+			return 0, err
+		}
+	}
+	return deviceID, nil
+}
+
+// respondClaimError answers a request whose one reading claimDevice refused
+func respondClaimError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSignatureRequired), errors.Is(err, services.ErrCredentialRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case strings.HasPrefix(err.Error(), "failed to"):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	}
+}
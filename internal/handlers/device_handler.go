@@ -3,6 +3,7 @@ package handlers
 import (
 	"edgefleet-commander/internal/models"
 	"edgefleet-commander/internal/services"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -10,11 +11,13 @@ import (
 )
 
 type DeviceHandler struct {
-	deviceService *services.DeviceService
+	deviceService      *services.DeviceService
+	credentialService  *services.CredentialService
+	certificateService *services.CertificateService
 }
 
-func NewDeviceHandler(deviceService *services.DeviceService) *DeviceHandler {
-	return &DeviceHandler{deviceService: deviceService}
+func NewDeviceHandler(deviceService *services.DeviceService, credentialService *services.CredentialService, certificateService *services.CertificateService) *DeviceHandler {
+	return &DeviceHandler{deviceService: deviceService, credentialService: credentialService, certificateService: certificateService}
 }
 
 func (h *DeviceHandler) GetDevices(c *gin.Context) {
@@ -56,11 +59,22 @@ func (h *DeviceHandler) CreateDevice(c *gin.Context) {
 
 	device, err := h.deviceService.CreateDevice(&insertDevice)
 	if err != nil {
+		if err.Error() == "invalid parent device" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent device"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, device)
+	// The device gets its ingestion token straight away; this response is
+	// the only place it appears. If issuing fails the device still exists
+	// and a token can be issued through its credentials endpoint.
+	credential, err := h.credentialService.IssueCredential(device.ID, 0)
+	if err != nil {
+		log.Printf("Warning: failed to issue credential for device %d: %v", device.ID, err)
+	}
+	c.JSON(http.StatusCreated, models.CreatedDevice{Device: *device, Credential: credential})
 }
 
 func (h *DeviceHandler) UpdateDevice(c *gin.Context) {
@@ -83,6 +97,10 @@ func (h *DeviceHandler) UpdateDevice(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
 			return
 		}
+		if err.Error() == "invalid parent device" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent device"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -98,6 +116,15 @@ func (h *DeviceHandler) DeleteDevice(c *gin.Context) {
 		return
 	}
 
+	// A deleted device's token and certificates must stop working with it
+	if err := h.credentialService.DeleteDeviceCredentials(int(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.certificateService.RevokeDeviceCertificates(int(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	if err := h.deviceService.DeleteDevice(int(id)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -105,3 +132,24 @@ func (h *DeviceHandler) DeleteDevice(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Device deleted successfully"})
 }
+
+func (h *DeviceHandler) GetChildDevices(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	if _, err := h.deviceService.GetDeviceByID(int(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	children, err := h.deviceService.GetChildDevices(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, children)
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EnrollmentHandler struct {
+	enrollmentService *services.EnrollmentService
+}
+
+func NewEnrollmentHandler(enrollmentService *services.EnrollmentService) *EnrollmentHandler {
+	return &EnrollmentHandler{enrollmentService: enrollmentService}
+}
+
+// CreateBootstrapToken creates a token devices can enroll with. The token is
+// shown only in this response.
+func (h *EnrollmentHandler) CreateBootstrapToken(c *gin.Context) {
+	var insertToken models.InsertBootstrapToken
+	if err := c.ShouldBindJSON(&insertToken); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := h.enrollmentService.CreateBootstrapToken(&insertToken)
+	if err != nil {
+		respondEnrollmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, token)
+}
+
+func (h *EnrollmentHandler) GetBootstrapTokens(c *gin.Context) {
+	tokens, err := h.enrollmentService.GetBootstrapTokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *EnrollmentHandler) DeleteBootstrapToken(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bootstrap token ID"})
+		return
+	}
+
+	if err := h.enrollmentService.DeleteBootstrapToken(int(id)); err != nil {
+		respondEnrollmentError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Enroll takes a device's enrollment request, authenticated with a bootstrap
+// token as "Authorization: Bearer <token>". The response carries the poll
+// token the device collects its credentials with, or the credentials
+// themselves if the bootstrap token auto-approves.
+func (h *EnrollmentHandler) Enroll(c *gin.Context) {
+	var request models.EnrollmentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	status, err := h.enrollmentService.Enroll(bearerToken(c), &request)
+	if err != nil {
+		respondEnrollmentError(c, err)
+		return
+	}
+	if status.Status == models.EnrollmentApproved {
+		c.JSON(http.StatusCreated, status)
+		return
+	}
+	c.JSON(http.StatusAccepted, status)
+}
+
+// PollEnrollment is how an enrolling device, presenting its poll token as a
+// bearer token, learns whether it was approved and picks up its credentials
+func (h *EnrollmentHandler) PollEnrollment(c *gin.Context) {
+	id, ok := parseEnrollmentID(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.enrollmentService.Collect(id, bearerToken(c))
+	if err != nil {
+		respondEnrollmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *EnrollmentHandler) GetEnrollments(c *gin.Context) {
+	enrollments, err := h.enrollmentService.GetEnrollments(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, enrollments)
+}
+
+func (h *EnrollmentHandler) GetEnrollment(c *gin.Context) {
+	id, ok := parseEnrollmentID(c)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.enrollmentService.GetEnrollment(id)
+	if err != nil {
+		respondEnrollmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ApproveEnrollment registers the device. The body may set its name,
+// location and parent; an empty body keeps what the device asked for.
+func (h *EnrollmentHandler) ApproveEnrollment(c *gin.Context) {
+	id, ok := parseEnrollmentID(c)
+	if !ok {
+		return
+	}
+
+	var decision models.EnrollmentDecision
+	if err := c.ShouldBindJSON(&decision); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	enrollment, err := h.enrollmentService.Approve(id, &decision)
+	if err != nil {
+		respondEnrollmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (h *EnrollmentHandler) RejectEnrollment(c *gin.Context) {
+	id, ok := parseEnrollmentID(c)
+	if !ok {
+		return
+	}
+
+	var decision models.EnrollmentDecision
+	if err := c.ShouldBindJSON(&decision); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	enrollment, err := h.enrollmentService.Reject(id, decision.Reason)
+	if err != nil {
+		respondEnrollmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) string {
+	scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func parseEnrollmentID(c *gin.Context) (int, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid enrollment ID"})
+		return 0, false
+	}
+	return int(id), true
+}
+
+func respondEnrollmentError(c *gin.Context, err error) {
+	var validationErr *services.ValidationError
+	switch {
+	case errors.Is(err, services.ErrInvalidBootstrapToken):
+		c.Header("WWW-Authenticate", `Bearer realm="edgefleet"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSerialRegistered), errors.Is(err, services.ErrEnrollmentDecided), errors.Is(err, services.ErrEnrollmentPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err.Error() == "invalid parent device":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent device"})
+	case err.Error() == "enrollment not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+	case err.Error() == "bootstrap token not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bootstrap token not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
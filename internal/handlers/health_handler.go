@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/ingest"
+	"edgefleet-commander/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthPingTimeout bounds how long the health check waits for Redis
+const healthPingTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	db       *database.RedisClient
+	pipeline *ingest.Pipeline
+}
+
+func NewHealthHandler(db *database.RedisClient, pipeline *ingest.Pipeline) *HealthHandler {
+	return &HealthHandler{db: db, pipeline: pipeline}
+}
+
+// GetHealth reports whether Redis is reachable and how much telemetry is
+// spooled waiting for it. The server keeps accepting telemetry while Redis
+// is down, so it answers 200 with status "degraded" rather than failing.
+func (h *HealthHandler) GetHealth(c *gin.Context) {
+	health := models.Health{Status: "ok", Redis: "up", Spool: h.pipeline.SpoolStats()}
+	if err := h.db.Ping(healthPingTimeout); err != nil {
+		health.Status = "degraded"
+		health.Redis = "down"
+	}
+	if health.Spool.Records > 0 {
+		health.Status = "degraded"
+	}
+	c.JSON(http.StatusOK, health)
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// messageIDFromHeader fills in a record's message ID from the
+// Idempotency-Key header. A key that disagrees with the body's messageId is
+// an error.
+func messageIDFromHeader(c *gin.Context, messageID *string) error {
+	key := c.GetHeader("Idempotency-Key")
+	switch {
+	case key == "":
+	case *messageID == "":
+		*messageID = key
+	case *messageID != key:
+		return fmt.Errorf("Idempotency-Key header and messageId differ")
+	}
+	return nil
+}
+
+// createdStatus is 201 for a new record, or 200 with Idempotent-Replayed set
+// when the original record is returned for a repeated message ID
+func createdStatus(c *gin.Context, replayed bool) int {
+	if replayed {
+		c.Header("Idempotent-Replayed", "true")
+		return http.StatusOK
+	}
+	return http.StatusCreated
+}
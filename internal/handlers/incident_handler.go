@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type IncidentHandler struct {
+	incidentService *services.IncidentService
+}
+
+func NewIncidentHandler(incidentService *services.IncidentService) *IncidentHandler {
+	return &IncidentHandler{incidentService: incidentService}
+}
+
+func (h *IncidentHandler) GetIncidents(c *gin.Context) {
+	incidents, err := h.incidentService.GetAllIncidents(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, incidents)
+}
+
+func (h *IncidentHandler) GetIncident(c *gin.Context) {
+	id, ok := parseIncidentID(c)
+	if !ok {
+		return
+	}
+
+	incident, err := h.incidentService.GetIncidentByID(id)
+	if err != nil {
+		respondIncidentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, incident)
+}
+
+func (h *IncidentHandler) CreateIncident(c *gin.Context) {
+	var insertIncident models.InsertIncident
+	if err := c.ShouldBindJSON(&insertIncident); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	incident, err := h.incidentService.CreateIncident(&insertIncident)
+	if err != nil {
+		respondIncidentError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, incident)
+}
+
+func (h *IncidentHandler) GetIncidentAlerts(c *gin.Context) {
+	id, ok := parseIncidentID(c)
+	if !ok {
+		return
+	}
+
+	alerts, err := h.incidentService.GetIncidentAlerts(id)
+	if err != nil {
+		respondIncidentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+func (h *IncidentHandler) AddAlerts(c *gin.Context) {
+	id, ok := parseIncidentID(c)
+	if !ok {
+		return
+	}
+
+	var req models.IncidentAlertsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	incident, err := h.incidentService.AddAlerts(id, req.AlertIDs)
+	if err != nil {
+		respondIncidentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, incident)
+}
+
+func (h *IncidentHandler) GetTimeline(c *gin.Context) {
+	id, ok := parseIncidentID(c)
+	if !ok {
+		return
+	}
+
+	events, err := h.incidentService.GetTimeline(id)
+	if err != nil {
+		respondIncidentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+func (h *IncidentHandler) AddComment(c *gin.Context) {
+	id, ok := parseIncidentID(c)
+	if !ok {
+		return
+	}
+
+	var comment models.IncidentComment
+	if err := c.ShouldBindJSON(&comment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, err := h.incidentService.AddComment(id, &comment)
+	if err != nil {
+		respondIncidentError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, event)
+}
+
+func (h *IncidentHandler) UpdateStatus(c *gin.Context) {
+	id, ok := parseIncidentID(c)
+	if !ok {
+		return
+	}
+
+	var update models.IncidentStatusUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	incident, err := h.incidentService.UpdateStatus(id, update.Status, update.Author)
+	if err != nil {
+		respondIncidentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, incident)
+}
+
+func parseIncidentID(c *gin.Context) (int, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid incident ID"})
+		return 0, false
+	}
+	return int(id), true
+}
+
+func respondIncidentError(c *gin.Context, err error) {
+	switch {
+	case err.Error() == "incident not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Incident not found"})
+	case err.Error() == "alert not found":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Alert not found"})
+	case strings.Contains(err.Error(), "already belongs to incident"):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/ingest"
+	"edgefleet-commander/internal/lineprotocol"
+	"edgefleet-commander/internal/middleware"
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// telemetryMeasurement is the measurement whose fields keep their own names
+// rather than being prefixed, so built-in metrics can be written directly
+const telemetryMeasurement = "telemetry"
+
+// InfluxHandler accepts InfluxDB line protocol so Telegraf and other
+// collectors can push telemetry without custom code
+type InfluxHandler struct {
+	pipeline  *ingest.Pipeline
+	deviceTag string
+	maxItems  int
+	maxBytes  int64
+}
+
+// NewInfluxHandler creates the line protocol endpoint. deviceTag names the
+// tag holding the device ID; writes share the batch limits.
+func NewInfluxHandler(pipeline *ingest.Pipeline, deviceTag string, maxItems int, maxBytes int64) *InfluxHandler {
+	return &InfluxHandler{
+		pipeline:  pipeline,
+		deviceTag: deviceTag,
+		maxItems:  maxItems,
+		maxBytes:  maxBytes,
+	}
+}
+
+// Write stores a body of line protocol. Points for the same device and
+// timestamp are merged into one reading, each field becoming the metric
+// <measurement>_<field>. Like InfluxDB it answers 204 when everything was
+// written and 400 for a partial write.
+func (h *InfluxHandler) Write(c *gin.Context) {
+	precision, err := lineprotocol.Precision(c.Query("precision"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	body, err := readBody(c, h.maxBytes)
+	if err != nil {
+		if bodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body exceeds %d bytes", h.maxBytes)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	points, err := lineprotocol.Parse(string(body), precision)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	readings, rejected := h.readings(c, points)
+	if len(readings) > h.maxItems {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Batch exceeds %d readings", h.maxItems)})
+		return
+	}
+
+	if len(readings) > 0 {
+		storeErrs, _, err := h.pipeline.Submit(readings)
+		if errors.Is(err, ingest.ErrSpooled) {
+			// Stored once Redis is back, so don't have the client resend
+			storeErrs, err = nil, nil
+		}
+		if err != nil {
+			if !backpressure(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		for i, storeErr := range storeErrs {
+			if storeErr != nil {
+				rejected = append(rejected, fmt.Errorf("device %d: %w", readings[i].DeviceID, storeErr))
+			}
+		}
+	}
+
+	if len(rejected) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("partial write: %d rejected, including %s", len(rejected), rejected[0])})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// readings groups points into one reading per device and timestamp. Points
+// without a usable device tag or numeric field are returned as rejected. An
+// authenticated device may leave out the tag.
+func (h *InfluxHandler) readings(c *gin.Context, points []lineprotocol.Point) ([]models.Telemetry, []error) {
+	type readingKey struct {
+		deviceID int
+		time     time.Time
+	}
+	var readings []models.Telemetry
+	var rejected []error
+	index := map[readingKey]int{}
+	for i, point := range points {
+		var named uint64
+		idStr, ok := point.Tags[h.deviceTag]
+		if ok {
+			var err error
+			named, err = strconv.ParseUint(idStr, 10, 32)
+			if err != nil || named == 0 {
+				rejected = append(rejected, fmt.Errorf("point %d (%s): invalid device ID %q", i+1, point.Measurement, idStr))
+				continue
+			}
+		}
+		deviceID, err := claimDevice(c, int(named))
+		if err != nil {
+			rejected = append(rejected, fmt.Errorf("point %d (%s): %w", i+1, point.Measurement, err))
+			continue
+		}
+		if deviceID == 0 {
+			rejected = append(rejected, fmt.Errorf("point %d (%s): missing %q tag", i+1, point.Measurement, h.deviceTag))
+			continue
+		}
+
+		reading := models.Telemetry{DeviceID: deviceID, Timestamp: point.Time, Signature: middleware.SignatureStatus(c)}
+		key := readingKey{deviceID, point.Time}
+		n, merge := index[key]
+		if merge {
+			reading = readings[n]
+		}
+		stored := 0
+		for field, value := range point.Fields {
+			if !value.Numeric {
+				continue
+			}
+			name := point.Measurement + "_" + field
+			if point.Measurement == telemetryMeasurement {
+				name = field
+			}
+			if name = services.MetricName(name); name != "" {
+				services.SetMetric(&reading, name, value.Value, "")
+				stored++
+			}
+		}
+		switch {
+		case stored == 0:
+			rejected = append(rejected, fmt.Errorf("point %d (%s): no numeric fields", i+1, point.Measurement))
+		case merge:
+			readings[n] = reading
+		default:
+			index[key] = len(readings)
+			readings = append(readings, reading)
+		}
+	}
+	return readings, rejected
+}
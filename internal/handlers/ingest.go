@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/ingest"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ingestRetryAfter is the Retry-After, in seconds, sent with backpressure
+// responses
+const ingestRetryAfter = "1"
+
+// backpressure answers 429 when the ingest queue is full and 503 when a
+// write didn't finish in time, reporting whether err was either
+func backpressure(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, ingest.ErrQueueFull):
+		c.Header("Retry-After", ingestRetryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, ingest.ErrTimeout):
+		c.Header("Retry-After", ingestRetryAfter)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// respondAsync reports whether the client asked not to wait for its
+// readings to be written
+func respondAsync(c *gin.Context) bool {
+	return c.GetHeader("Prefer") == "respond-async"
+}
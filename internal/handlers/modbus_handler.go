@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ModbusHandler struct {
+	modbusService *services.ModbusService
+}
+
+func NewModbusHandler(modbusService *services.ModbusService) *ModbusHandler {
+	return &ModbusHandler{modbusService: modbusService}
+}
+
+func (h *ModbusHandler) GetConfigs(c *gin.Context) {
+	configs, err := h.modbusService.GetAllConfigs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, configs)
+}
+
+func (h *ModbusHandler) GetConfig(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	config, err := h.modbusService.GetConfig(int(id))
+	if err != nil {
+		if err.Error() == "modbus config not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Modbus config not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, config)
+}
+
+func (h *ModbusHandler) SaveConfig(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	var insertConfig models.InsertModbusConfig
+	if err := c.ShouldBindJSON(&insertConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, err := h.modbusService.SaveConfig(int(id), &insertConfig)
+	if err != nil {
+		var validationErr *services.ValidationError
+		switch {
+		case err.Error() == "device not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		case errors.As(err, &validationErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, config)
+}
+
+func (h *ModbusHandler) DeleteConfig(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	if err := h.modbusService.DeleteConfig(int(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Modbus config deleted successfully"})
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/ingest"
+	"edgefleet-commander/internal/middleware"
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLPHandler receives OpenTelemetry metrics over OTLP/HTTP
+type OTLPHandler struct {
+	pipeline        *ingest.Pipeline
+	deviceAttribute string
+	maxItems        int
+	maxBytes        int64
+}
+
+// NewOTLPHandler creates the OTLP metrics endpoint. deviceAttribute names the
+// resource attribute holding the device ID; exports share the batch limits.
+func NewOTLPHandler(pipeline *ingest.Pipeline, deviceAttribute string, maxItems int, maxBytes int64) *OTLPHandler {
+	return &OTLPHandler{
+		pipeline:        pipeline,
+		deviceAttribute: deviceAttribute,
+		maxItems:        maxItems,
+		maxBytes:        maxBytes,
+	}
+}
+
+// ExportMetrics stores an ExportMetricsServiceRequest, as protobuf or JSON.
+// Gauge and sum points for the same device and timestamp are merged into one
+// reading. Points that can't be stored are reported as a partial success, as
+// the OTLP spec asks, so exporters don't retry them.
+func (h *OTLPHandler) ExportMetrics(c *gin.Context) {
+	isJSON := c.ContentType() == "application/json"
+	if !isJSON && c.ContentType() != "application/x-protobuf" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/x-protobuf or application/json"})
+		return
+	}
+
+	body, err := readBody(c, h.maxBytes)
+	if err != nil {
+		if bodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body exceeds %d bytes", h.maxBytes)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// ExportMetricsServiceRequest has the same fields as MetricsData
+	var request metricspb.MetricsData
+	if isJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, &request)
+	} else {
+		err = proto.Unmarshal(body, &request)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid OTLP metrics request: %v", err)})
+		return
+	}
+
+	readings, points, rejected, problems := h.readings(c, &request)
+	if len(readings) > h.maxItems {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Batch exceeds %d readings", h.maxItems)})
+		return
+	}
+	if len(readings) > 0 {
+		storeErrs, _, err := h.pipeline.Submit(readings)
+		if errors.Is(err, ingest.ErrSpooled) {
+			// Stored once Redis is back, so don't have the client resend
+			storeErrs, err = nil, nil
+		}
+		if err != nil {
+			// 429 and 503 tell exporters to retry later
+			if !backpressure(c, err) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		for i, storeErr := range storeErrs {
+			if storeErr != nil {
+				rejected += int64(points[i])
+				problems = append(problems, fmt.Sprintf("device %d: %v", readings[i].DeviceID, storeErr))
+			}
+		}
+	}
+
+	var errorMessage string
+	if len(problems) > 0 {
+		errorMessage = problems[0]
+		if len(problems) > 1 {
+			errorMessage += fmt.Sprintf(" (and %d more problems)", len(problems)-1)
+		}
+	}
+	writeExportResponse(c, isJSON, rejected, errorMessage)
+}
+
+// readings groups the gauge and sum points of a request into one reading per
+// device and timestamp, returning how many points went into each reading,
+// and the number of points rejected with the reasons
+func (h *OTLPHandler) readings(c *gin.Context, request *metricspb.MetricsData) ([]models.Telemetry, []int, int64, []string) {
+	type readingKey struct {
+		deviceID int
+		time     time.Time
+	}
+	var readings []models.Telemetry
+	var points []int
+	var rejected int64
+	var problems []string
+	index := map[readingKey]int{}
+
+	for _, resourceMetrics := range request.GetResourceMetrics() {
+		// An authenticated device may leave out the attribute
+		deviceID := 0
+		var problem string
+		if idStr, ok := attributeValue(resourceMetrics.GetResource().GetAttributes(), h.deviceAttribute); ok {
+			named, err := strconv.ParseUint(idStr, 10, 32)
+			if err != nil || named == 0 {
+				problem = fmt.Sprintf("invalid device ID %q", idStr)
+			}
+			deviceID = int(named)
+		}
+		if problem == "" {
+			claimed, err := claimDevice(c, deviceID)
+			if err != nil {
+				problem = err.Error()
+			}
+			deviceID = claimed
+		}
+		if problem == "" && deviceID == 0 {
+			problem = fmt.Sprintf("resource has no %q attribute", h.deviceAttribute)
+		}
+		if problem != "" {
+			count := countPoints(resourceMetrics)
+			rejected += count
+			problems = append(problems, fmt.Sprintf("%d points: %s", count, problem))
+			continue
+		}
+
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				var dataPoints []*metricspb.NumberDataPoint
+				switch data := metric.GetData().(type) {
+				case *metricspb.Metric_Gauge:
+					dataPoints = data.Gauge.GetDataPoints()
+				case *metricspb.Metric_Sum:
+					dataPoints = data.Sum.GetDataPoints()
+				default:
+					rejected += countMetricPoints(metric)
+					problems = append(problems, fmt.Sprintf("metric %q: only gauges and sums are supported", metric.GetName()))
+					continue
+				}
+
+				for _, point := range dataPoints {
+					name := services.MetricName(seriesName(metric.GetName(), point.GetAttributes()))
+					if name == "" {
+						rejected++
+						problems = append(problems, fmt.Sprintf("metric %q: unusable name", metric.GetName()))
+						continue
+					}
+					var value float64
+					switch v := point.GetValue().(type) {
+					case *metricspb.NumberDataPoint_AsDouble:
+						value = v.AsDouble
+					case *metricspb.NumberDataPoint_AsInt:
+						value = float64(v.AsInt)
+					default:
+						rejected++
+						problems = append(problems, fmt.Sprintf("metric %q: point has no value", metric.GetName()))
+						continue
+					}
+
+					var timestamp time.Time
+					if point.GetTimeUnixNano() != 0 {
+						timestamp = time.Unix(0, int64(point.GetTimeUnixNano()))
+					}
+					key := readingKey{deviceID, timestamp}
+					n, ok := index[key]
+					if !ok {
+						n = len(readings)
+						index[key] = n
+						readings = append(readings, models.Telemetry{DeviceID: deviceID, Timestamp: timestamp, Signature: middleware.SignatureStatus(c)})
+						points = append(points, 0)
+					}
+					services.SetMetric(&readings[n], name, value, metric.GetUnit())
+					points[n]++
+				}
+			}
+		}
+	}
+	return readings, points, rejected, problems
+}
+
+// seriesName appends a point's attribute values, ordered by key, to the
+// metric name, e.g. system.cpu.utilization with state=user becomes
+// system.cpu.utilization.user
+func seriesName(metric string, attributes []*commonpb.KeyValue) string {
+	if len(attributes) == 0 {
+		return metric
+	}
+	sorted := append([]*commonpb.KeyValue(nil), attributes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetKey() < sorted[j].GetKey() })
+	parts := []string{metric}
+	for _, attribute := range sorted {
+		parts = append(parts, anyValueString(attribute.GetValue()))
+	}
+	return strings.Join(parts, ".")
+}
+
+func attributeValue(attributes []*commonpb.KeyValue, key string) (string, bool) {
+	for _, attribute := range attributes {
+		if attribute.GetKey() == key {
+			return anyValueString(attribute.GetValue()), true
+		}
+	}
+	return "", false
+}
+
+func anyValueString(value *commonpb.AnyValue) string {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'f', -1, 64)
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	}
+	return ""
+}
+
+func countPoints(resourceMetrics *metricspb.ResourceMetrics) int64 {
+	var count int64
+	for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+		for _, metric := range scopeMetrics.GetMetrics() {
+			count += countMetricPoints(metric)
+		}
+	}
+	return count
+}
+
+func countMetricPoints(metric *metricspb.Metric) int64 {
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		return int64(len(data.Gauge.GetDataPoints()))
+	case *metricspb.Metric_Sum:
+		return int64(len(data.Sum.GetDataPoints()))
+	case *metricspb.Metric_Histogram:
+		return int64(len(data.Histogram.GetDataPoints()))
+	case *metricspb.Metric_ExponentialHistogram:
+		return int64(len(data.ExponentialHistogram.GetDataPoints()))
+	case *metricspb.Metric_Summary:
+		return int64(len(data.Summary.GetDataPoints()))
+	}
+	return 0
+}
+
+// writeExportResponse answers with an ExportMetricsServiceResponse in the
+// request's encoding, carrying partial_success if any points were rejected
+func writeExportResponse(c *gin.Context, isJSON bool, rejected int64, errorMessage string) {
+	if isJSON {
+		response := gin.H{}
+		if rejected > 0 || errorMessage != "" {
+			response["partialSuccess"] = gin.H{
+				"rejectedDataPoints": strconv.FormatInt(rejected, 10),
+				"errorMessage":       errorMessage,
+			}
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	var response []byte
+	if rejected > 0 || errorMessage != "" {
+		var partialSuccess []byte
+		partialSuccess = protowire.AppendTag(partialSuccess, 1, protowire.VarintType)
+		partialSuccess = protowire.AppendVarint(partialSuccess, uint64(rejected))
+		partialSuccess = protowire.AppendTag(partialSuccess, 2, protowire.BytesType)
+		partialSuccess = protowire.AppendString(partialSuccess, errorMessage)
+		response = protowire.AppendTag(response, 1, protowire.BytesType)
+		response = protowire.AppendBytes(response, partialSuccess)
+	}
+	c.Data(http.StatusOK, "application/x-protobuf", response)
+}
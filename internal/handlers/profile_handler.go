@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ProfileHandler struct {
+	profileService *services.ProfileService
+}
+
+func NewProfileHandler(profileService *services.ProfileService) *ProfileHandler {
+	return &ProfileHandler{profileService: profileService}
+}
+
+func (h *ProfileHandler) GetProfiles(c *gin.Context) {
+	profiles, err := h.profileService.GetAllProfiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profiles)
+}
+
+func (h *ProfileHandler) GetProfile(c *gin.Context) {
+	profile, err := h.profileService.GetProfile(c.Param("type"))
+	if err != nil {
+		if err.Error() == "profile not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Profile not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+func (h *ProfileHandler) SaveProfile(c *gin.Context) {
+	var insertProfile models.InsertDeviceProfile
+	if err := c.ShouldBindJSON(&insertProfile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.profileService.SaveProfile(c.Param("type"), &insertProfile)
+	if err != nil {
+		var validationErr *services.ValidationError
+		if errors.As(err, &validationErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+func (h *ProfileHandler) DeleteProfile(c *gin.Context) {
+	if err := h.profileService.DeleteProfile(c.Param("type")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Profile deleted successfully"})
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// readBody reads the request body, inflating it if it was gzipped. The limit
+// applies to the inflated size as well.
+func readBody(c *gin.Context, maxBytes int64) ([]byte, error) {
+	var r io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+	switch encoding := c.GetHeader("Content-Encoding"); encoding {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errBodyTooLarge
+	}
+	return data, nil
+}
+
+// bodyTooLarge reports whether readBody failed because of the size limit
+func bodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || errors.Is(err, errBodyTooLarge)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SigningHandler struct {
+	signingService *services.SigningService
+}
+
+func NewSigningHandler(signingService *services.SigningService) *SigningHandler {
+	return &SigningHandler{signingService: signingService}
+}
+
+// SetSigningKey sets how the device signs its telemetry. An HMAC secret is
+// shown only in this response.
+func (h *SigningHandler) SetSigningKey(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	var request models.SigningKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := h.signingService.SetSigningKey(int(id), &request)
+	if err != nil {
+		var validationErr *services.ValidationError
+		switch {
+		case err.Error() == "device not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		case errors.As(err, &validationErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+func (h *SigningHandler) GetSigningKey(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	key, err := h.signingService.GetSigningKey(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if key == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Signing key not found"})
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// DeleteSigningKey stops the device signing; its payloads are then stored as
+// unsigned
+func (h *SigningHandler) DeleteSigningKey(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	if err := h.signingService.DeleteSigningKey(int(id)); err != nil {
+		if err.Error() == "signing key not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Signing key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Signing key deleted successfully"})
+}
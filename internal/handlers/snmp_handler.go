@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SNMPHandler struct {
+	snmpService *services.SNMPService
+}
+
+func NewSNMPHandler(snmpService *services.SNMPService) *SNMPHandler {
+	return &SNMPHandler{snmpService: snmpService}
+}
+
+func (h *SNMPHandler) GetConfigs(c *gin.Context) {
+	configs, err := h.snmpService.GetAllConfigs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range configs {
+		redactSNMPConfig(&configs[i])
+	}
+	c.JSON(http.StatusOK, configs)
+}
+
+func (h *SNMPHandler) GetConfig(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	config, err := h.snmpService.GetConfig(int(id))
+	if err != nil {
+		if err.Error() == "snmp config not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "SNMP config not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	redactSNMPConfig(config)
+	c.JSON(http.StatusOK, config)
+}
+
+func (h *SNMPHandler) SaveConfig(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	var insertConfig models.InsertSNMPConfig
+	if err := c.ShouldBindJSON(&insertConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, err := h.snmpService.SaveConfig(int(id), &insertConfig)
+	if err != nil {
+		var validationErr *services.ValidationError
+		switch {
+		case err.Error() == "device not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		case errors.As(err, &validationErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	redactSNMPConfig(config)
+	c.JSON(http.StatusOK, config)
+}
+
+func (h *SNMPHandler) DeleteConfig(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	if err := h.snmpService.DeleteConfig(int(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "SNMP config deleted successfully"})
+}
+
+// redactSNMPConfig blanks the community and passphrases, which are write-only
+func redactSNMPConfig(config *models.SNMPConfig) {
+	config.Community = ""
+	if config.User != nil {
+		user := *config.User
+		user.AuthPassphrase, user.PrivPassphrase = "", ""
+		config.User = &user
+	}
+}
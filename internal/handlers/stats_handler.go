@@ -1,18 +1,23 @@
 package handlers
 
 import (
+	"edgefleet-commander/internal/ingest"
 	"edgefleet-commander/internal/services"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type StatsHandler struct {
 	statsService *services.StatsService
+	pipeline     *ingest.Pipeline
 }
 
-func NewStatsHandler(statsService *services.StatsService) *StatsHandler {
-	return &StatsHandler{statsService: statsService}
+func NewStatsHandler(statsService *services.StatsService, pipeline *ingest.Pipeline) *StatsHandler {
+	return &StatsHandler{statsService: statsService, pipeline: pipeline}
 }
 
 func (h *StatsHandler) GetStats(c *gin.Context) {
@@ -22,4 +27,63 @@ func (h *StatsHandler) GetStats(c *gin.Context) {
 		return
 	}
 	c.JSON(http.StatusOK, stats)
-}
\ No newline at end of file
+}
+
+// GetIngestStats reports the telemetry ingestion queue's depth, outcome
+// counters and write latency
+func (h *StatsHandler) GetIngestStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.pipeline.Stats())
+}
+
+// maxAlertStatsBuckets bounds the volume series so a tiny bucket over a long
+// window can't produce an enormous response.
+const maxAlertStatsBuckets = 1000
+
+// maxAlertStatsHours bounds the window, keeping it well clear of overflowing
+// a time.Duration
+const maxAlertStatsHours = 24 * 366
+
+func (h *StatsHandler) GetAlertStats(c *gin.Context) {
+	hoursStr := c.Query("hours")
+	hours := 24 // default window
+
+	if hoursStr != "" {
+		if parsedHours, err := strconv.Atoi(hoursStr); err == nil && parsedHours > 0 {
+			hours = parsedHours
+		}
+	}
+	if hours > maxAlertStatsHours {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("hours must be at most %d", maxAlertStatsHours)})
+		return
+	}
+
+	bucket := time.Hour
+	if bucketStr := c.Query("bucket"); bucketStr != "" {
+		parsedBucket, err := time.ParseDuration(bucketStr)
+		if err != nil || parsedBucket <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bucket duration"})
+			return
+		}
+		bucket = parsedBucket
+	}
+
+	window := time.Duration(hours) * time.Hour
+	if window/bucket > maxAlertStatsBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bucket too small for the requested window"})
+		return
+	}
+
+	top := 5
+	if topStr := c.Query("top"); topStr != "" {
+		if parsedTop, err := strconv.Atoi(topStr); err == nil && parsedTop > 0 {
+			top = parsedTop
+		}
+	}
+
+	stats, err := h.statsService.GetAlertStats(window, bucket, top)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
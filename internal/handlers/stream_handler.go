@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"edgefleet-commander/internal/events"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamBuffer is how many events a slow client may fall behind by
+	// before events are dropped for it
+	streamBuffer = 256
+	// streamHeartbeat keeps idle connections alive through proxies
+	streamHeartbeat = 25 * time.Second
+	wsWriteTimeout  = 10 * time.Second
+	wsMaxMessage    = 4096
+)
+
+type StreamHandler struct {
+	bus      *events.Bus
+	upgrader websocket.Upgrader
+}
+
+// NewStreamHandler serves the event stream. WebSocket upgrades are accepted
+// from the same origin or any of allowedOrigins.
+func NewStreamHandler(bus *events.Bus, allowedOrigins []string) *StreamHandler {
+	h := &StreamHandler{bus: bus}
+	h.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || strings.TrimPrefix(strings.TrimPrefix(origin, "http://"), "https://") == r.Host {
+				return true
+			}
+			for _, allowed := range allowedOrigins {
+				if origin == allowed {
+					return true
+				}
+			}
+			return false
+		},
+	}
+	return h
+}
+
+// Stream pushes events to the client, over WebSocket when the request asks
+// for an upgrade and as Server-Sent Events otherwise. The optional `types`
+// and `devices` query parameters (comma separated) filter the stream.
+func (h *StreamHandler) Stream(c *gin.Context) {
+	filter, err := parseStreamFilter(c.Query("types"), c.Query("devices"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.serveWebSocket(c, filter)
+		return
+	}
+	h.serveSSE(c, filter)
+}
+
+func (h *StreamHandler) serveSSE(c *gin.Context, filter events.Filter) {
+	sub := h.bus.Subscribe(filter, streamBuffer)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, ": connected\n\n")
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-sub.Events():
+			if !ok {
+				return false
+			}
+			c.Render(-1, sse.Event{
+				Id:    fmt.Sprintf("%s:%d", event.Source, event.ID),
+				Event: event.Type,
+				Data:  event,
+			})
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			return true
+		}
+	})
+}
+
+// serveWebSocket streams events as JSON text messages. The client may send
+// a JSON filter ({"types": [...], "deviceIds": [...]}) at any time to
+// replace its subscription filter.
+func (h *StreamHandler) serveWebSocket(c *gin.Context, filter events.Filter) {
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied to the client
+		return
+	}
+	defer conn.Close()
+
+	sub := h.bus.Subscribe(filter, streamBuffer)
+	defer sub.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(wsMaxMessage)
+		conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+		})
+		for {
+			var update events.Filter
+			if err := conn.ReadJSON(&update); err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "invalid filter"), time.Now().Add(wsWriteTimeout))
+				}
+				return
+			}
+			if err := validateStreamFilter(update); err != nil {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()), time.Now().Add(wsWriteTimeout))
+				return
+			}
+			sub.SetFilter(update)
+		}
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func parseStreamFilter(types, devices string) (events.Filter, error) {
+	var filter events.Filter
+	for _, eventType := range strings.Split(types, ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			filter.Types = append(filter.Types, eventType)
+		}
+	}
+	for _, idStr := range strings.Split(devices, ",") {
+		if idStr = strings.TrimSpace(idStr); idStr == "" {
+			continue
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id <= 0 {
+			return filter, fmt.Errorf("invalid device ID %q", idStr)
+		}
+		filter.DeviceIDs = append(filter.DeviceIDs, id)
+	}
+	return filter, validateStreamFilter(filter)
+}
+
+func validateStreamFilter(filter events.Filter) error {
+	for _, eventType := range filter.Types {
+		if !events.IsKnownType(eventType) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
@@ -1,20 +1,42 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
+	"edgefleet-commander/internal/codec"
+	"edgefleet-commander/internal/ingest"
+	"edgefleet-commander/internal/middleware"
 	"edgefleet-commander/internal/models"
 	"edgefleet-commander/internal/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type TelemetryHandler struct {
 	telemetryService *services.TelemetryService
+	pipeline         *ingest.Pipeline
+	batchMaxItems    int
+	batchMaxBytes    int64
 }
 
-func NewTelemetryHandler(telemetryService *services.TelemetryService) *TelemetryHandler {
-	return &TelemetryHandler{telemetryService: telemetryService}
+// NewTelemetryHandler creates the telemetry endpoints. Readings are written
+// through the ingest pipeline. Batch uploads are limited to batchMaxItems
+// readings and batchMaxBytes of request body.
+func NewTelemetryHandler(telemetryService *services.TelemetryService, pipeline *ingest.Pipeline, batchMaxItems int, batchMaxBytes int64) *TelemetryHandler {
+	return &TelemetryHandler{
+		telemetryService: telemetryService,
+		pipeline:         pipeline,
+		batchMaxItems:    batchMaxItems,
+		batchMaxBytes:    batchMaxBytes,
+	}
 }
 
 func (h *TelemetryHandler) GetAllTelemetry(c *gin.Context) {
@@ -60,17 +82,325 @@ func (h *TelemetryHandler) GetDeviceTelemetry(c *gin.Context) {
 	c.JSON(http.StatusOK, telemetry)
 }
 
+// CreateTelemetry stores one reading, sent as JSON, CBOR or protobuf and
+// optionally gzipped
 func (h *TelemetryHandler) CreateTelemetry(c *gin.Context) {
+	body, err := readBody(c, h.batchMaxBytes)
+	if err != nil {
+		if bodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body exceeds %d bytes", h.batchMaxBytes)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var telemetry models.Telemetry
-	if err := c.ShouldBindJSON(&telemetry); err != nil {
+	switch c.ContentType() {
+	case contentTypeCBOR:
+		var jsonBody []byte
+		if jsonBody, err = codec.CBORToJSON(body); err == nil {
+			err = binding.JSON.BindBody(jsonBody, &telemetry)
+		}
+	case contentTypeProtobuf, "application/protobuf":
+		telemetry, err = codec.DecodeReading(body)
+	default:
+		err = binding.JSON.BindBody(body, &telemetry)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := messageIDFromHeader(c, &telemetry.MessageID); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if telemetry.DeviceID, err = claimDevice(c, telemetry.DeviceID); err != nil {
+		respondClaimError(c, err)
+		return
+	}
+	telemetry.Signature = middleware.SignatureStatus(c)
+
+	if respondAsync(c) {
+		if err := h.pipeline.SubmitAsync([]models.Telemetry{telemetry}); err != nil {
+			backpressure(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"status": "queued", "readings": 1})
+		return
+	}
+
+	batch := []models.Telemetry{telemetry}
+	itemErrs, replayed, err := h.pipeline.Submit(batch)
+	if err == nil {
+		err = itemErrs[0]
+	}
+	if errors.Is(err, ingest.ErrSpooled) {
+		// Validated and stored once Redis is back
+		c.JSON(http.StatusAccepted, gin.H{"status": "spooled", "readings": 1})
+		return
+	}
+	if err != nil {
+		if backpressure(c, err) {
+			return
+		}
+		var validationErr *services.ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrMessageInFlight):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(createdStatus(c, replayed[0]), batch[0])
+}
+
+func (h *TelemetryHandler) GetDeviceMetricNames(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	names, err := h.telemetryService.GetDeviceMetricNames(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, names)
+}
+
+func (h *TelemetryHandler) GetMetricSeries(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	hours := 24 // default window
+	if hoursStr := c.Query("hours"); hoursStr != "" {
+		if parsedHours, err := strconv.Atoi(hoursStr); err == nil && parsedHours > 0 {
+			hours = parsedHours
+		}
+	}
+	limit := 500 // default limit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
 
-	if err := h.telemetryService.CreateTelemetry(&telemetry); err != nil {
+	points, err := h.telemetryService.GetMetricSeries(int(id), c.Param("metric"), hours, limit)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, points)
+}
+
+// Content types of the compact telemetry encodings
+const (
+	contentTypeCBOR     = "application/cbor"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+var errBatchTooLarge = codec.ErrTooManyReadings
+
+// CreateTelemetryBatch stores many readings at once. The body is a JSON
+// array, NDJSON (one JSON object per line), a CBOR array or a protobuf
+// ReadingBatch, optionally gzipped. Each reading is validated on its own and
+// reported in the response.
+func (h *TelemetryHandler) CreateTelemetryBatch(c *gin.Context) {
+	var batch []models.Telemetry
+	var decodeErrs []error
+	body, err := readBody(c, h.batchMaxBytes)
+	if err == nil {
+		switch c.ContentType() {
+		case "application/x-ndjson", "application/ndjson":
+			batch, decodeErrs, err = decodeTelemetryNDJSON(bytes.NewReader(body), h.batchMaxItems)
+		case contentTypeCBOR:
+			var jsonBody []byte
+			if jsonBody, err = codec.CBORToJSON(body); err == nil {
+				batch, decodeErrs, err = decodeTelemetryArray(bytes.NewReader(jsonBody), h.batchMaxItems)
+			}
+		case contentTypeProtobuf, "application/protobuf":
+			batch, decodeErrs, err = codec.DecodeReadingBatch(body, h.batchMaxItems)
+		default:
+			batch, decodeErrs, err = decodeTelemetryArray(bytes.NewReader(body), h.batchMaxItems)
+		}
+	}
+	if err != nil {
+		switch {
+		case bodyTooLarge(err):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body exceeds %d bytes", h.batchMaxBytes)})
+		case errors.Is(err, errBatchTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Batch exceeds %d readings", h.batchMaxItems)})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if len(batch) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Batch contains no readings"})
+		return
+	}
 
-	c.JSON(http.StatusCreated, telemetry)
-}
\ No newline at end of file
+	// Only readings that decoded, for the device the request may write for,
+	// go to the service; keep track of where they sit in the original batch
+	var decoded []models.Telemetry
+	var positions []int
+	for i := range batch {
+		if decodeErrs[i] == nil {
+			batch[i].DeviceID, decodeErrs[i] = claimDevice(c, batch[i].DeviceID)
+			batch[i].Signature = middleware.SignatureStatus(c)
+		}
+		if decodeErrs[i] == nil {
+			decoded = append(decoded, batch[i])
+			positions = append(positions, i)
+		}
+	}
+	itemErrs := make([]error, len(batch))
+	copy(itemErrs, decodeErrs)
+	replayed := make([]bool, len(batch))
+	if len(decoded) > 0 && respondAsync(c) {
+		if err := h.pipeline.SubmitAsync(decoded); err != nil {
+			backpressure(c, err)
+			return
+		}
+	}
+	if respondAsync(c) {
+		c.JSON(http.StatusAccepted, pendingBatchResponse(decodeErrs))
+		return
+	}
+	if len(decoded) > 0 {
+		storeErrs, storeReplayed, err := h.pipeline.Submit(decoded)
+		if errors.Is(err, ingest.ErrSpooled) {
+			c.JSON(http.StatusAccepted, pendingBatchResponse(decodeErrs))
+			return
+		}
+		if err != nil {
+			if !backpressure(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		for j, i := range positions {
+			itemErrs[i] = storeErrs[j]
+			replayed[i] = storeReplayed[j]
+			batch[i] = decoded[j]
+		}
+	}
+
+	response := models.TelemetryBatchResponse{Results: make([]models.TelemetryBatchResult, len(batch))}
+	for i := range batch {
+		result := models.TelemetryBatchResult{Index: i}
+		if itemErrs[i] != nil {
+			result.Status = "rejected"
+			result.Error = itemErrs[i].Error()
+			response.Rejected++
+		} else {
+			result.Status = "created"
+			if replayed[i] {
+				result.Status = "duplicate"
+			}
+			result.ID = batch[i].ID
+			result.Flags = batch[i].Flags
+			response.Accepted++
+		}
+		response.Results[i] = result
+	}
+
+	status := http.StatusCreated
+	switch {
+	case response.Accepted == 0:
+		status = http.StatusUnprocessableEntity
+	case response.Rejected > 0:
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, response)
+}
+
+// pendingBatchResponse reports a batch whose readings will be stored later.
+// Only the readings that didn't decode can be reported as rejected yet.
+func pendingBatchResponse(decodeErrs []error) models.TelemetryBatchResponse {
+	response := models.TelemetryBatchResponse{Results: []models.TelemetryBatchResult{}}
+	for i, decodeErr := range decodeErrs {
+		if decodeErr != nil {
+			response.Results = append(response.Results, models.TelemetryBatchResult{Index: i, Status: "rejected", Error: decodeErr.Error()})
+			response.Rejected++
+		} else {
+			response.Accepted++
+		}
+	}
+	return response
+}
+
+// decodeTelemetryArray reads a JSON array of readings. A reading that is
+// valid JSON but doesn't fit the model gets a per-item error; malformed JSON
+// fails the whole batch since item boundaries can't be trusted after it.
+func decodeTelemetryArray(r io.Reader, maxItems int) ([]models.Telemetry, []error, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	} else if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, nil, fmt.Errorf("expected a JSON array of readings")
+	}
+
+	var batch []models.Telemetry
+	var itemErrs []error
+	for dec.More() {
+		if len(batch) >= maxItems {
+			return nil, nil, errBatchTooLarge
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON at reading %d: %w", len(batch), err)
+		}
+		var t models.Telemetry
+		batch = append(batch, t)
+		itemErrs = append(itemErrs, unmarshalReading(raw, &batch[len(batch)-1]))
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return batch, itemErrs, nil
+}
+
+// decodeTelemetryNDJSON reads one reading per line, skipping blank lines.
+// Each line stands alone, so a malformed line only rejects that reading.
+func decodeTelemetryNDJSON(r io.Reader, maxItems int) ([]models.Telemetry, []error, error) {
+	reader := bufio.NewReader(r)
+	var batch []models.Telemetry
+	var itemErrs []error
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if len(batch) >= maxItems {
+				return nil, nil, errBatchTooLarge
+			}
+			var t models.Telemetry
+			batch = append(batch, t)
+			itemErrs = append(itemErrs, unmarshalReading(trimmed, &batch[len(batch)-1]))
+		}
+		if err == io.EOF {
+			return batch, itemErrs, nil
+		}
+	}
+}
+
+func unmarshalReading(data []byte, t *models.Telemetry) error {
+	if err := json.Unmarshal(data, t); err != nil {
+		return fmt.Errorf("invalid reading: %s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return nil
+}
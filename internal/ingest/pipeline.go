@@ -0,0 +1,384 @@
+// Package ingest queues telemetry writes from the HTTP endpoints so that a
+// small pool of workers stores them in batched, pipelined round trips,
+// instead of every request talking to Redis on its own. While Redis is
+// unavailable the readings go to an on-disk spool and are replayed later.
+package ingest
+
+import (
+	"context"
+	"edgefleet-commander/internal/models"
+	"edgefleet-commander/internal/services"
+	"edgefleet-commander/internal/spool"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options sizes the pipeline
+type Options struct {
+	// QueueSize is how many readings may wait to be written
+	QueueSize int
+	Workers   int
+	// MaxBatch is how many readings a worker gathers into one write
+	MaxBatch int
+	// Linger is how long a worker waits for more readings to fill a batch;
+	// 0 takes only what is already queued
+	Linger time.Duration
+	// Timeout is how long a request waits for its readings to be written
+	Timeout time.Duration
+}
+
+var (
+	// ErrQueueFull is returned by Submit when the queue can't take the
+	// readings; the client should back off and retry
+	ErrQueueFull = errors.New("ingest queue is full")
+	// ErrTimeout is returned by Submit when the readings were queued but not
+	// written in time. They may still be written later.
+	ErrTimeout = errors.New("timed out waiting for telemetry to be written")
+	// ErrSpooled is returned by Submit when the store was unavailable and
+	// the readings were saved to the spool instead. They are validated and
+	// stored when the store is back.
+	ErrSpooled = errors.New("telemetry store unavailable; readings spooled for later")
+)
+
+// replayInterval is how often the spool is checked for readings to replay
+const replayInterval = time.Second
+
+// latencySamples is how many recent write latencies are kept for the
+// percentiles in Stats
+const latencySamples = 1024
+
+type job struct {
+	readings []models.Telemetry
+	enqueued time.Time
+	// done is nil when nobody waits for the outcome
+	done chan result
+}
+
+type result struct {
+	itemErrs []error
+	replayed []bool
+	err      error
+}
+
+// Pipeline is a bounded queue of readings in front of the telemetry service
+type Pipeline struct {
+	telemetry *services.TelemetryService
+	// spool is nil when spooling is off
+	spool   *spool.Spool
+	options Options
+	jobs    chan *job
+	// spoolMu keeps batches in order around the spool: writes straight to
+	// the store hold it shared, and spooling a batch holds it exclusively,
+	// so no batch can be stored directly while another is being spooled
+	// ahead of it
+	spoolMu sync.RWMutex
+
+	mu        sync.Mutex
+	queued    int
+	stats     models.IngestStats
+	latencies []time.Duration
+	next      int
+}
+
+// New creates a pipeline in front of the telemetry service. wal may be nil,
+// in which case writes fail while the store is unavailable.
+func New(telemetry *services.TelemetryService, wal *spool.Spool, options Options) *Pipeline {
+	options.Workers = max(options.Workers, 1)
+	options.MaxBatch = max(options.MaxBatch, 1)
+	options.QueueSize = max(options.QueueSize, 1)
+	return &Pipeline{
+		telemetry: telemetry,
+		spool:     wal,
+		options:   options,
+		jobs:      make(chan *job, options.QueueSize),
+		latencies: make([]time.Duration, 0, latencySamples),
+	}
+}
+
+// Run starts the workers, and the spool replay, and blocks until ctx is done
+func (p *Pipeline) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	if p.spool != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.replay(ctx)
+		}()
+	}
+	for i := 0; i < p.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Submit queues readings and waits for them to be written, returning the
+// same per-reading outcome as TelemetryService.CreateTelemetryBatchDeduped,
+// with the readings updated in place.
+func (p *Pipeline) Submit(readings []models.Telemetry) ([]error, []bool, error) {
+	j := &job{readings: readings, enqueued: time.Now(), done: make(chan result, 1)}
+	if err := p.enqueue(j); err != nil {
+		return nil, nil, err
+	}
+
+	timer := time.NewTimer(p.options.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-j.done:
+		return r.itemErrs, r.replayed, r.err
+	case <-timer.C:
+		p.mu.Lock()
+		p.stats.TimedOut++
+		p.mu.Unlock()
+		return nil, nil, ErrTimeout
+	}
+}
+
+// SubmitAsync queues readings without waiting for them to be written.
+// Readings that fail validation are only counted in Stats.
+func (p *Pipeline) SubmitAsync(readings []models.Telemetry) error {
+	return p.enqueue(&job{readings: readings, enqueued: time.Now()})
+}
+
+// enqueue reserves queue space for a job. A job larger than the whole queue
+// is still taken when the queue is empty, so big batches can't starve.
+func (p *Pipeline) enqueue(j *job) error {
+	p.mu.Lock()
+	if p.queued > 0 && p.queued+len(j.readings) > p.options.QueueSize {
+		p.stats.Throttled++
+		p.mu.Unlock()
+		return ErrQueueFull
+	}
+	p.queued += len(j.readings)
+	p.mu.Unlock()
+
+	select {
+	case p.jobs <- j:
+		return nil
+	default:
+		p.mu.Lock()
+		p.queued -= len(j.readings)
+		p.stats.Throttled++
+		p.mu.Unlock()
+		return ErrQueueFull
+	}
+}
+
+func (p *Pipeline) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case first := <-p.jobs:
+			p.write(p.gather(first))
+		}
+	}
+}
+
+// gather collects queued jobs behind first until the batch is full or the
+// linger time is up. With no linger it takes only what is already queued,
+// so batches grow with load without delaying a lone request.
+func (p *Pipeline) gather(first *job) []*job {
+	jobs := []*job{first}
+	size := len(first.readings)
+	var linger <-chan time.Time
+	if p.options.Linger > 0 {
+		timer := time.NewTimer(p.options.Linger)
+		defer timer.Stop()
+		linger = timer.C
+	}
+	for size < p.options.MaxBatch {
+		if linger == nil {
+			select {
+			case j := <-p.jobs:
+				jobs = append(jobs, j)
+				size += len(j.readings)
+				continue
+			default:
+				return jobs
+			}
+		}
+		select {
+		case j := <-p.jobs:
+			jobs = append(jobs, j)
+			size += len(j.readings)
+		case <-linger:
+			return jobs
+		}
+	}
+	return jobs
+}
+
+// write stores the readings of several jobs in one batch and hands each job
+// its share of the outcome. If the store can't be written, or earlier
+// readings are still spooled, the batch is spooled behind them so readings
+// are stored in the order they arrived.
+func (p *Pipeline) write(jobs []*job) {
+	var batch []models.Telemetry
+	for _, j := range jobs {
+		batch = append(batch, j.readings...)
+	}
+	itemErrs, replayed, spooled, err := p.store(batch)
+	finished := time.Now()
+
+	p.mu.Lock()
+	p.stats.Batches++
+	offset := 0
+	for _, j := range jobs {
+		n := len(j.readings)
+		p.queued -= n
+		p.recordLatency(finished.Sub(j.enqueued))
+		r := result{err: err}
+		if err == nil {
+			copy(j.readings, batch[offset:offset+n])
+			r.itemErrs = itemErrs[offset : offset+n]
+			r.replayed = replayed[offset : offset+n]
+			for _, itemErr := range r.itemErrs {
+				if itemErr == nil {
+					p.stats.Accepted++
+				} else {
+					p.stats.Rejected++
+				}
+			}
+		} else if spooled {
+			p.stats.Spooled += int64(n)
+		} else {
+			p.stats.Failed += int64(n)
+		}
+		offset += n
+		if j.done != nil {
+			j.done <- r
+		}
+	}
+	p.mu.Unlock()
+}
+
+// store writes a batch to the store while nothing is spooled, and otherwise,
+// or if the store fails, spools it behind the readings already there. It
+// reports whether the batch was spooled.
+func (p *Pipeline) store(batch []models.Telemetry) ([]error, []bool, bool, error) {
+	if p.spool == nil {
+		itemErrs, replayed, err := p.telemetry.CreateTelemetryBatchDeduped(batch)
+		return itemErrs, replayed, false, err
+	}
+
+	var err error
+	p.spoolMu.RLock()
+	if !p.spool.Pending() {
+		var itemErrs []error
+		var replayed []bool
+		itemErrs, replayed, err = p.telemetry.CreateTelemetryBatchDeduped(batch)
+		if err == nil {
+			p.spoolMu.RUnlock()
+			return itemErrs, replayed, false, nil
+		}
+	}
+	p.spoolMu.RUnlock()
+
+	// Writers waiting for the lock hold back new direct writes, and once the
+	// batch is appended they find the spool pending and queue up behind it
+	p.spoolMu.Lock()
+	defer p.spoolMu.Unlock()
+	if spoolErr := p.spool.Append(batch); spoolErr != nil {
+		log.Printf("Warning: failed to spool %d readings: %v", len(batch), spoolErr)
+		if err == nil {
+			err = spoolErr
+		}
+		return nil, nil, false, err
+	}
+	return nil, nil, true, ErrSpooled
+}
+
+// replay stores spooled readings whenever there are any, until ctx is done.
+// A batch stays spooled until the store takes it, so replay resumes where it
+// stopped after each failed attempt.
+func (p *Pipeline) replay(ctx context.Context) {
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !p.spool.Pending() {
+			continue
+		}
+		batches, err := p.spool.Replay(func(batch []models.Telemetry) error {
+			itemErrs, _, err := p.telemetry.ReplayTelemetryBatch(batch)
+			if err != nil {
+				return err
+			}
+			p.mu.Lock()
+			for i, itemErr := range itemErrs {
+				if itemErr == nil {
+					p.stats.Replayed++
+				} else {
+					// Nobody is waiting for the outcome any more
+					p.stats.Rejected++
+					log.Printf("Warning: dropped spooled reading for device %d: %v", batch[i].DeviceID, itemErr)
+				}
+			}
+			p.mu.Unlock()
+			return nil
+		})
+		if batches > 0 {
+			log.Printf("Replayed %d spooled telemetry batches", batches)
+		}
+		if err != nil && batches > 0 {
+			log.Printf("Spool replay stopped: %v", err)
+		}
+	}
+}
+
+// SpoolStats reports what is waiting in the spool
+func (p *Pipeline) SpoolStats() models.SpoolStats {
+	if p.spool == nil {
+		return models.SpoolStats{}
+	}
+	return p.spool.Stats()
+}
+
+// recordLatency keeps the last latencySamples latencies; p.mu must be held
+func (p *Pipeline) recordLatency(d time.Duration) {
+	if len(p.latencies) < latencySamples {
+		p.latencies = append(p.latencies, d)
+	} else {
+		p.latencies[p.next] = d
+	}
+	p.next = (p.next + 1) % latencySamples
+}
+
+// Stats reports the queue depth, outcome counters and the latency from
+// queueing to written over recent requests
+func (p *Pipeline) Stats() models.IngestStats {
+	p.mu.Lock()
+	stats := p.stats
+	stats.QueueDepth = p.queued
+	stats.QueueCapacity = p.options.QueueSize
+	stats.Workers = p.options.Workers
+	sorted := append([]time.Duration(nil), p.latencies...)
+	p.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(q float64) float64 {
+		if len(sorted) == 0 {
+			return 0
+		}
+		return float64(sorted[int(q*float64(len(sorted)-1))].Microseconds()) / 1000
+	}
+	stats.Latency = models.IngestLatency{
+		Samples: len(sorted),
+		P50Ms:   percentile(0.50),
+		P95Ms:   percentile(0.95),
+		P99Ms:   percentile(0.99),
+		MaxMs:   percentile(1),
+	}
+	return stats
+}
@@ -0,0 +1,186 @@
+// Package lineprotocol parses InfluxDB line protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+package lineprotocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point is one parsed line
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]Field
+	// Time is zero when the line has no timestamp
+	Time time.Time
+}
+
+// Field is a field value. Numbers and booleans are in Value with Numeric
+// set; strings are in Text.
+type Field struct {
+	Numeric bool
+	Value   float64
+	Text    string
+}
+
+// Precision returns the unit of timestamps for a precision query parameter,
+// accepting both the v1 (n, u, ms, s, m, h) and v2 (ns, us, ms, s) names.
+// An empty precision means nanoseconds.
+func Precision(name string) (time.Duration, error) {
+	switch name {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	return 0, fmt.Errorf("invalid precision %q", name)
+}
+
+// Parse parses a body of lines. Blank lines and comments are skipped. The
+// error names the first line that doesn't parse.
+func Parse(body string, precision time.Duration) ([]Point, error) {
+	var points []Point
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		point, err := parseLine(line, precision)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+func parseLine(line string, precision time.Duration) (Point, error) {
+	// The line splits on its first two unescaped, unquoted spaces into
+	// series key, fields and timestamp
+	key, rest := splitUnescaped(line, ' ', false)
+	if rest == "" {
+		return Point{}, fmt.Errorf("missing fields")
+	}
+	fields, timestamp := splitUnescaped(rest, ' ', true)
+
+	point := Point{Tags: map[string]string{}, Fields: map[string]Field{}}
+	parts := splitAll(key, ',', false)
+	point.Measurement = unescape(parts[0])
+	if point.Measurement == "" {
+		return Point{}, fmt.Errorf("missing measurement")
+	}
+	for _, tag := range parts[1:] {
+		k, v := splitUnescaped(tag, '=', false)
+		if k == "" || v == "" {
+			return Point{}, fmt.Errorf("invalid tag %q", tag)
+		}
+		point.Tags[unescape(k)] = unescape(v)
+	}
+
+	for _, field := range splitAll(fields, ',', true) {
+		k, v := splitUnescaped(field, '=', false)
+		if k == "" || v == "" {
+			return Point{}, fmt.Errorf("invalid field %q", field)
+		}
+		value, err := parseFieldValue(v)
+		if err != nil {
+			return Point{}, fmt.Errorf("field %q: %w", unescape(k), err)
+		}
+		point.Fields[unescape(k)] = value
+	}
+
+	if timestamp = strings.TrimSpace(timestamp); timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		point.Time = time.Unix(0, 0).Add(time.Duration(ts) * precision)
+		if precision > time.Nanosecond && ts != int64(time.Duration(ts)*precision/precision) {
+			return Point{}, fmt.Errorf("timestamp %q out of range", timestamp)
+		}
+	}
+	return point, nil
+}
+
+func parseFieldValue(v string) (Field, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+			return Field{}, fmt.Errorf("unterminated string")
+		}
+		text := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1])
+		return Field{Text: text}, nil
+	case strings.HasSuffix(v, "i"):
+		n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return Field{}, fmt.Errorf("invalid integer %q", v)
+		}
+		return Field{Numeric: true, Value: float64(n)}, nil
+	case strings.HasSuffix(v, "u"):
+		n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return Field{}, fmt.Errorf("invalid unsigned integer %q", v)
+		}
+		return Field{Numeric: true, Value: float64(n)}, nil
+	}
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return Field{Numeric: true, Value: 1}, nil
+	case "f", "F", "false", "False", "FALSE":
+		return Field{Numeric: true, Value: 0}, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return Field{}, fmt.Errorf("invalid value %q", v)
+	}
+	return Field{Numeric: true, Value: f}, nil
+}
+
+// splitUnescaped splits s at the first sep that isn't backslash-escaped (or,
+// if quoted, inside a double-quoted string)
+func splitUnescaped(s string, sep byte, quoted bool) (string, string) {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quoted:
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+func splitAll(s string, sep byte, quoted bool) []string {
+	var parts []string
+	for {
+		part, rest := splitUnescaped(s, sep, quoted)
+		parts = append(parts, part)
+		if len(rest) == 0 && len(part) == len(s) {
+			return parts
+		}
+		s = rest
+	}
+}
+
+// unescape removes the backslashes before commas, spaces and equals signs
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=").Replace(s)
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth guards the routes that hand out or take away device secrets:
+// credentials, certificates, signing keys and enrollment. The operator sends
+// the admin token as "Authorization: Bearer <token>". With no token
+// configured the routes are turned off.
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Device credential management is disabled; set ADMIN_TOKEN to enable it"})
+			return
+		}
+		scheme, presented, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(presented)), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="edgefleet-admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "A valid admin token is required"})
+			return
+		}
+		c.Next()
+	}
+}
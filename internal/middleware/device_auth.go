@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"edgefleet-commander/internal/services"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deviceIDKey is where DeviceAuth leaves the authenticated device ID, and
+// credentialsKey and certificatesKey the services AllowAnonymous checks
+// devices with
+const (
+	deviceIDKey     = "deviceID"
+	credentialsKey  = "credentials"
+	certificatesKey = "certificates"
+)
+
+// DeviceAuth requires a device credential on ingestion routes. A device
+// presents a client certificate over mutual TLS, its token as
+// "Authorization: Bearer <token>" (or "Token <token>", as InfluxDB clients
+// send it), or uses HTTP Basic auth with its ID as the username and the token
+// as the password, as over MQTT. When required is false requests without
+// credentials pass through anonymously, but bad credentials are still
+// refused, and handlers call AllowAnonymous for the devices readings name.
+func DeviceAuth(credentials *services.CredentialService, certificates *services.CertificateService, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(credentialsKey, credentials)
+		c.Set(certificatesKey, certificates)
+		deviceID, presented, err := authenticateDevice(c, credentials, certificates)
+		if err != nil {
+			log.Printf("Failed to verify device credential: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify credential"})
+			return
+		}
+		if deviceID == 0 && (presented || required) {
+			c.Header("WWW-Authenticate", `Bearer realm="edgefleet"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "A valid device credential is required"})
+			return
+		}
+		if deviceID != 0 {
+			c.Set(deviceIDKey, deviceID)
+		}
+		c.Next()
+	}
+}
+
+// authenticateDevice returns the device whose credential the request
+// carries, or 0, and whether it carried any. A client certificate, already
+// verified during the handshake, takes precedence.
+func authenticateDevice(c *gin.Context, credentials *services.CredentialService, certificates *services.CertificateService) (int, bool, error) {
+	if state := c.Request.TLS; state != nil && len(state.VerifiedChains) > 0 {
+		deviceID, err := certificates.AuthenticateCertificate(state.VerifiedChains[0][0])
+		return deviceID, true, err
+	}
+
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		deviceID, err := strconv.Atoi(username)
+		if err != nil || deviceID <= 0 || strconv.Itoa(deviceID) != username {
+			return 0, true, nil
+		}
+		valid, err := credentials.VerifyCredential(deviceID, password)
+		if err != nil || !valid {
+			return 0, true, err
+		}
+		return deviceID, true, nil
+	}
+
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return 0, false, nil
+	}
+	scheme, token, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") && !strings.EqualFold(scheme, "Token") {
+		return 0, true, nil
+	}
+	deviceID, err := credentials.Authenticate(strings.TrimSpace(token))
+	return deviceID, true, err
+}
+
+// AllowAnonymous returns services.ErrCredentialRequired if an anonymous
+// request may not write for deviceID: the device has a token or a valid
+// certificate, so it is expected to present it. Only devices without
+// credentials can still report anonymously when DEVICE_AUTH=optional.
+func AllowAnonymous(c *gin.Context, deviceID int) error {
+	if credentials, ok := c.Value(credentialsKey).(*services.CredentialService); ok {
+		has, err := credentials.HasCredential(deviceID)
+		if err != nil {
+			return err
+		}
+		if has {
+			return fmt.Errorf("%w: device %d", services.ErrCredentialRequired, deviceID)
+		}
+	}
+	if certificates, ok := c.Value(certificatesKey).(*services.CertificateService); ok {
+		has, err := certificates.HasValidCertificate(deviceID)
+		if err != nil {
+			return err
+		}
+		if has {
+			return fmt.Errorf("%w: device %d", services.ErrCredentialRequired, deviceID)
+		}
+	}
+	return nil
+}
+
+// AuthenticatedDevice returns the device DeviceAuth authenticated the
+// request as, if any
+func AuthenticatedDevice(c *gin.Context) (int, bool) {
+	deviceID, ok := c.Get(deviceIDKey)
+	if !ok {
+		return 0, false
+	}
+	return deviceID.(int), true
+}
@@ -0,0 +1,162 @@
+// Package modbus is a minimal Modbus TCP client covering the read functions
+// the collector needs.
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Function codes
+const (
+	ReadCoils            = 0x01
+	ReadDiscreteInputs   = 0x02
+	ReadHoldingRegisters = 0x03
+	ReadInputRegisters   = 0x04
+)
+
+// maxRegisters and maxBits are the largest quantities one request may read
+const (
+	maxRegisters = 125
+	maxBits      = 2000
+)
+
+// Exception is an error response from the device
+type Exception struct {
+	Function byte
+	Code     byte
+}
+
+func (e *Exception) Error() string {
+	var reason string
+	switch e.Code {
+	case 0x01:
+		reason = "illegal function"
+	case 0x02:
+		reason = "illegal data address"
+	case 0x03:
+		reason = "illegal data value"
+	case 0x04:
+		reason = "server device failure"
+	case 0x06:
+		reason = "server device busy"
+	case 0x0A:
+		reason = "gateway path unavailable"
+	case 0x0B:
+		reason = "gateway target device failed to respond"
+	default:
+		reason = fmt.Sprintf("exception 0x%02x", e.Code)
+	}
+	return fmt.Sprintf("modbus function 0x%02x: %s", e.Function, reason)
+}
+
+// Client talks to one unit over a Modbus TCP connection. It is not safe for
+// concurrent use.
+type Client struct {
+	conn          net.Conn
+	unitID        byte
+	timeout       time.Duration
+	transactionID uint16
+}
+
+// Dial connects to a Modbus TCP server. addr is host:port.
+func Dial(addr string, unitID byte, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, unitID: unitID, timeout: timeout}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// ReadRegisters reads quantity 16-bit registers with ReadHoldingRegisters or
+// ReadInputRegisters
+func (c *Client) ReadRegisters(function byte, address, quantity uint16) ([]uint16, error) {
+	if quantity == 0 || quantity > maxRegisters {
+		return nil, fmt.Errorf("cannot read %d registers in one request", quantity)
+	}
+	data, err := c.read(function, address, quantity)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(quantity)*2 {
+		return nil, fmt.Errorf("expected %d register bytes, got %d", quantity*2, len(data))
+	}
+	registers := make([]uint16, quantity)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+	return registers, nil
+}
+
+// ReadBits reads quantity coils or discrete inputs with ReadCoils or
+// ReadDiscreteInputs
+func (c *Client) ReadBits(function byte, address, quantity uint16) ([]bool, error) {
+	if quantity == 0 || quantity > maxBits {
+		return nil, fmt.Errorf("cannot read %d bits in one request", quantity)
+	}
+	data, err := c.read(function, address, quantity)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != (int(quantity)+7)/8 {
+		return nil, fmt.Errorf("expected %d bit bytes, got %d", (quantity+7)/8, len(data))
+	}
+	bits := make([]bool, quantity)
+	for i := range bits {
+		bits[i] = data[i/8]&(1<<(i%8)) != 0
+	}
+	return bits, nil
+}
+
+// read sends one request and returns the data bytes of the response
+func (c *Client) read(function byte, address, quantity uint16) ([]byte, error) {
+	c.transactionID++
+	request := make([]byte, 12)
+	binary.BigEndian.PutUint16(request[0:], c.transactionID)
+	binary.BigEndian.PutUint16(request[2:], 0) // protocol identifier
+	binary.BigEndian.PutUint16(request[4:], 6) // unit ID + PDU
+	request[6] = c.unitID
+	request[7] = function
+	binary.BigEndian.PutUint16(request[8:], address)
+	binary.BigEndian.PutUint16(request[10:], quantity)
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint16(header[4:])
+	if length < 3 || length > 254 {
+		return nil, fmt.Errorf("invalid response length %d", length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(c.conn, pdu); err != nil {
+		return nil, err
+	}
+	if id := binary.BigEndian.Uint16(header[0:]); id != c.transactionID {
+		return nil, fmt.Errorf("response for transaction %d, expected %d", id, c.transactionID)
+	}
+
+	switch {
+	case pdu[0] == function|0x80:
+		return nil, &Exception{Function: function, Code: pdu[1]}
+	case pdu[0] != function:
+		return nil, fmt.Errorf("response for function 0x%02x, expected 0x%02x", pdu[0], function)
+	case len(pdu) < 2 || int(pdu[1]) != len(pdu)-2:
+		return nil, fmt.Errorf("malformed response")
+	}
+	return pdu[2:], nil
+}
@@ -0,0 +1,260 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeServer is a Modbus TCP server holding one unit's tables in memory.
+// Reads outside a table get an illegal data address exception, unknown
+// functions an illegal function exception.
+type fakeServer struct {
+	holding  []uint16
+	input    []uint16
+	coils    []bool
+	discrete []bool
+}
+
+// start serves on a local port until the test ends and returns its address
+func (s *fakeServer) start(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	request := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		function := request[7]
+		address := int(binary.BigEndian.Uint16(request[8:]))
+		quantity := int(binary.BigEndian.Uint16(request[10:]))
+
+		var pdu []byte
+		switch function {
+		case ReadHoldingRegisters, ReadInputRegisters:
+			table := s.holding
+			if function == ReadInputRegisters {
+				table = s.input
+			}
+			if address+quantity > len(table) {
+				pdu = []byte{function | 0x80, 0x02}
+				break
+			}
+			pdu = []byte{function, byte(quantity * 2)}
+			for _, register := range table[address : address+quantity] {
+				pdu = binary.BigEndian.AppendUint16(pdu, register)
+			}
+		case ReadCoils, ReadDiscreteInputs:
+			table := s.coils
+			if function == ReadDiscreteInputs {
+				table = s.discrete
+			}
+			if address+quantity > len(table) {
+				pdu = []byte{function | 0x80, 0x02}
+				break
+			}
+			data := make([]byte, (quantity+7)/8)
+			for i, bit := range table[address : address+quantity] {
+				if bit {
+					data[i/8] |= 1 << (i % 8)
+				}
+			}
+			pdu = append([]byte{function, byte(len(data))}, data...)
+		default:
+			pdu = []byte{function | 0x80, 0x01}
+		}
+
+		response := make([]byte, 7, 7+len(pdu))
+		copy(response[0:2], request[0:2])
+		binary.BigEndian.PutUint16(response[4:], uint16(len(pdu)+1))
+		response[6] = request[6]
+		if _, err := conn.Write(append(response, pdu...)); err != nil {
+			return
+		}
+	}
+}
+
+func dial(t *testing.T, addr string) *Client {
+	t.Helper()
+	client, err := Dial(addr, 1, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestReadRegisters(t *testing.T) {
+	server := &fakeServer{
+		holding: []uint16{10, 20, 30, 40},
+		input:   []uint16{0xFFFF, 7},
+	}
+	client := dial(t, server.start(t))
+
+	registers, err := client.ReadRegisters(ReadHoldingRegisters, 1, 3)
+	if err != nil {
+		t.Fatalf("read holding registers: %v", err)
+	}
+	if want := []uint16{20, 30, 40}; !equalRegisters(registers, want) {
+		t.Errorf("holding registers = %v, want %v", registers, want)
+	}
+
+	registers, err = client.ReadRegisters(ReadInputRegisters, 0, 2)
+	if err != nil {
+		t.Fatalf("read input registers: %v", err)
+	}
+	if want := []uint16{0xFFFF, 7}; !equalRegisters(registers, want) {
+		t.Errorf("input registers = %v, want %v", registers, want)
+	}
+
+	if _, err := client.ReadRegisters(ReadHoldingRegisters, 0, maxRegisters+1); err == nil {
+		t.Error("reading more than the maximum registers succeeded")
+	}
+}
+
+func TestReadBits(t *testing.T) {
+	server := &fakeServer{
+		coils:    []bool{true, false, true, true, false, false, false, false, true, true},
+		discrete: []bool{false, true},
+	}
+	client := dial(t, server.start(t))
+
+	bits, err := client.ReadBits(ReadCoils, 0, 10)
+	if err != nil {
+		t.Fatalf("read coils: %v", err)
+	}
+	for i, want := range server.coils {
+		if bits[i] != want {
+			t.Errorf("coil %d = %v, want %v", i, bits[i], want)
+		}
+	}
+
+	bits, err = client.ReadBits(ReadDiscreteInputs, 1, 1)
+	if err != nil {
+		t.Fatalf("read discrete inputs: %v", err)
+	}
+	if !bits[0] {
+		t.Error("discrete input 1 = false, want true")
+	}
+}
+
+func TestReadException(t *testing.T) {
+	server := &fakeServer{holding: []uint16{1, 2}}
+	client := dial(t, server.start(t))
+
+	tests := []struct {
+		name     string
+		function byte
+		address  uint16
+		code     byte
+	}{
+		{"illegal data address", ReadHoldingRegisters, 5, 0x02},
+		{"illegal function", 0x2B, 0, 0x01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.read(tt.function, tt.address, 1)
+			var exception *Exception
+			if !errors.As(err, &exception) {
+				t.Fatalf("err = %v, want an Exception", err)
+			}
+			if exception.Function != tt.function || exception.Code != tt.code {
+				t.Errorf("exception = %+v, want function 0x%02x code 0x%02x", exception, tt.function, tt.code)
+			}
+		})
+	}
+
+	// The connection is still usable after an exception
+	if _, err := client.ReadRegisters(ReadHoldingRegisters, 0, 2); err != nil {
+		t.Errorf("read after exception: %v", err)
+	}
+}
+
+func TestDecodeRegisters(t *testing.T) {
+	pi := math.Float32bits(3.14159)
+	tests := []struct {
+		valueType string
+		wordOrder string
+		registers []uint16
+		want      float64
+	}{
+		{"", "", []uint16{42}, 42},
+		{"uint16", "", []uint16{0xFFFF}, 65535},
+		{"int16", "", []uint16{0xFFFE}, -2},
+		{"uint32", "big", []uint16{0x0001, 0x0002}, 65538},
+		{"uint32", "little", []uint16{0x0002, 0x0001}, 65538},
+		{"int32", "big", []uint16{0xFFFF, 0xFFFB}, -5},
+		{"int32", "little", []uint16{0xFFFB, 0xFFFF}, -5},
+		{"float32", "big", []uint16{uint16(pi >> 16), uint16(pi)}, float64(float32(3.14159))},
+		{"float32", "little", []uint16{uint16(pi), uint16(pi >> 16)}, float64(float32(3.14159))},
+	}
+	for _, tt := range tests {
+		got, err := DecodeRegisters(tt.registers, tt.valueType, tt.wordOrder)
+		if err != nil {
+			t.Errorf("DecodeRegisters(%v, %q, %q): %v", tt.registers, tt.valueType, tt.wordOrder, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("DecodeRegisters(%v, %q, %q) = %v, want %v", tt.registers, tt.valueType, tt.wordOrder, got, tt.want)
+		}
+	}
+
+	if _, err := DecodeRegisters([]uint16{1}, "float32", "big"); err == nil {
+		t.Error("decoding float32 from one register succeeded")
+	}
+	if _, err := DecodeRegisters([]uint16{1}, "string", ""); err == nil {
+		t.Error("decoding an unsupported type succeeded")
+	}
+}
+
+func TestReadAndDecode(t *testing.T) {
+	bits := math.Float32bits(-12.5)
+	server := &fakeServer{input: []uint16{0, uint16(bits), uint16(bits >> 16)}}
+	client := dial(t, server.start(t))
+
+	registers, err := client.ReadRegisters(ReadInputRegisters, 1, RegisterCount("float32"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	value, err := DecodeRegisters(registers, "float32", "little")
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if value != -12.5 {
+		t.Errorf("value = %v, want -12.5", value)
+	}
+}
+
+func equalRegisters(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
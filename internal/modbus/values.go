@@ -0,0 +1,46 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+)
+
+// RegisterCount is how many 16-bit registers a value of the given type
+// occupies
+func RegisterCount(valueType string) uint16 {
+	switch valueType {
+	case "uint32", "int32", "float32":
+		return 2
+	}
+	return 1
+}
+
+// DecodeRegisters interprets registers as a value of the given type. With
+// word order "little" the low word of a 32-bit value comes first.
+func DecodeRegisters(registers []uint16, valueType, wordOrder string) (float64, error) {
+	if len(registers) < int(RegisterCount(valueType)) {
+		return 0, fmt.Errorf("%s needs %d registers", valueType, RegisterCount(valueType))
+	}
+	var wide uint32
+	if len(registers) >= 2 {
+		if wordOrder == "little" {
+			wide = uint32(registers[1])<<16 | uint32(registers[0])
+		} else {
+			wide = uint32(registers[0])<<16 | uint32(registers[1])
+		}
+	}
+
+	switch valueType {
+	case "", "uint16":
+		return float64(registers[0]), nil
+	case "int16":
+		return float64(int16(registers[0])), nil
+	case "uint32":
+		return float64(wide), nil
+	case "int32":
+		return float64(int32(wide)), nil
+	case "float32":
+		return float64(math.Float32frombits(wide)), nil
+	}
+	return 0, fmt.Errorf("unsupported register type %q", valueType)
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+        "encoding/json"
+        "fmt"
         "time"
 )
 
@@ -11,6 +13,171 @@ type Device struct {
         Location     string    `json:"location"`
         Status       string    `json:"status"`
         RegisteredAt time.Time `json:"registeredAt"`
+        ParentID     *int      `json:"parentId,omitempty"`
+}
+
+// DeviceCredential is a device's secret for authenticating ingestion (e.g.
+// as its MQTT password or HTTP bearer token). Token is only returned when the
+// credential is issued; the server keeps a hash.
+type DeviceCredential struct {
+        DeviceID int       `json:"deviceId"`
+        Token    string    `json:"token,omitempty"`
+        IssuedAt time.Time `json:"issuedAt"`
+        // PreviousValidUntil is set while the token this one replaced is
+        // still accepted
+        PreviousValidUntil *time.Time `json:"previousValidUntil,omitempty"`
+}
+
+// DeviceCertificate is a client certificate issued to a device by the
+// internal CA. PrivateKey is only returned when the server generated the key.
+type DeviceCertificate struct {
+        Serial      string     `json:"serial"`
+        DeviceID    int        `json:"deviceId"`
+        Subject     string     `json:"subject"`
+        NotBefore   time.Time  `json:"notBefore"`
+        NotAfter    time.Time  `json:"notAfter"`
+        RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+        Certificate string     `json:"certificate"`
+        PrivateKey  string     `json:"privateKey,omitempty"`
+}
+
+// IssueCertificateRequest asks the internal CA for a device certificate. With
+// a PEM CSR its public key is certified; without one the server generates a
+// key pair.
+type IssueCertificateRequest struct {
+        CSR          string `json:"csr"`
+        ValidityDays int    `json:"validityDays"`
+}
+
+// Telemetry signing algorithms
+const (
+        SigningHMACSHA256 = "hmac-sha256"
+        SigningEd25519    = "ed25519"
+)
+
+// Signature statuses stored on readings
+const (
+        SignatureVerified = "verified"
+        SignatureUnsigned = "unsigned"
+)
+
+// SigningKey is how a device signs its telemetry. Secret, the HMAC key, is
+// only returned when it is generated.
+type SigningKey struct {
+        DeviceID  int    `json:"deviceId"`
+        Algorithm string `json:"algorithm"`
+        // PublicKey is the device's base64 Ed25519 public key
+        PublicKey string `json:"publicKey,omitempty"`
+        Secret    string `json:"secret,omitempty"`
+        // Required refuses the device's unsigned payloads
+        Required  bool      `json:"required"`
+        CreatedAt time.Time `json:"createdAt"`
+}
+
+type SigningKeyRequest struct {
+        Algorithm string `json:"algorithm" binding:"required,oneof=hmac-sha256 ed25519"`
+        // PublicKey is required for Ed25519, as base64 or a PEM public key
+        PublicKey string `json:"publicKey"`
+        Required  bool   `json:"required"`
+}
+
+// BootstrapToken lets devices enroll themselves. Token is only returned when
+// the bootstrap token is created; the server keeps a hash.
+type BootstrapToken struct {
+        ID    int    `json:"id"`
+        Name  string `json:"name"`
+        Token string `json:"token,omitempty"`
+        // AutoApprove registers enrolling devices without operator approval
+        AutoApprove bool `json:"autoApprove"`
+        // DeviceTypes limits which device types may enroll; empty allows any
+        DeviceTypes []string `json:"deviceTypes,omitempty"`
+        // Location is given to enrolled devices that don't name their own
+        Location  string     `json:"location,omitempty"`
+        MaxUses   int        `json:"maxUses,omitempty"`
+        Uses      int        `json:"uses"`
+        ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+        CreatedAt time.Time  `json:"createdAt"`
+}
+
+type InsertBootstrapToken struct {
+        Name        string   `json:"name" binding:"required"`
+        AutoApprove bool     `json:"autoApprove"`
+        DeviceTypes []string `json:"deviceTypes"`
+        Location    string   `json:"location"`
+        MaxUses     int      `json:"maxUses" binding:"min=0"`
+        // ExpiresIn is a duration such as "72h"; empty never expires
+        ExpiresIn string `json:"expiresIn"`
+}
+
+// Enrollment states
+const (
+        EnrollmentPending  = "pending"
+        EnrollmentApproved = "approved"
+        EnrollmentRejected = "rejected"
+)
+
+// EnrollmentRequest is what a device sends to enroll itself. With a PEM CSR
+// it also gets a client certificate once approved.
+type EnrollmentRequest struct {
+        Serial   string `json:"serial" binding:"required,max=128"`
+        Type     string `json:"type" binding:"required"`
+        Name     string `json:"name"`
+        Location string `json:"location"`
+        CSR      string `json:"csr"`
+        // PollToken lets a device re-send its pending request, e.g. after
+        // losing the response
+        PollToken string `json:"pollToken"`
+}
+
+// Enrollment is a device's request to be registered. PollToken, which the
+// device uses to collect its credentials, is only returned when the request
+// is made.
+type Enrollment struct {
+        ID               int        `json:"id"`
+        Serial           string     `json:"serial"`
+        Type             string     `json:"type"`
+        Name             string     `json:"name"`
+        Location         string     `json:"location"`
+        CSR              string     `json:"csr,omitempty"`
+        Status           string     `json:"status"`
+        BootstrapTokenID int        `json:"bootstrapTokenId"`
+        RequestedAt      time.Time  `json:"requestedAt"`
+        DecidedAt        *time.Time `json:"decidedAt,omitempty"`
+        Reason           string     `json:"reason,omitempty"`
+        DeviceID         int        `json:"deviceId,omitempty"`
+        // CredentialsCollected is set once the device has fetched the
+        // credentials issued on approval
+        CredentialsCollected bool   `json:"credentialsCollected"`
+        PollToken            string `json:"pollToken,omitempty"`
+}
+
+// EnrollmentDecision is an operator's approval or rejection. On approval the
+// device's name, location and parent may be set.
+type EnrollmentDecision struct {
+        Name     string `json:"name"`
+        Location string `json:"location"`
+        ParentID *int   `json:"parentId"`
+        Reason   string `json:"reason"`
+}
+
+// EnrollmentStatus is what an enrolling device is told. The credentials are
+// included once, on the first poll after approval.
+type EnrollmentStatus struct {
+        Enrollment
+        Credential  *DeviceCredential  `json:"credential,omitempty"`
+        Certificate *DeviceCertificate `json:"certificate,omitempty"`
+}
+
+// CreatedDevice is a new device along with the credential issued for it
+type CreatedDevice struct {
+        Device
+        Credential *DeviceCredential `json:"credential"`
+}
+
+// DeviceStatusChange is the payload of a device status change event
+type DeviceStatusChange struct {
+        Device         Device `json:"device"`
+        PreviousStatus string `json:"previousStatus"`
 }
 
 type Telemetry struct {
@@ -22,16 +189,220 @@ type Telemetry struct {
         MemoryUsage  float64   `json:"memoryUsage"`
         MemoryTotal  float64   `json:"memoryTotal"`
         Timestamp    time.Time `json:"timestamp"`
+        ReceivedAt   time.Time `json:"receivedAt"`
+        Metrics      Metrics   `json:"metrics,omitempty"`
+        Flags        []string  `json:"flags,omitempty"`
+        // MessageID is an optional client-chosen ID; a repeat within the
+        // dedupe window returns the original reading instead of a new one
+        MessageID string `json:"messageId,omitempty"`
+        // Signature records how the payload's signature checked out at
+        // ingest: "verified" or "unsigned". Set by the server, and only for
+        // HTTP ingestion.
+        Signature string `json:"signature,omitempty"`
+}
+
+// Metrics holds device-specific measurements by name, e.g. "pressure_kpa"
+type Metrics map[string]Metric
+
+type Metric struct {
+        Value float64 `json:"value"`
+        Unit  string  `json:"unit,omitempty"`
+}
+
+// UnmarshalJSON accepts either {"value": 1.5, "unit": "kPa"} or a bare number
+func (m *Metric) UnmarshalJSON(data []byte) error {
+        var value float64
+        if err := json.Unmarshal(data, &value); err == nil {
+                *m = Metric{Value: value}
+                return nil
+        }
+        type metricObject Metric
+        var p metricObject
+        if err := json.Unmarshal(data, &p); err != nil {
+                return fmt.Errorf(`metric must be a number or {"value": <number>, "unit": "<unit>"}`)
+        }
+        *m = Metric(p)
+        return nil
+}
+
+// DeviceProfile declares what telemetry devices of one type are expected to
+// send. Readings that don't fit are rejected or flagged per Enforcement.
+type DeviceProfile struct {
+        Type                string       `json:"type"`
+        Description         string       `json:"description,omitempty"`
+        Metrics             []MetricSpec `json:"metrics"`
+        ReportingInterval   int          `json:"reportingIntervalSeconds,omitempty"`
+        AllowUnknownMetrics bool         `json:"allowUnknownMetrics"`
+        Enforcement         string       `json:"enforcement"`
+        UpdatedAt           time.Time    `json:"updatedAt"`
+}
+
+// MetricSpec describes one expected metric. Name may also be one of the
+// built-in fields (e.g. "temperature") to bound its range.
+type MetricSpec struct {
+        Name     string   `json:"name" binding:"required"`
+        Unit     string   `json:"unit,omitempty"`
+        Min      *float64 `json:"min,omitempty"`
+        Max      *float64 `json:"max,omitempty"`
+        Required bool     `json:"required"`
+}
+
+type InsertDeviceProfile struct {
+        Description         string       `json:"description"`
+        Metrics             []MetricSpec `json:"metrics" binding:"dive"`
+        ReportingInterval   int          `json:"reportingIntervalSeconds" binding:"min=0"`
+        AllowUnknownMetrics bool         `json:"allowUnknownMetrics"`
+        Enforcement         string       `json:"enforcement" binding:"omitempty,oneof=reject flag"`
+}
+
+// ModbusConfig tells the collector how to poll a device over Modbus TCP
+type ModbusConfig struct {
+        DeviceID        int              `json:"deviceId"`
+        Host            string           `json:"host"`
+        UnitID          int              `json:"unitId"`
+        IntervalSeconds int              `json:"intervalSeconds"`
+        Enabled         bool             `json:"enabled"`
+        Registers       []ModbusRegister `json:"registers"`
+        UpdatedAt       time.Time        `json:"updatedAt"`
+        Status          *PollStatus      `json:"status,omitempty"`
+}
+
+// ModbusRegister maps one register (or register pair, or bit) to a metric.
+// The stored value is raw*scale + offset; a zero scale means 1.
+type ModbusRegister struct {
+        Metric    string  `json:"metric" binding:"required"`
+        Table     string  `json:"table" binding:"omitempty,oneof=holding input coil discrete"`
+        Address   uint16  `json:"address"`
+        Type      string  `json:"type" binding:"omitempty,oneof=uint16 int16 uint32 int32 float32 bool"`
+        WordOrder string  `json:"wordOrder,omitempty" binding:"omitempty,oneof=big little"`
+        Scale     float64 `json:"scale,omitempty"`
+        Offset    float64 `json:"offset,omitempty"`
+        Unit      string  `json:"unit,omitempty"`
+}
+
+type InsertModbusConfig struct {
+        Host            string           `json:"host" binding:"required"`
+        UnitID          int              `json:"unitId" binding:"min=0,max=255"`
+        IntervalSeconds int              `json:"intervalSeconds" binding:"required,min=1"`
+        Enabled         *bool            `json:"enabled"`
+        Registers       []ModbusRegister `json:"registers" binding:"required,min=1,dive"`
+}
+
+// PollStatus summarises recent polls of a device by the collector
+type PollStatus struct {
+        LastPollAt          *time.Time `json:"lastPollAt,omitempty"`
+        LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
+        LastError           string     `json:"lastError,omitempty"`
+        ConsecutiveFailures int        `json:"consecutiveFailures"`
+        TotalPolls          int        `json:"totalPolls"`
+        TotalFailures       int        `json:"totalFailures"`
+        AlertID             *int       `json:"alertId,omitempty"`
+}
+
+// SNMPConfig tells the collector how to poll a device over SNMP and how to
+// authenticate and classify the traps it sends
+type SNMPConfig struct {
+        DeviceID        int            `json:"deviceId"`
+        Host            string         `json:"host"`
+        Version         string         `json:"version"`
+        Community       string         `json:"community,omitempty"`
+        User            *SNMPUser      `json:"user,omitempty"`
+        IntervalSeconds int            `json:"intervalSeconds"`
+        Enabled         bool           `json:"enabled"`
+        OIDs            []SNMPMetric   `json:"oids"`
+        Traps           []SNMPTrapRule `json:"traps,omitempty"`
+        UpdatedAt       time.Time      `json:"updatedAt"`
+        Status          *PollStatus    `json:"status,omitempty"`
+}
+
+// SNMPUser is an SNMPv3 user-based security model identity
+type SNMPUser struct {
+        Username       string `json:"username" binding:"required"`
+        SecurityLevel  string `json:"securityLevel" binding:"omitempty,oneof=noAuthNoPriv authNoPriv authPriv"`
+        AuthProtocol   string `json:"authProtocol,omitempty" binding:"omitempty,oneof=MD5 SHA SHA224 SHA256 SHA384 SHA512"`
+        AuthPassphrase string `json:"authPassphrase,omitempty"`
+        PrivProtocol   string `json:"privProtocol,omitempty" binding:"omitempty,oneof=DES AES AES192 AES256"`
+        PrivPassphrase string `json:"privPassphrase,omitempty"`
+}
+
+// SNMPMetric maps one OID to a metric. The stored value is raw*scale +
+// offset; a zero scale means 1.
+type SNMPMetric struct {
+        OID    string  `json:"oid" binding:"required"`
+        Metric string  `json:"metric" binding:"required"`
+        Scale  float64 `json:"scale,omitempty"`
+        Offset float64 `json:"offset,omitempty"`
+        Unit   string  `json:"unit,omitempty"`
+}
+
+// SNMPTrapRule classifies traps whose snmpTrapOID is OID or lies below it
+type SNMPTrapRule struct {
+        OID      string `json:"oid" binding:"required"`
+        Type     string `json:"type" binding:"required"`
+        Severity string `json:"severity" binding:"required,oneof=info warning critical"`
+}
+
+type InsertSNMPConfig struct {
+        Host            string         `json:"host" binding:"required"`
+        Version         string         `json:"version" binding:"required,oneof=2c 3"`
+        Community       string         `json:"community"`
+        User            *SNMPUser      `json:"user"`
+        IntervalSeconds int            `json:"intervalSeconds" binding:"required,min=1"`
+        Enabled         *bool          `json:"enabled"`
+        OIDs            []SNMPMetric   `json:"oids" binding:"required,min=1,dive"`
+        Traps           []SNMPTrapRule `json:"traps" binding:"dive"`
+}
+
+// MetricPoint is one value of a single metric over time
+type MetricPoint struct {
+        TelemetryID int       `json:"telemetryId"`
+        Timestamp   time.Time `json:"timestamp"`
+        Value       float64   `json:"value"`
+        Unit        string    `json:"unit,omitempty"`
 }
 
 type Alert struct {
-        ID           int       `json:"id"`
-        DeviceID     int       `json:"deviceId"`
-        Type         string    `json:"type"`
-        Message      string    `json:"message"`
-        Severity     string    `json:"severity"`
-        Acknowledged bool      `json:"acknowledged"`
-        CreatedAt    time.Time `json:"createdAt"`
+        ID                int        `json:"id"`
+        DeviceID          int        `json:"deviceId"`
+        Type              string     `json:"type"`
+        Message           string     `json:"message"`
+        Severity          string     `json:"severity"`
+        Acknowledged      bool       `json:"acknowledged"`
+        CreatedAt         time.Time  `json:"createdAt"`
+        AcknowledgedAt    *time.Time `json:"acknowledgedAt,omitempty"`
+        Resolved          bool       `json:"resolved"`
+        ResolvedAt        *time.Time `json:"resolvedAt,omitempty"`
+        SnoozedUntil      *time.Time `json:"snoozedUntil,omitempty"`
+        CorrelatedAlertID *int       `json:"correlatedAlertId,omitempty"`
+        Suppressed        bool       `json:"suppressed"`
+        IncidentID        *int       `json:"incidentId,omitempty"`
+        // MessageID deduplicates retried creates, as for telemetry
+        MessageID string `json:"messageId,omitempty"`
+}
+
+// Incident groups related alerts so they can be worked as one
+type Incident struct {
+        ID             int        `json:"id"`
+        Title          string     `json:"title"`
+        Status         string     `json:"status"`
+        Severity       string     `json:"severity"`
+        Location       string     `json:"location,omitempty"`
+        Source         string     `json:"source"`
+        AlertIDs       []int      `json:"alertIds"`
+        CreatedAt      time.Time  `json:"createdAt"`
+        UpdatedAt      time.Time  `json:"updatedAt"`
+        LastAlertAt    time.Time  `json:"lastAlertAt"`
+        AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty"`
+        ResolvedAt     *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// IncidentEvent is one entry in an incident's timeline
+type IncidentEvent struct {
+        Type      string    `json:"type"`
+        Message   string    `json:"message"`
+        AlertID   *int      `json:"alertId,omitempty"`
+        Author    string    `json:"author,omitempty"`
+        Timestamp time.Time `json:"timestamp"`
 }
 
 type Stats struct {
@@ -41,12 +412,93 @@ type Stats struct {
         AvgCPUUsage   float64 `json:"avgCpuUsage"`
 }
 
+// IngestStats reports the state of the telemetry ingestion queue
+type IngestStats struct {
+        // QueueDepth counts readings waiting or being written
+        QueueDepth    int   `json:"queueDepth"`
+        QueueCapacity int   `json:"queueCapacity"`
+        Workers       int   `json:"workers"`
+        Accepted      int64 `json:"accepted"`
+        Rejected      int64 `json:"rejected"`
+        Failed        int64 `json:"failed"`
+        Throttled     int64 `json:"throttled"`
+        TimedOut      int64 `json:"timedOut"`
+        Batches       int64 `json:"batches"`
+        // Spooled counts readings written to the on-disk spool while the
+        // store was unavailable, and Replayed those since stored from it
+        Spooled  int64         `json:"spooled"`
+        Replayed int64         `json:"replayed"`
+        Latency  IngestLatency `json:"latency"`
+}
+
+// IngestLatency summarises the time from queueing to written over recent
+// requests
+type IngestLatency struct {
+        Samples int     `json:"samples"`
+        P50Ms   float64 `json:"p50Ms"`
+        P95Ms   float64 `json:"p95Ms"`
+        P99Ms   float64 `json:"p99Ms"`
+        MaxMs   float64 `json:"maxMs"`
+}
+
+// SpoolStats reports the telemetry waiting in the on-disk spool to be
+// replayed into the store. A record is one spooled batch of readings.
+type SpoolStats struct {
+        Enabled  bool       `json:"enabled"`
+        Records  int        `json:"records"`
+        Readings int        `json:"readings"`
+        Bytes    int64      `json:"bytes"`
+        MaxBytes int64      `json:"maxBytes,omitempty"`
+        Segments int        `json:"segments,omitempty"`
+        Oldest   *time.Time `json:"oldest,omitempty"`
+}
+
+// Health reports whether the datastore is reachable and how much telemetry
+// is spooled waiting for it
+type Health struct {
+        Status string     `json:"status"`
+        Redis  string     `json:"redis"`
+        Spool  SpoolStats `json:"spool"`
+}
+
+// AlertStats summarises alert volume and response times over a reporting window
+type AlertStats struct {
+        From               time.Time           `json:"from"`
+        To                 time.Time           `json:"to"`
+        TotalAlerts        int                 `json:"totalAlerts"`
+        OpenAlerts         int                 `json:"openAlerts"`
+        AcknowledgedAlerts int                 `json:"acknowledgedAlerts"`
+        ResolvedAlerts     int                 `json:"resolvedAlerts"`
+        BySeverity         map[string]int      `json:"bySeverity"`
+        ByType             map[string]int      `json:"byType"`
+        ByDevice           map[int]int         `json:"byDevice"`
+        ByLocation         map[string]int      `json:"byLocation"`
+        MTTASeconds        float64             `json:"mttaSeconds"`
+        MTTRSeconds        float64             `json:"mttrSeconds"`
+        TopNoisyDevices    []DeviceAlertCount  `json:"topNoisyDevices"`
+        Volume             []AlertVolumeBucket `json:"volume"`
+}
+
+type DeviceAlertCount struct {
+        DeviceID   int    `json:"deviceId"`
+        DeviceName string `json:"deviceName"`
+        Location   string `json:"location"`
+        Count      int    `json:"count"`
+}
+
+type AlertVolumeBucket struct {
+        Start      time.Time      `json:"start"`
+        Count      int            `json:"count"`
+        BySeverity map[string]int `json:"bySeverity"`
+}
+
 // Insert types for creating new records
 type InsertDevice struct {
         Name     string `json:"name" binding:"required"`
         Type     string `json:"type" binding:"required"`
         Location string `json:"location" binding:"required"`
-        Status   string `json:"status" binding:"required,oneof=online offline warning critical"`
+        Status   string `json:"status" binding:"required,oneof=online offline warning critical decommissioned"`
+        ParentID *int   `json:"parentId"`
 }
 
 type InsertTelemetry struct {
@@ -63,4 +515,66 @@ type InsertAlert struct {
         Type     string `json:"type" binding:"required"`
         Message  string `json:"message" binding:"required"`
         Severity string `json:"severity" binding:"required,oneof=info warning critical"`
-}
\ No newline at end of file
+}
+
+// BulkAlertRequest selects alerts by explicit ID, by filter, or both
+type BulkAlertRequest struct {
+        Action    string       `json:"action" binding:"required,oneof=acknowledge resolve snooze delete"`
+        IDs       []int        `json:"ids"`
+        Filter    *AlertFilter `json:"filter"`
+        SnoozeFor string       `json:"snoozeFor"`
+}
+
+type AlertFilter struct {
+        DeviceID  int    `json:"deviceId"`
+        Severity  string `json:"severity" binding:"omitempty,oneof=info warning critical"`
+        Type      string `json:"type"`
+        OlderThan string `json:"olderThan"`
+}
+
+type BulkAlertResult struct {
+        ID     int    `json:"id"`
+        Status string `json:"status"`
+        Error  string `json:"error,omitempty"`
+}
+
+type BulkAlertResponse struct {
+        Action  string            `json:"action"`
+        Applied bool              `json:"applied"`
+        Results []BulkAlertResult `json:"results"`
+}
+
+type InsertIncident struct {
+        Title    string `json:"title" binding:"required"`
+        Severity string `json:"severity" binding:"omitempty,oneof=info warning critical"`
+        AlertIDs []int  `json:"alertIds"`
+}
+
+type IncidentAlertsRequest struct {
+        AlertIDs []int `json:"alertIds" binding:"required,min=1"`
+}
+
+type IncidentComment struct {
+        Author  string `json:"author"`
+        Message string `json:"message" binding:"required"`
+}
+
+type IncidentStatusUpdate struct {
+        Status string `json:"status" binding:"required,oneof=open acknowledged resolved"`
+        Author string `json:"author"`
+}
+
+// TelemetryBatchResult reports the outcome of one reading in a batch upload
+type TelemetryBatchResult struct {
+        Index  int      `json:"index"`
+        ID     int      `json:"id,omitempty"`
+        Status string   `json:"status"`
+        Error  string   `json:"error,omitempty"`
+        Flags  []string `json:"flags,omitempty"`
+}
+
+type TelemetryBatchResponse struct {
+        Accepted int                    `json:"accepted"`
+        Rejected int                    `json:"rejected"`
+        Results  []TelemetryBatchResult `json:"results"`
+}
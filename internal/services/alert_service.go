@@ -2,18 +2,70 @@ package services
 
 import (
 	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/events"
 	"edgefleet-commander/internal/models"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Per-item outcomes reported by BulkAction
+const (
+	BulkStatusUpdated   = "updated"
+	BulkStatusUnchanged = "unchanged"
+	BulkStatusDeleted   = "deleted"
+	BulkStatusNotFound  = "not_found"
+	BulkStatusInvalid   = "invalid"
+	BulkStatusAborted   = "aborted"
 )
 
+// maxBulkRetries bounds how often a bulk action is retried when one of the
+// watched alerts is modified concurrently.
+const maxBulkRetries = 3
+
+// ErrBulkTooLarge is returned for a bulk action over more alerts than the
+// service allows in one transaction
+var ErrBulkTooLarge = errors.New("bulk action covers too many alerts")
+
+// AlertQuery narrows a bulk action to alerts matching every non-zero field
+type AlertQuery struct {
+	DeviceID  int
+	Severity  string
+	Type      string
+	OlderThan time.Duration
+}
+
 type AlertService struct {
-	db *database.RedisClient
+	db           *database.RedisClient
+	incidents    *IncidentService
+	events       *events.Bus
+	bulkMaxItems int
+	messageIDs
 }
 
-func NewAlertService(db *database.RedisClient) *AlertService {
-	return &AlertService{db: db}
+// NewAlertService creates the alert service. Alerts created with a message
+// ID are deduplicated for dedupeWindow; 0 turns that off. A bulk action may
+// cover at most bulkMaxItems alerts.
+func NewAlertService(db *database.RedisClient, incidents *IncidentService, bus *events.Bus, dedupeWindow time.Duration, bulkMaxItems int) *AlertService {
+	return &AlertService{
+		db:           db,
+		incidents:    incidents,
+		events:       bus,
+		bulkMaxItems: bulkMaxItems,
+		messageIDs:   messageIDs{db: db, prefix: "alerts", window: dedupeWindow},
+	}
+}
+
+// bulkActionEvents maps bulk actions to the event published per changed alert
+var bulkActionEvents = map[string]string{
+	"acknowledge": events.AlertAcknowledged,
+	"resolve":     events.AlertResolved,
+	"snooze":      events.AlertSnoozed,
+	"delete":      events.AlertDeleted,
 }
 
 func (s *AlertService) GetAllAlerts(limit int) ([]models.Alert, error) {
@@ -66,14 +118,79 @@ func (s *AlertService) GetAlertsByDevice(deviceID uint) ([]models.Alert, error)
 }
 
 func (s *AlertService) CreateAlert(alert *models.Alert) error {
+	_, err := s.CreateAlertDeduped(alert)
+	return err
+}
+
+// CreateAlertDeduped is CreateAlert, also reporting whether the alert
+// repeated the message ID of one created within the dedupe window, in which
+// case alert is filled in with the original
+func (s *AlertService) CreateAlertDeduped(alert *models.Alert) (bool, error) {
+	if err := validateMessageID(alert.MessageID); err != nil {
+		return false, err
+	}
 	// Get next alert ID
 	nextID, err := s.db.GetClient().Incr(s.db.GetContext(), "alerts:next_id").Result()
 	if err != nil {
-		return fmt.Errorf("failed to generate alert ID: %w", err)
+		return false, fmt.Errorf("failed to generate alert ID: %w", err)
 	}
 	alert.ID = int(nextID)
+
+	var claims []messageClaim
+	if alert.MessageID != "" && s.messageIDs.enabled() {
+		claims = []messageClaim{{alert.DeviceID, alert.MessageID, alert.ID}}
+		originals, err := s.messageIDs.claim(s.db.GetContext(), claims)
+		if err != nil {
+			return false, err
+		}
+		if originals[0] != 0 {
+			original, err := s.getAlert(originals[0])
+			if err != nil {
+				return false, ErrMessageInFlight
+			}
+			*alert = *original
+			return true, nil
+		}
+	}
+	if err := s.storeNewAlert(alert); err != nil {
+		s.messageIDs.release(s.db.GetContext(), claims)
+		return false, err
+	}
+	return false, nil
+}
+
+func (s *AlertService) getAlert(id int) (*models.Alert, error) {
+	alertData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("alerts:%d", id), "data").Result()
+	if err != nil {
+		return nil, fmt.Errorf("alert not found")
+	}
+	var alert models.Alert
+	if err := json.Unmarshal([]byte(alertData), &alert); err != nil {
+		return nil, fmt.Errorf("failed to parse alert data: %w", err)
+	}
+	return &alert, nil
+}
+
+// storeNewAlert stores an alert under its freshly allocated ID, correlating
+// it with open upstream alerts
+func (s *AlertService) storeNewAlert(alert *models.Alert) error {
 	alert.CreatedAt = time.Now()
 	alert.Acknowledged = false
+	alert.CorrelatedAlertID = nil
+	alert.Suppressed = false
+
+	// While an upstream device (typically the gateway) has an open alert,
+	// alerts from devices behind it are symptoms: attach them to the root
+	// alert and keep them quiet.
+	upstream, err := s.findUpstreamAlert(alert.DeviceID)
+	if err != nil {
+		return err
+	}
+	if upstream != nil {
+		alert.CorrelatedAlertID = &upstream.ID
+		alert.Suppressed = true
+	}
+
 	alertJSON, err := json.Marshal(alert)
 	if err != nil {
 		return fmt.Errorf("failed to marshal alert: %w", err)
@@ -84,9 +201,113 @@ func (s *AlertService) CreateAlert(alert *models.Alert) error {
 	if err := s.db.GetClient().SAdd(s.db.GetContext(), "alerts:all", alert.ID).Err(); err != nil {
 		return fmt.Errorf("failed to add alert to set: %w", err)
 	}
+	if upstream != nil {
+		if err := s.db.GetClient().SAdd(s.db.GetContext(), fmt.Sprintf("alerts:%d:correlated", upstream.ID), alert.ID).Err(); err != nil {
+			return fmt.Errorf("failed to correlate alert: %w", err)
+		}
+	}
+	// The alert is already stored, so a grouping failure shouldn't fail the request
+	if err := s.incidents.CorrelateAlert(alert, upstream); err != nil {
+		log.Printf("Warning: failed to group alert %d into an incident: %v", alert.ID, err)
+	}
+	// Suppressed alerts are not announced; they are listed under their root
+	// alert instead
+	if !alert.Suppressed {
+		s.events.Publish(events.AlertCreated, alert.DeviceID, *alert)
+	}
 	return nil
 }
 
+// GetCorrelatedAlerts returns the alerts attributed to the given root alert
+func (s *AlertService) GetCorrelatedAlerts(id uint) ([]models.Alert, error) {
+	exists, err := s.db.GetClient().Exists(s.db.GetContext(), fmt.Sprintf("alerts:%d", id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up alert: %w", err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("alert not found")
+	}
+	alertIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), fmt.Sprintf("alerts:%d:correlated", id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get correlated alert IDs: %w", err)
+	}
+	alerts := []models.Alert{}
+	for _, idStr := range alertIDs {
+		alertData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("alerts:%s", idStr), "data").Result()
+		if err != nil {
+			continue
+		}
+		var alert models.Alert
+		if err := json.Unmarshal([]byte(alertData), &alert); err != nil {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+// findUpstreamAlert walks up the device topology from deviceID and returns
+// the oldest open alert on the nearest ancestor that has one, resolved to
+// the root of its own correlation chain. It returns nil when no ancestor is
+// alerting.
+func (s *AlertService) findUpstreamAlert(deviceID int) (*models.Alert, error) {
+	parentID := s.deviceParent(deviceID)
+	if parentID == nil {
+		return nil, nil
+	}
+
+	alertIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), "alerts:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alert IDs: %w", err)
+	}
+	openByID := make(map[int]*models.Alert)
+	openByDevice := make(map[int]*models.Alert)
+	for _, idStr := range alertIDs {
+		alertData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("alerts:%s", idStr), "data").Result()
+		if err != nil {
+			continue
+		}
+		alert := &models.Alert{}
+		if err := json.Unmarshal([]byte(alertData), alert); err != nil || alert.Resolved {
+			continue
+		}
+		openByID[alert.ID] = alert
+		if oldest, ok := openByDevice[alert.DeviceID]; !ok || alert.CreatedAt.Before(oldest.CreatedAt) {
+			openByDevice[alert.DeviceID] = alert
+		}
+	}
+
+	seen := map[int]bool{deviceID: true}
+	for current := parentID; current != nil && !seen[*current]; current = s.deviceParent(*current) {
+		seen[*current] = true
+		root, ok := openByDevice[*current]
+		if !ok {
+			continue
+		}
+		for root.CorrelatedAlertID != nil {
+			next, ok := openByID[*root.CorrelatedAlertID]
+			if !ok || next.ID == root.ID {
+				break
+			}
+			root = next
+		}
+		return root, nil
+	}
+	return nil, nil
+}
+
+func (s *AlertService) deviceParent(deviceID int) *int {
+	deviceData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("devices:%d", deviceID), "data").Result()
+	if err != nil {
+		return nil
+	}
+	var device models.Device
+	if err := json.Unmarshal([]byte(deviceData), &device); err != nil {
+		return nil
+	}
+	return device.ParentID
+}
+
 func (s *AlertService) AcknowledgeAlert(id uint) error {
 	alertData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("alerts:%d", id), "data").Result()
 	if err != nil {
@@ -96,7 +317,35 @@ func (s *AlertService) AcknowledgeAlert(id uint) error {
 	if err := json.Unmarshal([]byte(alertData), &alert); err != nil {
 		return fmt.Errorf("failed to parse alert data: %w", err)
 	}
-	alert.Acknowledged = true
+	changed := markAcknowledged(&alert, time.Now())
+	alertJSON, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	if err := s.db.GetClient().HSet(s.db.GetContext(), fmt.Sprintf("alerts:%d", alert.ID), "data", alertJSON).Err(); err != nil {
+		return fmt.Errorf("failed to update alert: %w", err)
+	}
+	if changed {
+		s.events.Publish(events.AlertAcknowledged, alert.DeviceID, alert)
+		if err := s.incidents.RecordAlertEvent(&alert, IncidentEventAlertAcknowledged); err != nil {
+			log.Printf("Warning: failed to record alert %d on its incident: %v", alert.ID, err)
+		}
+	}
+	return nil
+}
+
+// ResolveAlert closes an alert. Resolving an alert that was never acknowledged
+// also acknowledges it so it stops counting as active.
+func (s *AlertService) ResolveAlert(id uint) error {
+	alertData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("alerts:%d", id), "data").Result()
+	if err != nil {
+		return fmt.Errorf("alert not found")
+	}
+	var alert models.Alert
+	if err := json.Unmarshal([]byte(alertData), &alert); err != nil {
+		return fmt.Errorf("failed to parse alert data: %w", err)
+	}
+	changed := markResolved(&alert, time.Now())
 	alertJSON, err := json.Marshal(alert)
 	if err != nil {
 		return fmt.Errorf("failed to marshal alert: %w", err)
@@ -104,6 +353,12 @@ func (s *AlertService) AcknowledgeAlert(id uint) error {
 	if err := s.db.GetClient().HSet(s.db.GetContext(), fmt.Sprintf("alerts:%d", alert.ID), "data", alertJSON).Err(); err != nil {
 		return fmt.Errorf("failed to update alert: %w", err)
 	}
+	if changed {
+		s.events.Publish(events.AlertResolved, alert.DeviceID, alert)
+		if err := s.incidents.RecordAlertEvent(&alert, IncidentEventAlertResolved); err != nil {
+			log.Printf("Warning: failed to record alert %d on its incident: %v", alert.ID, err)
+		}
+	}
 	return nil
 }
 
@@ -122,9 +377,238 @@ func (s *AlertService) GetUnacknowledgedAlerts() ([]models.Alert, error) {
 		if err := json.Unmarshal([]byte(alertData), &alert); err != nil {
 			continue
 		}
-		if !alert.Acknowledged {
+		if !alert.Acknowledged && !isSnoozed(&alert, time.Now()) {
 			alerts = append(alerts, alert)
 		}
 	}
 	return alerts, nil
 }
+
+// BulkAction applies action to the given alerts plus any matching query, all
+// or nothing. Every explicitly listed ID must exist; otherwise nothing is
+// written and the response lists which items failed. Alerts matched only by
+// the query that disappear before the write are reported but don't abort.
+// More alerts than the service's limit are refused with ErrBulkTooLarge.
+func (s *AlertService) BulkAction(action string, ids []int, query *AlertQuery, snoozeFor time.Duration) (*models.BulkAlertResponse, error) {
+	ctx := s.db.GetContext()
+
+	explicit := make(map[int]bool, len(ids))
+	var targets []int
+	for _, id := range ids {
+		if !explicit[id] {
+			explicit[id] = true
+			targets = append(targets, id)
+		}
+	}
+	if query != nil {
+		matched, err := s.findAlerts(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range matched {
+			if !explicit[id] {
+				explicit[id] = false
+				targets = append(targets, id)
+			}
+		}
+	}
+
+	if len(targets) > s.bulkMaxItems {
+		return nil, fmt.Errorf("%w: %d given or matched, at most %d", ErrBulkTooLarge, len(targets), s.bulkMaxItems)
+	}
+	if len(targets) == 0 {
+		return &models.BulkAlertResponse{Action: action, Applied: true, Results: []models.BulkAlertResult{}}, nil
+	}
+
+	keys := make([]string, len(targets))
+	for i, id := range targets {
+		keys[i] = fmt.Sprintf("alerts:%d", id)
+	}
+
+	for attempt := 0; attempt < maxBulkRetries; attempt++ {
+		var response *models.BulkAlertResponse
+		var changedAlerts []models.Alert
+		err := s.db.GetClient().Watch(ctx, func(tx *redis.Tx) error {
+			response = &models.BulkAlertResponse{Action: action, Results: make([]models.BulkAlertResult, 0, len(targets))}
+			changedAlerts = changedAlerts[:0]
+			now := time.Now()
+			updates := make(map[int][]byte)
+			var deletes []int
+			failed := false
+
+			for i, id := range targets {
+				result := models.BulkAlertResult{ID: id}
+				alertData, err := tx.HGet(ctx, keys[i], "data").Result()
+				if err == redis.Nil {
+					result.Status = BulkStatusNotFound
+					result.Error = "alert not found"
+					failed = failed || explicit[id]
+					response.Results = append(response.Results, result)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				var alert models.Alert
+				if err := json.Unmarshal([]byte(alertData), &alert); err != nil {
+					result.Status = BulkStatusInvalid
+					result.Error = "failed to parse alert data"
+					failed = true
+					response.Results = append(response.Results, result)
+					continue
+				}
+
+				if action == "delete" {
+					deletes = append(deletes, id)
+					changedAlerts = append(changedAlerts, alert)
+					result.Status = BulkStatusDeleted
+					response.Results = append(response.Results, result)
+					continue
+				}
+
+				changed := false
+				switch action {
+				case "acknowledge":
+					changed = markAcknowledged(&alert, now)
+				case "resolve":
+					changed = markResolved(&alert, now)
+				case "snooze":
+					changed = markSnoozed(&alert, now.Add(snoozeFor))
+				default:
+					return fmt.Errorf("unsupported bulk action %q", action)
+				}
+				if !changed {
+					result.Status = BulkStatusUnchanged
+					response.Results = append(response.Results, result)
+					continue
+				}
+				alertJSON, err := json.Marshal(alert)
+				if err != nil {
+					return fmt.Errorf("failed to marshal alert: %w", err)
+				}
+				updates[id] = alertJSON
+				changedAlerts = append(changedAlerts, alert)
+				result.Status = BulkStatusUpdated
+				response.Results = append(response.Results, result)
+			}
+
+			if failed {
+				for i := range response.Results {
+					switch response.Results[i].Status {
+					case BulkStatusUpdated, BulkStatusUnchanged, BulkStatusDeleted:
+						response.Results[i].Status = BulkStatusAborted
+					}
+				}
+				return nil
+			}
+
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for id, alertJSON := range updates {
+					pipe.HSet(ctx, fmt.Sprintf("alerts:%d", id), "data", alertJSON)
+				}
+				for _, id := range deletes {
+					pipe.Del(ctx, fmt.Sprintf("alerts:%d", id), fmt.Sprintf("alerts:%d:correlated", id))
+					pipe.SRem(ctx, "alerts:all", id)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			response.Applied = true
+			return nil
+		}, keys...)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply bulk alert action: %w", err)
+		}
+		if response.Applied {
+			for _, alert := range changedAlerts {
+				s.events.Publish(bulkActionEvents[action], alert.DeviceID, alert)
+			}
+		}
+		if response.Applied && (action == "acknowledge" || action == "resolve") {
+			eventType := IncidentEventAlertAcknowledged
+			if action == "resolve" {
+				eventType = IncidentEventAlertResolved
+			}
+			for i := range changedAlerts {
+				if err := s.incidents.RecordAlertEvent(&changedAlerts[i], eventType); err != nil {
+					log.Printf("Warning: failed to record alert %d on its incident: %v", changedAlerts[i].ID, err)
+				}
+			}
+		}
+		return response, nil
+	}
+
+	return nil, fmt.Errorf("failed to apply bulk alert action: alerts modified concurrently")
+}
+
+// findAlerts returns the IDs of alerts matching every non-zero field of query
+func (s *AlertService) findAlerts(query *AlertQuery) ([]int, error) {
+	alertIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), "alerts:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alert IDs: %w", err)
+	}
+	cutoff := time.Now().Add(-query.OlderThan)
+	var matched []int
+	for _, idStr := range alertIDs {
+		alertData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("alerts:%s", idStr), "data").Result()
+		if err != nil {
+			continue
+		}
+		var alert models.Alert
+		if err := json.Unmarshal([]byte(alertData), &alert); err != nil {
+			continue
+		}
+		if query.DeviceID != 0 && alert.DeviceID != query.DeviceID {
+			continue
+		}
+		if query.Severity != "" && alert.Severity != query.Severity {
+			continue
+		}
+		if query.Type != "" && alert.Type != query.Type {
+			continue
+		}
+		if query.OlderThan > 0 && !alert.CreatedAt.Before(cutoff) {
+			continue
+		}
+		matched = append(matched, alert.ID)
+	}
+	return matched, nil
+}
+
+func markAcknowledged(alert *models.Alert, now time.Time) bool {
+	if alert.Acknowledged {
+		return false
+	}
+	alert.Acknowledged = true
+	alert.AcknowledgedAt = &now
+	return true
+}
+
+// markResolved also acknowledges the alert so it stops counting as active
+func markResolved(alert *models.Alert, now time.Time) bool {
+	if alert.Resolved {
+		return false
+	}
+	markAcknowledged(alert, now)
+	alert.Resolved = true
+	alert.ResolvedAt = &now
+	return true
+}
+
+func markSnoozed(alert *models.Alert, until time.Time) bool {
+	if alert.Resolved {
+		return false
+	}
+	alert.SnoozedUntil = &until
+	return true
+}
+
+func isSnoozed(alert *models.Alert, now time.Time) bool {
+	return alert.SnoozedUntil != nil && alert.SnoozedUntil.After(now)
+}
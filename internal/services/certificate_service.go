@@ -0,0 +1,524 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// defaultCertificateDays and maxCertificateDays bound device certificate
+	// lifetimes
+	defaultCertificateDays = 365
+	maxCertificateDays     = 3650
+	// caValidity is the lifetime of a newly created internal CA
+	caValidity = 20 * 365 * 24 * time.Hour
+	// crlValidity is how long a published CRL is good for
+	crlValidity = 24 * time.Hour
+)
+
+// CertificateService is a small internal CA issuing client certificates for
+// devices to authenticate with over mutual TLS. The CA's key and certificate
+// are created on first use and kept in Redis, so every replica signs with the
+// same CA. The key is stored encrypted with the server's credential key.
+type CertificateService struct {
+	db *database.RedisClient
+	// deviceIDPattern has one group capturing the device ID in a
+	// certificate's URI or DNS SAN or common name
+	deviceIDPattern *regexp.Regexp
+	aead            cipher.AEAD
+
+	mu     sync.Mutex
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+}
+
+// NewCertificateService creates the certificate service. encryptionKey is
+// the server secret the CA key is encrypted with; changing it makes the
+// stored CA unusable.
+func NewCertificateService(db *database.RedisClient, deviceIDPattern, encryptionKey string) (*CertificateService, error) {
+	pattern, err := regexp.Compile(deviceIDPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device ID pattern: %w", err)
+	}
+	if pattern.NumSubexp() != 1 {
+		return nil, fmt.Errorf("device ID pattern must have exactly one group")
+	}
+	aead, err := newSecretCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateService{db: db, deviceIDPattern: pattern, aead: aead}, nil
+}
+
+// storedCA is the internal CA as kept in pki:ca. EncryptedKey is the PKCS #8
+// key sealed with the credential key, base64 encoded with its nonce first.
+type storedCA struct {
+	Certificate  string `json:"certificate"`
+	EncryptedKey string `json:"encryptedKey"`
+}
+
+// caKeyAdditionalData binds the sealed CA key to its purpose
+var caKeyAdditionalData = []byte("pki:ca")
+
+// authority returns the internal CA, loading or creating it on first use.
+// Replicas racing to create it agree on whichever was stored first.
+func (s *CertificateService) authority() (*x509.Certificate, crypto.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.caCert != nil {
+		return s.caCert, s.caKey, nil
+	}
+
+	ctx := s.db.GetContext()
+	data, err := s.db.GetClient().Get(ctx, "pki:ca").Result()
+	if err == redis.Nil {
+		var created []byte
+		if created, err = s.newAuthority(); err != nil {
+			return nil, nil, err
+		}
+		if err := s.db.GetClient().SetNX(ctx, "pki:ca", created, 0).Err(); err != nil {
+			return nil, nil, fmt.Errorf("failed to store CA: %w", err)
+		}
+		data, err = s.db.GetClient().Get(ctx, "pki:ca").Result()
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get CA: %w", err)
+	}
+
+	var stored storedCA
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA: %w", err)
+	}
+	certBlock, _ := pem.Decode([]byte(stored.Certificate))
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("failed to parse CA: invalid PEM")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	keyDER, err := s.openCAKey(stored.EncryptedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("CA key can't sign")
+	}
+	s.caCert, s.caKey = cert, signer
+	return cert, signer, nil
+}
+
+// newAuthority generates a self-signed CA, returning it as stored in pki:ca
+func (s *CertificateService) newAuthority() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "EdgeFleet Device CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode CA key: %w", err)
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return json.Marshal(storedCA{
+		Certificate:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		EncryptedKey: base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, keyDER, caKeyAdditionalData)),
+	})
+}
+
+func (s *CertificateService) openCAKey(stored string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("failed to decode CA key")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	keyDER, err := s.aead.Open(nil, nonce, ciphertext, caKeyAdditionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt CA key; was CREDENTIAL_KEY changed?")
+	}
+	return keyDER, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
+
+// CACertificate returns the internal CA's certificate, which devices and the
+// TLS listener trust
+func (s *CertificateService) CACertificate() (*x509.Certificate, error) {
+	cert, _, err := s.authority()
+	return cert, err
+}
+
+// IssueCertificate signs a client certificate for a device, naming it
+// device-{id} with the SAN urn:edgefleet:device:{id}. The CSR's subject is
+// ignored; only its public key is used.
+func (s *CertificateService) IssueCertificate(deviceID int, request models.IssueCertificateRequest) (*models.DeviceCertificate, error) {
+	ctx := s.db.GetContext()
+	exists, err := s.db.GetClient().Exists(ctx, fmt.Sprintf("devices:%d", deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check device: %w", err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("device not found")
+	}
+
+	days := request.ValidityDays
+	if days == 0 {
+		days = defaultCertificateDays
+	}
+	if days < 1 || days > maxCertificateDays {
+		return nil, &ValidationError{fmt.Sprintf("validityDays must be between 1 and %d", maxCertificateDays)}
+	}
+
+	var publicKey crypto.PublicKey
+	var privateKeyPEM string
+	if request.CSR != "" {
+		csr, err := parseCSR(request.CSR)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = csr.PublicKey
+	} else {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode key: %w", err)
+		}
+		publicKey = &key.PublicKey
+		privateKeyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	}
+
+	caCert, caKey, err := s.authority()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("device-%d", deviceID)},
+		URIs:         []*url.URL{{Scheme: "urn", Opaque: fmt.Sprintf("edgefleet:device:%d", deviceID)}},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.AddDate(0, 0, days),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, publicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+
+	certificate := &models.DeviceCertificate{
+		Serial:      serialString(serial),
+		DeviceID:    deviceID,
+		Subject:     template.Subject.CommonName,
+		NotBefore:   template.NotBefore,
+		NotAfter:    template.NotAfter,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+	certificateJSON, err := json.Marshal(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal certificate: %w", err)
+	}
+	_, err = s.db.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, "certificates:"+certificate.Serial, "data", certificateJSON)
+		pipe.SAdd(ctx, fmt.Sprintf("devices:%d:certificates", deviceID), certificate.Serial)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store certificate: %w", err)
+	}
+	certificate.PrivateKey = privateKeyPEM
+	return certificate, nil
+}
+
+// parseCSR decodes a PEM certificate request and checks its signature
+func parseCSR(csrPEM string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, &ValidationError{"csr must be a PEM certificate request"}
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, &ValidationError{fmt.Sprintf("invalid csr: %v", err)}
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, &ValidationError{fmt.Sprintf("invalid csr signature: %v", err)}
+	}
+	return csr, nil
+}
+
+func serialString(serial *big.Int) string {
+	return strings.ToLower(serial.Text(16))
+}
+
+func (s *CertificateService) GetCertificate(serial string) (*models.DeviceCertificate, error) {
+	data, err := s.db.GetClient().HGet(s.db.GetContext(), "certificates:"+strings.ToLower(serial), "data").Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("certificate not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate: %w", err)
+	}
+	var certificate models.DeviceCertificate
+	if err := json.Unmarshal([]byte(data), &certificate); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal certificate: %w", err)
+	}
+	return &certificate, nil
+}
+
+// GetDeviceCertificates lists a device's certificates, newest first
+func (s *CertificateService) GetDeviceCertificates(deviceID int) ([]models.DeviceCertificate, error) {
+	serials, err := s.db.GetClient().SMembers(s.db.GetContext(), fmt.Sprintf("devices:%d:certificates", deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate serials: %w", err)
+	}
+	certificates := []models.DeviceCertificate{}
+	for _, serial := range serials {
+		certificate, err := s.GetCertificate(serial)
+		if err != nil {
+			continue
+		}
+		certificates = append(certificates, *certificate)
+	}
+	sort.Slice(certificates, func(i, j int) bool { return certificates[i].NotBefore.After(certificates[j].NotBefore) })
+	return certificates, nil
+}
+
+// HasValidCertificate reports whether the device holds a certificate that is
+// neither revoked nor expired
+func (s *CertificateService) HasValidCertificate(deviceID int) (bool, error) {
+	certificates, err := s.GetDeviceCertificates(deviceID)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	for _, certificate := range certificates {
+		if certificate.RevokedAt == nil && now.Before(certificate.NotAfter) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RevokeCertificate adds a device's certificate to the CRL. Revoking it
+// again keeps the original revocation time.
+func (s *CertificateService) RevokeCertificate(deviceID int, serial string) (*models.DeviceCertificate, error) {
+	certificate, err := s.GetCertificate(serial)
+	if err != nil {
+		return nil, err
+	}
+	if certificate.DeviceID != deviceID {
+		return nil, fmt.Errorf("certificate not found")
+	}
+	if certificate.RevokedAt != nil {
+		return certificate, nil
+	}
+
+	now := time.Now()
+	certificate.RevokedAt = &now
+	certificateJSON, err := json.Marshal(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal certificate: %w", err)
+	}
+	ctx := s.db.GetContext()
+	_, err = s.db.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, "certificates:"+certificate.Serial, "data", certificateJSON)
+		pipe.ZAdd(ctx, "certificates:revoked", &redis.Z{Score: float64(now.Unix()), Member: certificate.Serial})
+		pipe.Incr(ctx, "pki:crl_number")
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke certificate: %w", err)
+	}
+	return certificate, nil
+}
+
+// RevokeDeviceCertificates adds every certificate issued to a device to the
+// CRL and forgets the device's certificate list, for when the device is
+// deleted. Certificates already revoked keep their revocation time.
+func (s *CertificateService) RevokeDeviceCertificates(deviceID int) error {
+	ctx := s.db.GetContext()
+	serials, err := s.db.GetClient().SMembers(ctx, fmt.Sprintf("devices:%d:certificates", deviceID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get certificate serials: %w", err)
+	}
+	now := time.Now()
+	revoked := make(map[string][]byte)
+	for _, serial := range serials {
+		certificate, err := s.GetCertificate(serial)
+		if err != nil {
+			if err.Error() == "certificate not found" {
+				continue
+			}
+			return err
+		}
+		if certificate.RevokedAt != nil {
+			continue
+		}
+		certificate.RevokedAt = &now
+		if revoked[serial], err = json.Marshal(certificate); err != nil {
+			return fmt.Errorf("failed to marshal certificate: %w", err)
+		}
+	}
+
+	_, err = s.db.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for serial, certificateJSON := range revoked {
+			pipe.HSet(ctx, "certificates:"+serial, "data", certificateJSON)
+			pipe.ZAdd(ctx, "certificates:revoked", &redis.Z{Score: float64(now.Unix()), Member: serial})
+		}
+		if len(revoked) > 0 {
+			pipe.Incr(ctx, "pki:crl_number")
+		}
+		pipe.Del(ctx, fmt.Sprintf("devices:%d:certificates", deviceID))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke device certificates: %w", err)
+	}
+	return nil
+}
+
+// CRL returns the internal CA's certificate revocation list in DER form,
+// signed afresh on every call. Its number only changes when a certificate is
+// revoked.
+func (s *CertificateService) CRL() ([]byte, error) {
+	caCert, caKey, err := s.authority()
+	if err != nil {
+		return nil, err
+	}
+	ctx := s.db.GetContext()
+	revoked, err := s.db.GetClient().ZRangeWithScores(ctx, "certificates:revoked", 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revoked certificates: %w", err)
+	}
+	number, err := s.db.GetClient().Get(ctx, "pki:crl_number").Int64()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to number CRL: %w", err)
+	}
+
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, z := range revoked {
+		serial, ok := new(big.Int).SetString(z.Member.(string), 16)
+		if !ok {
+			continue
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: time.Unix(int64(z.Score), 0),
+		})
+	}
+	now := time.Now()
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(number),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+		RevokedCertificateEntries: entries,
+	}, caCert, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign CRL: %w", err)
+	}
+	return crl, nil
+}
+
+// AuthenticateCertificate returns the device a verified client certificate
+// belongs to, or 0 if it names no device or was revoked. The device ID is
+// taken from the first URI SAN, DNS SAN or common name matching the device
+// ID pattern.
+func (s *CertificateService) AuthenticateCertificate(cert *x509.Certificate) (int, error) {
+	var names []string
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.Subject.CommonName)
+
+	deviceID := 0
+	for _, name := range names {
+		if match := s.deviceIDPattern.FindStringSubmatch(name); match != nil {
+			if id, err := strconv.Atoi(match[1]); err == nil && id > 0 {
+				deviceID = id
+				break
+			}
+		}
+	}
+	if deviceID == 0 {
+		return 0, nil
+	}
+
+	// Only the internal CA's certificates can be revoked here; other CAs
+	// publish their own CRLs
+	caCert, err := s.CACertificate()
+	if err != nil {
+		return 0, err
+	}
+	if bytes.Equal(cert.RawIssuer, caCert.RawSubject) {
+		_, err := s.db.GetClient().ZScore(s.db.GetContext(), "certificates:revoked", serialString(cert.SerialNumber)).Result()
+		if err == nil {
+			return 0, nil
+		}
+		if err != redis.Nil {
+			return 0, fmt.Errorf("failed to check revocation: %w", err)
+		}
+	}
+	return deviceID, nil
+}
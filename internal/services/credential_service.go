@@ -0,0 +1,283 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/models"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrCredentialRequired is returned for anonymous data naming a device that
+// has been issued a credential, which must come with it
+var ErrCredentialRequired = errors.New("device has a credential; anonymous writes for it are refused")
+
+// pskLabel separates the DTLS key derived from a token from any other use
+// of the token
+const pskLabel = "edgefleet-coap-psk"
+
+// CredentialService manages the secrets devices authenticate with. Only a
+// SHA-256 hash of each token is stored, under the device and in an index
+// from hash to device ID so a bare token identifies its device. The DTLS
+// key derived from the token is stored encrypted with the server's
+// credential key.
+type CredentialService struct {
+	db   *database.RedisClient
+	aead cipher.AEAD
+}
+
+// NewCredentialService creates the credential service. encryptionKey is the
+// server secret DTLS keys are encrypted with; changing it invalidates them
+// until devices are issued new tokens.
+func NewCredentialService(db *database.RedisClient, encryptionKey string) (*CredentialService, error) {
+	aead, err := newSecretCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return &CredentialService{db: db, aead: aead}, nil
+}
+
+// newSecretCipher returns the AES-GCM cipher secrets kept in Redis are
+// encrypted with, keyed by a hash of the server's credential key
+func newSecretCipher(encryptionKey string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(encryptionKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credential cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credential cipher: %w", err)
+	}
+	return aead, nil
+}
+
+func credentialKey(deviceID int) string {
+	return fmt.Sprintf("devices:%d:credential", deviceID)
+}
+
+func tokenIndexKey(hash string) string {
+	return "credentials:token:" + hash
+}
+
+// IssueCredential generates a new token for a device, replacing any previous
+// one. With a grace period the previous token keeps working that long, so a
+// device in the field can be moved over to the new one. The returned
+// credential is the only place the token appears.
+func (s *CredentialService) IssueCredential(deviceID int, grace time.Duration) (*models.DeviceCredential, error) {
+	ctx := s.db.GetContext()
+	exists, err := s.db.GetClient().Exists(ctx, fmt.Sprintf("devices:%d", deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check device: %w", err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("device not found")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	credential := &models.DeviceCredential{
+		DeviceID: deviceID,
+		Token:    base64.RawURLEncoding.EncodeToString(secret),
+		IssuedAt: time.Now(),
+	}
+	hash := hashToken(credential.Token)
+	psk, err := s.sealPSK(deviceID, derivePSK(credential.Token))
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := s.db.GetClient().HGetAll(ctx, credentialKey(deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credential: %w", err)
+	}
+	_, err = s.db.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if old["previous_hash"] != "" {
+			pipe.Del(ctx, tokenIndexKey(old["previous_hash"]))
+		}
+		pipe.HDel(ctx, credentialKey(deviceID), "previous_hash", "previous_valid_until")
+		if old["token_hash"] != "" {
+			if grace > 0 {
+				validUntil := credential.IssuedAt.Add(grace)
+				credential.PreviousValidUntil = &validUntil
+				pipe.HSet(ctx, credentialKey(deviceID),
+					"previous_hash", old["token_hash"],
+					"previous_valid_until", validUntil.Format(time.RFC3339Nano),
+				)
+				pipe.Expire(ctx, tokenIndexKey(old["token_hash"]), grace)
+			} else {
+				pipe.Del(ctx, tokenIndexKey(old["token_hash"]))
+			}
+		}
+		pipe.HSet(ctx, credentialKey(deviceID),
+			"token_hash", hash,
+			"psk", psk,
+			"issued_at", credential.IssuedAt.Format(time.RFC3339Nano),
+		)
+		pipe.Set(ctx, tokenIndexKey(hash), deviceID, 0)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store credential: %w", err)
+	}
+	return credential, nil
+}
+
+// GetCredential describes a device's credential without its token
+func (s *CredentialService) GetCredential(deviceID int) (*models.DeviceCredential, error) {
+	stored, err := s.db.GetClient().HGetAll(s.db.GetContext(), credentialKey(deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credential: %w", err)
+	}
+	if stored["token_hash"] == "" {
+		return nil, fmt.Errorf("credential not found")
+	}
+	credential := &models.DeviceCredential{DeviceID: deviceID}
+	credential.IssuedAt, _ = time.Parse(time.RFC3339Nano, stored["issued_at"])
+	if validUntil, err := time.Parse(time.RFC3339Nano, stored["previous_valid_until"]); err == nil && time.Now().Before(validUntil) {
+		credential.PreviousValidUntil = &validUntil
+	}
+	return credential, nil
+}
+
+// HasCredential reports whether the device has been issued a token
+func (s *CredentialService) HasCredential(deviceID int) (bool, error) {
+	exists, err := s.db.GetClient().HExists(s.db.GetContext(), credentialKey(deviceID), "token_hash").Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to get credential: %w", err)
+	}
+	return exists, nil
+}
+
+// RevokeCredential removes a device's token, and any previous one still in
+// its grace period
+func (s *CredentialService) RevokeCredential(deviceID int) error {
+	ctx := s.db.GetContext()
+	hashes, err := s.db.GetClient().HMGet(ctx, credentialKey(deviceID), "token_hash", "previous_hash").Result()
+	if err != nil {
+		return fmt.Errorf("failed to get credential: %w", err)
+	}
+	keys := []string{credentialKey(deviceID)}
+	for _, hash := range hashes {
+		if hash, ok := hash.(string); ok && hash != "" {
+			keys = append(keys, tokenIndexKey(hash))
+		}
+	}
+	if err := s.db.GetClient().Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to revoke credential: %w", err)
+	}
+	return nil
+}
+
+// DeleteDeviceCredentials removes everything stored for a device's
+// credential, for when the device is deleted
+func (s *CredentialService) DeleteDeviceCredentials(deviceID int) error {
+	return s.RevokeCredential(deviceID)
+}
+
+// VerifyCredential reports whether token is the device's current token, or
+// its previous one during a rotation's grace period
+func (s *CredentialService) VerifyCredential(deviceID int, token string) (bool, error) {
+	stored, err := s.db.GetClient().HMGet(s.db.GetContext(), credentialKey(deviceID), "token_hash", "previous_hash", "previous_valid_until").Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to get credential: %w", err)
+	}
+	hash := []byte(hashToken(token))
+	if current, ok := stored[0].(string); ok && subtle.ConstantTimeCompare([]byte(current), hash) == 1 {
+		return true, nil
+	}
+	previous, _ := stored[1].(string)
+	validUntil, _ := stored[2].(string)
+	if previous == "" || subtle.ConstantTimeCompare([]byte(previous), hash) != 1 {
+		return false, nil
+	}
+	until, err := time.Parse(time.RFC3339Nano, validUntil)
+	return err == nil && time.Now().Before(until), nil
+}
+
+// Authenticate returns the device a token belongs to, or 0 if it isn't a
+// valid token
+func (s *CredentialService) Authenticate(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	idStr, err := s.db.GetClient().Get(s.db.GetContext(), tokenIndexKey(hashToken(token))).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up token: %w", err)
+	}
+	deviceID, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, nil
+	}
+	// The index may outlive a deleted device; the device's own record decides
+	ok, err := s.VerifyCredential(deviceID, token)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return deviceID, nil
+}
+
+// PresharedKey returns the key a device uses for DTLS-PSK,
+// HMAC-SHA256(token, "edgefleet-coap-psk"), or nil if it has no credential.
+// Devices derive it from the token they were issued, so no second secret has
+// to be provisioned.
+func (s *CredentialService) PresharedKey(deviceID int) ([]byte, error) {
+	stored, err := s.db.GetClient().HGet(s.db.GetContext(), credentialKey(deviceID), "psk").Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credential: %w", err)
+	}
+	return s.openPSK(deviceID, stored)
+}
+
+func derivePSK(token string) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(pskLabel))
+	return mac.Sum(nil)
+}
+
+// sealPSK encrypts a DTLS key for storage, bound to its device
+func (s *CredentialService) sealPSK(deviceID int, psk []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, psk, []byte(strconv.Itoa(deviceID)))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *CredentialService) openPSK(deviceID int, stored string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("failed to decode DTLS key of device %d", deviceID)
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	psk, err := s.aead.Open(nil, nonce, ciphertext, []byte(strconv.Itoa(deviceID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt DTLS key of device %d; was CREDENTIAL_KEY changed?", deviceID)
+	}
+	return psk, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
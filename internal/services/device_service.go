@@ -2,19 +2,23 @@ package services
 
 import (
         "edgefleet-commander/internal/database"
+        "edgefleet-commander/internal/events"
         "edgefleet-commander/internal/models"
         "encoding/json"
         "fmt"
-       
+        "strconv"
         "time"
+
+        "github.com/go-redis/redis/v8"
 )
 
 type DeviceService struct {
-        db *database.RedisClient
+        db     *database.RedisClient
+        events *events.Bus
 }
 
-func NewDeviceService(db *database.RedisClient) *DeviceService {
-        return &DeviceService{db: db}
+func NewDeviceService(db *database.RedisClient, bus *events.Bus) *DeviceService {
+        return &DeviceService{db: db, events: bus}
 }
 
 func (s *DeviceService) GetAllDevices() ([]models.Device, error) {
@@ -55,6 +59,12 @@ func (s *DeviceService) GetDeviceByID(id int) (*models.Device, error) {
 }
 
 func (s *DeviceService) CreateDevice(insertDevice *models.InsertDevice) (*models.Device, error) {
+        if insertDevice.ParentID != nil {
+                if err := s.validateParent(0, *insertDevice.ParentID); err != nil {
+                        return nil, err
+                }
+        }
+
         // Get next device ID
         nextID, err := s.db.GetClient().Incr(s.db.GetContext(), "devices:next_id").Result()
         if err != nil {
@@ -68,6 +78,7 @@ func (s *DeviceService) CreateDevice(insertDevice *models.InsertDevice) (*models
                 Location:     insertDevice.Location,
                 Status:       insertDevice.Status,
                 RegisteredAt: time.Now(),
+                ParentID:     insertDevice.ParentID,
         }
 
         deviceJSON, err := json.Marshal(device)
@@ -83,6 +94,7 @@ func (s *DeviceService) CreateDevice(insertDevice *models.InsertDevice) (*models
                 return nil, fmt.Errorf("failed to add device to set: %w", err)
         }
 
+        s.events.Publish(events.DeviceCreated, device.ID, *device)
         return device, nil
 }
 
@@ -92,6 +104,8 @@ func (s *DeviceService) UpdateDevice(id int, updates map[string]interface{}) (*m
                 return nil, err
         }
 
+        previousStatus := device.Status
+
         // Apply updates
         if name, ok := updates["name"].(string); ok {
                 device.Name = name
@@ -105,6 +119,20 @@ func (s *DeviceService) UpdateDevice(id int, updates map[string]interface{}) (*m
         if status, ok := updates["status"].(string); ok {
                 device.Status = status
         }
+        if parent, ok := updates["parentId"]; ok {
+                switch parentID := parent.(type) {
+                case nil:
+                        device.ParentID = nil
+                case float64:
+                        id := int(parentID)
+                        if err := s.validateParent(device.ID, id); err != nil {
+                                return nil, err
+                        }
+                        device.ParentID = &id
+                default:
+                        return nil, fmt.Errorf("invalid parent device")
+                }
+        }
 
         deviceJSON, err := json.Marshal(device)
         if err != nil {
@@ -115,11 +143,34 @@ func (s *DeviceService) UpdateDevice(id int, updates map[string]interface{}) (*m
                 return nil, fmt.Errorf("failed to update device: %w", err)
         }
 
+        s.events.Publish(events.DeviceUpdated, device.ID, *device)
+        if device.Status != previousStatus {
+                s.events.Publish(events.DeviceStatusChanged, device.ID, models.DeviceStatusChange{Device: *device, PreviousStatus: previousStatus})
+        }
         return device, nil
 }
 
 func (s *DeviceService) DeleteDevice(id int) error {
-        if err := s.db.GetClient().Del(s.db.GetContext(), fmt.Sprintf("devices:%d", id)).Err(); err != nil {
+        device, err := s.GetDeviceByID(id)
+        if err == nil {
+                // Children move up to the deleted device's own parent
+                children, err := s.GetChildDevices(id)
+                if err != nil {
+                        return err
+                }
+                for _, child := range children {
+                        child.ParentID = device.ParentID
+                        if err := s.saveDevice(&child); err != nil {
+                                return err
+                        }
+                }
+        }
+
+        if err := s.unbindExternalIDs(id); err != nil {
+                return err
+        }
+
+        if err := s.db.GetClient().Del(s.db.GetContext(), fmt.Sprintf("devices:%d", id), fmt.Sprintf("devices:%d:signing", id)).Err(); err != nil {
                 return fmt.Errorf("failed to delete device: %w", err)
         }
 
@@ -127,6 +178,21 @@ func (s *DeviceService) DeleteDevice(id int) error {
                 return fmt.Errorf("failed to remove device from set: %w", err)
         }
 
+        // Stop polling a device that no longer exists
+        if err := s.db.GetClient().Del(s.db.GetContext(), fmt.Sprintf("modbus:%d", id), fmt.Sprintf("modbus:%d:status", id)).Err(); err != nil {
+                return fmt.Errorf("failed to delete Modbus config: %w", err)
+        }
+        if err := s.db.GetClient().SRem(s.db.GetContext(), "modbus:all", id).Err(); err != nil {
+                return fmt.Errorf("failed to remove Modbus config from set: %w", err)
+        }
+        if err := s.db.GetClient().Del(s.db.GetContext(), fmt.Sprintf("snmp:%d", id), fmt.Sprintf("snmp:%d:status", id)).Err(); err != nil {
+                return fmt.Errorf("failed to delete SNMP config: %w", err)
+        }
+        if err := s.db.GetClient().SRem(s.db.GetContext(), "snmp:all", id).Err(); err != nil {
+                return fmt.Errorf("failed to remove SNMP config from set: %w", err)
+        }
+
+        s.events.Publish(events.DeviceDeleted, id, map[string]int{"id": id})
         return nil
 }
 
@@ -136,6 +202,7 @@ func (s *DeviceService) UpdateDeviceStatus(id int, status string) error {
                 return err
         }
 
+        previousStatus := device.Status
         device.Status = status
 
         deviceJSON, err := json.Marshal(device)
@@ -147,5 +214,133 @@ func (s *DeviceService) UpdateDeviceStatus(id int, status string) error {
                 return fmt.Errorf("failed to update device status: %w", err)
         }
 
+        if status != previousStatus {
+                s.events.Publish(events.DeviceStatusChanged, device.ID, models.DeviceStatusChange{Device: *device, PreviousStatus: previousStatus})
+        }
         return nil
-}
\ No newline at end of file
+}
+
+// GetChildDevices returns the devices whose parent is the given device
+func (s *DeviceService) GetChildDevices(id int) ([]models.Device, error) {
+        devices, err := s.GetAllDevices()
+        if err != nil {
+                return nil, err
+        }
+        children := []models.Device{}
+        for _, device := range devices {
+                if device.ParentID != nil && *device.ParentID == id {
+                        children = append(children, device)
+                }
+        }
+        return children, nil
+}
+
+// validateParent checks that parentID exists and that making it the parent
+// of id would not create a loop in the topology. id is 0 for new devices.
+func (s *DeviceService) validateParent(id, parentID int) error {
+        if parentID == id {
+                return fmt.Errorf("invalid parent device")
+        }
+        seen := map[int]bool{}
+        for current := parentID; ; {
+                if current == id || seen[current] {
+                        return fmt.Errorf("invalid parent device")
+                }
+                seen[current] = true
+                parent, err := s.GetDeviceByID(current)
+                if err != nil {
+                        if current == parentID {
+                                return fmt.Errorf("invalid parent device")
+                        }
+                        return nil
+                }
+                if parent.ParentID == nil {
+                        return nil
+                }
+                current = *parent.ParentID
+        }
+}
+
+// FindDeviceByExternalID returns the device bound to an identifier from
+// another system (e.g. a Sparkplug edge node), or nil if none is
+func (s *DeviceService) FindDeviceByExternalID(externalID string) (*models.Device, error) {
+        idStr, err := s.db.GetClient().HGet(s.db.GetContext(), "devices:external", externalID).Result()
+        if err == redis.Nil {
+                return nil, nil
+        }
+        if err != nil {
+                return nil, fmt.Errorf("failed to look up external ID: %w", err)
+        }
+        id, err := strconv.Atoi(idStr)
+        if err != nil {
+                return nil, fmt.Errorf("invalid device ID for %s: %w", externalID, err)
+        }
+        device, err := s.GetDeviceByID(id)
+        if err != nil && err.Error() == "device not found" {
+                return nil, nil
+        }
+        return device, err
+}
+
+// ReserveExternalID claims externalID for a device about to be created, so
+// concurrent registrations can't both bind it. It reports false if the ID is
+// already bound or reserved. BindExternalID completes the reservation.
+func (s *DeviceService) ReserveExternalID(externalID string) (bool, error) {
+        reserved, err := s.db.GetClient().HSetNX(s.db.GetContext(), "devices:external", externalID, 0).Result()
+        if err != nil {
+                return false, fmt.Errorf("failed to reserve external ID: %w", err)
+        }
+        return reserved, nil
+}
+
+// ReleaseExternalID gives up a reservation that wasn't bound
+func (s *DeviceService) ReleaseExternalID(externalID string) {
+        ctx := s.db.GetContext()
+        if idStr, err := s.db.GetClient().HGet(ctx, "devices:external", externalID).Result(); err == nil && idStr == "0" {
+                s.db.GetClient().HDel(ctx, "devices:external", externalID)
+        }
+}
+
+// BindExternalID records that externalID refers to the given device
+func (s *DeviceService) BindExternalID(externalID string, id int) error {
+        _, err := s.db.GetClient().TxPipelined(s.db.GetContext(), func(pipe redis.Pipeliner) error {
+                pipe.HSet(s.db.GetContext(), "devices:external", externalID, id)
+                pipe.SAdd(s.db.GetContext(), fmt.Sprintf("devices:%d:external", id), externalID)
+                return nil
+        })
+        if err != nil {
+                return fmt.Errorf("failed to bind external ID: %w", err)
+        }
+        return nil
+}
+
+func (s *DeviceService) unbindExternalIDs(id int) error {
+        key := fmt.Sprintf("devices:%d:external", id)
+        externalIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), key).Result()
+        if err != nil {
+                return fmt.Errorf("failed to get external IDs: %w", err)
+        }
+        if len(externalIDs) == 0 {
+                return nil
+        }
+        _, err = s.db.GetClient().TxPipelined(s.db.GetContext(), func(pipe redis.Pipeliner) error {
+                pipe.HDel(s.db.GetContext(), "devices:external", externalIDs...)
+                pipe.Del(s.db.GetContext(), key)
+                return nil
+        })
+        if err != nil {
+                return fmt.Errorf("failed to remove external IDs: %w", err)
+        }
+        return nil
+}
+
+func (s *DeviceService) saveDevice(device *models.Device) error {
+        deviceJSON, err := json.Marshal(device)
+        if err != nil {
+                return fmt.Errorf("failed to marshal device: %w", err)
+        }
+        if err := s.db.GetClient().HSet(s.db.GetContext(), fmt.Sprintf("devices:%d", device.ID), "data", deviceJSON).Err(); err != nil {
+                return fmt.Errorf("failed to update device: %w", err)
+        }
+        return nil
+}
@@ -0,0 +1,639 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/events"
+	"edgefleet-commander/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultEnrollmentLocation is given to enrolled devices when neither the
+// device, the bootstrap token nor the operator names a location
+const defaultEnrollmentLocation = "unassigned"
+
+var (
+	// ErrInvalidBootstrapToken is returned when an enrollment's bootstrap
+	// token is unknown, expired or used up
+	ErrInvalidBootstrapToken = errors.New("bootstrap token is invalid, expired or used up")
+	// ErrSerialRegistered is returned when a device with the serial already
+	// exists
+	ErrSerialRegistered = errors.New("a device with this serial is already registered")
+	// ErrEnrollmentDecided is returned when approving or rejecting an
+	// enrollment that is no longer pending
+	ErrEnrollmentDecided = errors.New("enrollment has already been decided")
+	// ErrEnrollmentPending is returned when another request for the serial
+	// is pending and the enrollment doesn't carry its poll token
+	ErrEnrollmentPending = errors.New("an enrollment for this serial is already pending")
+)
+
+// EnrollmentService lets devices register themselves. A device presents a
+// bootstrap token with its serial and type and waits, polling with a secret
+// it was given, until an operator approves it or its bootstrap token does so
+// by policy. Approval creates the device; its credentials are handed out on
+// the next poll. Like device credentials, bootstrap tokens and poll secrets
+// are only stored as hashes.
+type EnrollmentService struct {
+	db           *database.RedisClient
+	events       *events.Bus
+	devices      *DeviceService
+	credentials  *CredentialService
+	certificates *CertificateService
+}
+
+func NewEnrollmentService(db *database.RedisClient, bus *events.Bus, devices *DeviceService, credentials *CredentialService, certificates *CertificateService) *EnrollmentService {
+	return &EnrollmentService{
+		db:           db,
+		events:       bus,
+		devices:      devices,
+		credentials:  credentials,
+		certificates: certificates,
+	}
+}
+
+func bootstrapTokenKey(id int) string {
+	return fmt.Sprintf("bootstrap_tokens:%d", id)
+}
+
+func enrollmentKey(id int) string {
+	return fmt.Sprintf("enrollments:%d", id)
+}
+
+// serialExternalID is the external ID an enrolled device is bound to, so a
+// serial can only be registered once
+func serialExternalID(serial string) string {
+	return "serial:" + serial
+}
+
+func generateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// CreateBootstrapToken creates a token devices can enroll with. The returned
+// token is the only place the secret appears.
+func (s *EnrollmentService) CreateBootstrapToken(insert *models.InsertBootstrapToken) (*models.BootstrapToken, error) {
+	token := &models.BootstrapToken{
+		Name:        insert.Name,
+		AutoApprove: insert.AutoApprove,
+		DeviceTypes: insert.DeviceTypes,
+		Location:    insert.Location,
+		MaxUses:     insert.MaxUses,
+		CreatedAt:   time.Now(),
+	}
+	if insert.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(insert.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			return nil, &ValidationError{fmt.Sprintf("invalid expiresIn %q", insert.ExpiresIn)}
+		}
+		expiresAt := token.CreatedAt.Add(expiresIn)
+		token.ExpiresAt = &expiresAt
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+	ctx := s.db.GetContext()
+	nextID, err := s.db.GetClient().Incr(ctx, "bootstrap_tokens:next_id").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate bootstrap token ID: %w", err)
+	}
+	token.ID = int(nextID)
+
+	tokenJSON, err := json.Marshal(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal bootstrap token: %w", err)
+	}
+	hash := hashToken(secret)
+	_, err = s.db.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, bootstrapTokenKey(token.ID), "data", tokenJSON, "token_hash", hash, "uses", 0)
+		pipe.Set(ctx, "bootstrap_tokens:hash:"+hash, token.ID, 0)
+		pipe.SAdd(ctx, "bootstrap_tokens:all", token.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store bootstrap token: %w", err)
+	}
+	token.Token = secret
+	return token, nil
+}
+
+func (s *EnrollmentService) GetBootstrapToken(id int) (*models.BootstrapToken, error) {
+	stored, err := s.db.GetClient().HMGet(s.db.GetContext(), bootstrapTokenKey(id), "data", "uses").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bootstrap token: %w", err)
+	}
+	data, ok := stored[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("bootstrap token not found")
+	}
+	var token models.BootstrapToken
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal bootstrap token: %w", err)
+	}
+	if uses, ok := stored[1].(string); ok {
+		token.Uses, _ = strconv.Atoi(uses)
+	}
+	return &token, nil
+}
+
+func (s *EnrollmentService) GetBootstrapTokens() ([]models.BootstrapToken, error) {
+	tokenIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), "bootstrap_tokens:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bootstrap token IDs: %w", err)
+	}
+	tokens := []models.BootstrapToken{}
+	for _, idStr := range tokenIDs {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		token, err := s.GetBootstrapToken(id)
+		if err != nil {
+			continue
+		}
+		tokens = append(tokens, *token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+// DeleteBootstrapToken stops a bootstrap token from being used. Enrollments
+// already made with it are unaffected.
+func (s *EnrollmentService) DeleteBootstrapToken(id int) error {
+	ctx := s.db.GetContext()
+	hash, err := s.db.GetClient().HGet(ctx, bootstrapTokenKey(id), "token_hash").Result()
+	if err == redis.Nil {
+		return fmt.Errorf("bootstrap token not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get bootstrap token: %w", err)
+	}
+	_, err = s.db.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, bootstrapTokenKey(id), "bootstrap_tokens:hash:"+hash)
+		pipe.SRem(ctx, "bootstrap_tokens:all", id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete bootstrap token: %w", err)
+	}
+	return nil
+}
+
+// authenticateBootstrapToken returns the bootstrap token secret belongs to if
+// it can still be used
+func (s *EnrollmentService) authenticateBootstrapToken(secret string) (*models.BootstrapToken, error) {
+	if secret == "" {
+		return nil, ErrInvalidBootstrapToken
+	}
+	idStr, err := s.db.GetClient().Get(s.db.GetContext(), "bootstrap_tokens:hash:"+hashToken(secret)).Result()
+	if err == redis.Nil {
+		return nil, ErrInvalidBootstrapToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up bootstrap token: %w", err)
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return nil, ErrInvalidBootstrapToken
+	}
+	token, err := s.GetBootstrapToken(id)
+	if err != nil {
+		if err.Error() == "bootstrap token not found" {
+			return nil, ErrInvalidBootstrapToken
+		}
+		return nil, err
+	}
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, ErrInvalidBootstrapToken
+	}
+	return token, nil
+}
+
+// useBootstrapToken counts an enrollment against the token's use limit
+func (s *EnrollmentService) useBootstrapToken(token *models.BootstrapToken) error {
+	ctx := s.db.GetContext()
+	uses, err := s.db.GetClient().HIncrBy(ctx, bootstrapTokenKey(token.ID), "uses", 1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to count bootstrap token use: %w", err)
+	}
+	if token.MaxUses > 0 && int(uses) > token.MaxUses {
+		s.db.GetClient().HIncrBy(ctx, bootstrapTokenKey(token.ID), "uses", -1)
+		return ErrInvalidBootstrapToken
+	}
+	return nil
+}
+
+// Enroll records a device's request to be registered. A device that enrolls
+// again while its request is pending updates that request and gets a new
+// poll token. When the bootstrap token auto-approves, the device is created
+// and its credentials returned straight away.
+func (s *EnrollmentService) Enroll(bootstrapToken string, request *models.EnrollmentRequest) (*models.EnrollmentStatus, error) {
+	token, err := s.authenticateBootstrapToken(bootstrapToken)
+	if err != nil {
+		return nil, err
+	}
+	request.Serial = strings.TrimSpace(request.Serial)
+	if request.Serial == "" {
+		return nil, &ValidationError{"serial is required"}
+	}
+	if len(token.DeviceTypes) > 0 && !slices.Contains(token.DeviceTypes, request.Type) {
+		return nil, &ValidationError{fmt.Sprintf("device type %q may not enroll with this bootstrap token", request.Type)}
+	}
+	if request.CSR != "" {
+		if _, err := parseCSR(request.CSR); err != nil {
+			return nil, err
+		}
+	}
+	existing, err := s.devices.FindDeviceByExternalID(serialExternalID(request.Serial))
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrSerialRegistered
+	}
+
+	ctx := s.db.GetContext()
+	serialKey := "enrollments:serial:" + request.Serial
+	pending, err := s.pendingEnrollment(serialKey)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		// Only the device holding the pending request's poll token may send
+		// it again, and doing so leaves the request as it was
+		matches, err := s.pollTokenMatches(pending.ID, request.PollToken)
+		if err != nil {
+			return nil, err
+		}
+		if !matches {
+			return nil, ErrEnrollmentPending
+		}
+		status := &models.EnrollmentStatus{Enrollment: *pending}
+		status.PollToken = request.PollToken
+		return status, nil
+	}
+
+	nextID, err := s.db.GetClient().Incr(ctx, "enrollments:next_id").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate enrollment ID: %w", err)
+	}
+	enrollment := &models.Enrollment{
+		ID:               int(nextID),
+		Serial:           request.Serial,
+		Type:             request.Type,
+		Name:             request.Name,
+		Location:         request.Location,
+		CSR:              request.CSR,
+		Status:           models.EnrollmentPending,
+		BootstrapTokenID: token.ID,
+		RequestedAt:      time.Now(),
+	}
+	if enrollment.Location == "" {
+		enrollment.Location = token.Location
+	}
+
+	// Claiming the serial first means of two devices enrolling with it at
+	// once, only one gets a request
+	claimed, err := s.db.GetClient().SetNX(ctx, serialKey, enrollment.ID, 0).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim serial: %w", err)
+	}
+	if !claimed {
+		return nil, ErrEnrollmentPending
+	}
+	if err := s.useBootstrapToken(token); err != nil {
+		s.releaseSerial(enrollment.Serial, enrollment.ID)
+		return nil, err
+	}
+
+	pollToken, err := generateSecret()
+	if err != nil {
+		s.releaseSerial(enrollment.Serial, enrollment.ID)
+		return nil, err
+	}
+	enrollmentJSON, err := json.Marshal(enrollment)
+	if err != nil {
+		s.releaseSerial(enrollment.Serial, enrollment.ID)
+		return nil, fmt.Errorf("failed to marshal enrollment: %w", err)
+	}
+	_, err = s.db.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, enrollmentKey(enrollment.ID), "data", enrollmentJSON, "poll_hash", hashToken(pollToken))
+		pipe.SAdd(ctx, "enrollments:all", enrollment.ID)
+		return nil
+	})
+	if err != nil {
+		s.releaseSerial(enrollment.Serial, enrollment.ID)
+		return nil, fmt.Errorf("failed to store enrollment: %w", err)
+	}
+	s.events.Publish(events.EnrollmentRequested, 0, *enrollment)
+
+	if token.AutoApprove {
+		// The device has its poll token whatever happens here: a request
+		// that can't be approved stays pending for an operator, and
+		// credentials that can't be issued yet are collected by polling
+		if _, err := s.Approve(enrollment.ID, &models.EnrollmentDecision{}); err != nil {
+			log.Printf("Warning: failed to auto-approve enrollment %d: %v", enrollment.ID, err)
+		}
+		status, err := s.Collect(enrollment.ID, pollToken)
+		if err != nil {
+			log.Printf("Warning: failed to collect credentials for enrollment %d: %v", enrollment.ID, err)
+			status = &models.EnrollmentStatus{Enrollment: *enrollment}
+		}
+		status.PollToken = pollToken
+		return status, nil
+	}
+	status := &models.EnrollmentStatus{Enrollment: *enrollment}
+	status.PollToken = pollToken
+	return status, nil
+}
+
+// pendingEnrollment returns the request holding the serial, or nil if it is
+// free. A serial still held by a decided request is freed; one claimed by a
+// request not stored yet reads as pending.
+func (s *EnrollmentService) pendingEnrollment(serialKey string) (*models.Enrollment, error) {
+	pendingID, err := s.db.GetClient().Get(s.db.GetContext(), serialKey).Int()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up enrollment: %w", err)
+	}
+	enrollment, err := s.GetEnrollment(pendingID)
+	if err != nil && err.Error() == "enrollment not found" {
+		return &models.Enrollment{ID: pendingID, Status: models.EnrollmentPending}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enrollment.Status != models.EnrollmentPending {
+		s.releaseSerial(enrollment.Serial, enrollment.ID)
+		return nil, nil
+	}
+	return enrollment, nil
+}
+
+// releaseSerial frees the serial if the given request still holds it
+func (s *EnrollmentService) releaseSerial(serial string, id int) {
+	ctx := s.db.GetContext()
+	serialKey := "enrollments:serial:" + serial
+	err := s.db.GetClient().Watch(ctx, func(tx *redis.Tx) error {
+		pendingID, err := tx.Get(ctx, serialKey).Int()
+		if err != nil || pendingID != id {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, serialKey)
+			return nil
+		})
+		return err
+	}, serialKey)
+	if err != nil && err != redis.Nil {
+		log.Printf("Warning: failed to release serial %s of enrollment %d: %v", serial, id, err)
+	}
+}
+
+// pollTokenMatches reports whether pollToken is the enrollment's
+func (s *EnrollmentService) pollTokenMatches(id int, pollToken string) (bool, error) {
+	stored, err := s.db.GetClient().HGet(s.db.GetContext(), enrollmentKey(id), "poll_hash").Result()
+	if err != nil && err != redis.Nil {
+		return false, fmt.Errorf("failed to get enrollment: %w", err)
+	}
+	return stored != "" && pollToken != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(hashToken(pollToken))) == 1, nil
+}
+
+func (s *EnrollmentService) GetEnrollment(id int) (*models.Enrollment, error) {
+	data, err := s.db.GetClient().HGet(s.db.GetContext(), enrollmentKey(id), "data").Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("enrollment not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get enrollment: %w", err)
+	}
+	var enrollment models.Enrollment
+	if err := json.Unmarshal([]byte(data), &enrollment); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal enrollment: %w", err)
+	}
+	return &enrollment, nil
+}
+
+// GetEnrollments lists enrollments, oldest first, optionally only those with
+// the given status
+func (s *EnrollmentService) GetEnrollments(status string) ([]models.Enrollment, error) {
+	enrollmentIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), "enrollments:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get enrollment IDs: %w", err)
+	}
+	enrollments := []models.Enrollment{}
+	for _, idStr := range enrollmentIDs {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		enrollment, err := s.GetEnrollment(id)
+		if err != nil {
+			continue
+		}
+		if status != "" && enrollment.Status != status {
+			continue
+		}
+		enrollments = append(enrollments, *enrollment)
+	}
+	sort.Slice(enrollments, func(i, j int) bool { return enrollments[i].ID < enrollments[j].ID })
+	return enrollments, nil
+}
+
+func (s *EnrollmentService) saveEnrollment(enrollment *models.Enrollment) error {
+	enrollmentJSON, err := json.Marshal(enrollment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal enrollment: %w", err)
+	}
+	if err := s.db.GetClient().HSet(s.db.GetContext(), enrollmentKey(enrollment.ID), "data", enrollmentJSON).Err(); err != nil {
+		return fmt.Errorf("failed to store enrollment: %w", err)
+	}
+	return nil
+}
+
+// decide claims a pending enrollment so only one approval or rejection of it
+// goes through
+func (s *EnrollmentService) decide(id int) (*models.Enrollment, error) {
+	enrollment, err := s.GetEnrollment(id)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := s.db.GetClient().HSetNX(s.db.GetContext(), enrollmentKey(id), "decided", 1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update enrollment: %w", err)
+	}
+	if !claimed || enrollment.Status != models.EnrollmentPending {
+		return nil, ErrEnrollmentDecided
+	}
+	return enrollment, nil
+}
+
+// finishDecision stores the outcome and frees the serial for a new request
+func (s *EnrollmentService) finishDecision(enrollment *models.Enrollment) error {
+	now := time.Now()
+	enrollment.DecidedAt = &now
+	if err := s.saveEnrollment(enrollment); err != nil {
+		return err
+	}
+	s.releaseSerial(enrollment.Serial, enrollment.ID)
+	s.events.Publish(events.EnrollmentDecided, enrollment.DeviceID, *enrollment)
+	return nil
+}
+
+// Approve registers the enrolling device, offline until it reports in. The
+// decision may override the name and location the device asked for and
+// place it under a parent.
+func (s *EnrollmentService) Approve(id int, decision *models.EnrollmentDecision) (*models.Enrollment, error) {
+	enrollment, err := s.decide(id)
+	if err != nil {
+		return nil, err
+	}
+	undecide := func() {
+		s.db.GetClient().HDel(s.db.GetContext(), enrollmentKey(id), "decided")
+	}
+
+	// Reserve the serial so no other approval registers a device with it
+	reserved, err := s.devices.ReserveExternalID(serialExternalID(enrollment.Serial))
+	if err == nil && !reserved {
+		err = ErrSerialRegistered
+	}
+	if err != nil {
+		undecide()
+		return nil, err
+	}
+
+	insert := &models.InsertDevice{
+		Name:     enrollment.Name,
+		Type:     enrollment.Type,
+		Location: enrollment.Location,
+		Status:   "offline",
+		ParentID: decision.ParentID,
+	}
+	if decision.Name != "" {
+		insert.Name = decision.Name
+	}
+	if insert.Name == "" {
+		insert.Name = fmt.Sprintf("%s-%s", enrollment.Type, enrollment.Serial)
+	}
+	if decision.Location != "" {
+		insert.Location = decision.Location
+	}
+	if insert.Location == "" {
+		insert.Location = defaultEnrollmentLocation
+	}
+	device, err := s.devices.CreateDevice(insert)
+	if err != nil {
+		s.devices.ReleaseExternalID(serialExternalID(enrollment.Serial))
+		undecide()
+		return nil, err
+	}
+	// A registration that can't be completed is undone, leaving the request
+	// pending to be decided again
+	rollback := func() {
+		if err := s.devices.DeleteDevice(device.ID); err != nil {
+			log.Printf("Warning: failed to delete device %d of enrollment %d: %v", device.ID, id, err)
+		}
+		s.devices.ReleaseExternalID(serialExternalID(enrollment.Serial))
+		undecide()
+	}
+	if err := s.devices.BindExternalID(serialExternalID(enrollment.Serial), device.ID); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	enrollment.Status = models.EnrollmentApproved
+	enrollment.DeviceID = device.ID
+	enrollment.Name = device.Name
+	enrollment.Location = device.Location
+	if err := s.finishDecision(enrollment); err != nil {
+		rollback()
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+// Reject turns an enrollment down. The device may enroll again.
+func (s *EnrollmentService) Reject(id int, reason string) (*models.Enrollment, error) {
+	enrollment, err := s.decide(id)
+	if err != nil {
+		return nil, err
+	}
+	enrollment.Status = models.EnrollmentRejected
+	enrollment.Reason = reason
+	if err := s.finishDecision(enrollment); err != nil {
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+// Collect tells an enrolling device where its request stands. On the first
+// poll after approval the device's credential is issued and returned, along
+// with a certificate for the CSR it enrolled with; later polls only report
+// the status. A wrong poll token reads as an unknown enrollment.
+func (s *EnrollmentService) Collect(id int, pollToken string) (*models.EnrollmentStatus, error) {
+	ctx := s.db.GetContext()
+	matches, err := s.pollTokenMatches(id, pollToken)
+	if err != nil {
+		return nil, err
+	}
+	if !matches {
+		return nil, fmt.Errorf("enrollment not found")
+	}
+	enrollment, err := s.GetEnrollment(id)
+	if err != nil {
+		return nil, err
+	}
+	status := &models.EnrollmentStatus{Enrollment: *enrollment}
+	if enrollment.Status != models.EnrollmentApproved || enrollment.CredentialsCollected {
+		return status, nil
+	}
+
+	claimed, err := s.db.GetClient().HSetNX(ctx, enrollmentKey(id), "collected", 1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update enrollment: %w", err)
+	}
+	if !claimed {
+		status.CredentialsCollected = true
+		return status, nil
+	}
+	uncollect := func() {
+		s.db.GetClient().HDel(ctx, enrollmentKey(id), "collected")
+	}
+	status.Credential, err = s.credentials.IssueCredential(enrollment.DeviceID, 0)
+	if err != nil {
+		uncollect()
+		return nil, err
+	}
+	if enrollment.CSR != "" {
+		status.Certificate, err = s.certificates.IssueCertificate(enrollment.DeviceID, models.IssueCertificateRequest{CSR: enrollment.CSR})
+		if err != nil {
+			uncollect()
+			return nil, err
+		}
+	}
+	enrollment.CredentialsCollected = true
+	if err := s.saveEnrollment(enrollment); err != nil {
+		return nil, err
+	}
+	status.Enrollment = *enrollment
+	return status, nil
+}
@@ -0,0 +1,533 @@
+package services
+
+import (
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/events"
+	"edgefleet-commander/internal/models"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Incident statuses
+const (
+	IncidentStatusOpen         = "open"
+	IncidentStatusAcknowledged = "acknowledged"
+	IncidentStatusResolved     = "resolved"
+)
+
+// How an incident came to exist
+const (
+	IncidentSourceManual   = "manual"
+	IncidentSourceTopology = "topology"
+	IncidentSourceLocation = "location"
+)
+
+// Incident timeline event types
+const (
+	IncidentEventCreated           = "created"
+	IncidentEventAlertAdded        = "alert_added"
+	IncidentEventAlertAcknowledged = "alert_acknowledged"
+	IncidentEventAlertResolved     = "alert_resolved"
+	IncidentEventComment           = "comment"
+	IncidentEventStatusChanged     = "status_changed"
+)
+
+var severityRank = map[string]int{"info": 1, "warning": 2, "critical": 3}
+
+type IncidentService struct {
+	db     *database.RedisClient
+	events *events.Bus
+	window time.Duration
+}
+
+// NewIncidentService creates an incident service that groups alerts raised at
+// the same location within window of each other.
+func NewIncidentService(db *database.RedisClient, bus *events.Bus, window time.Duration) *IncidentService {
+	return &IncidentService{db: db, events: bus, window: window}
+}
+
+func (s *IncidentService) GetAllIncidents(status string) ([]models.Incident, error) {
+	incidentIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), "incidents:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get incident IDs: %w", err)
+	}
+	incidents := []models.Incident{}
+	for _, idStr := range incidentIDs {
+		incidentData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("incidents:%s", idStr), "data").Result()
+		if err != nil {
+			continue
+		}
+		var incident models.Incident
+		if err := json.Unmarshal([]byte(incidentData), &incident); err != nil {
+			continue
+		}
+		if status != "" && incident.Status != status {
+			continue
+		}
+		incidents = append(incidents, incident)
+	}
+	return incidents, nil
+}
+
+func (s *IncidentService) GetIncidentByID(id int) (*models.Incident, error) {
+	incidentData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("incidents:%d", id), "data").Result()
+	if err != nil {
+		return nil, fmt.Errorf("incident not found")
+	}
+	var incident models.Incident
+	if err := json.Unmarshal([]byte(incidentData), &incident); err != nil {
+		return nil, fmt.Errorf("failed to parse incident data: %w", err)
+	}
+	return &incident, nil
+}
+
+// GetIncidentAlerts returns the alerts currently grouped under an incident
+func (s *IncidentService) GetIncidentAlerts(id int) ([]models.Alert, error) {
+	incident, err := s.GetIncidentByID(id)
+	if err != nil {
+		return nil, err
+	}
+	alerts := []models.Alert{}
+	for _, alertID := range incident.AlertIDs {
+		alert, err := s.getAlert(alertID)
+		if err != nil {
+			continue
+		}
+		alerts = append(alerts, *alert)
+	}
+	return alerts, nil
+}
+
+func (s *IncidentService) GetTimeline(id int) ([]models.IncidentEvent, error) {
+	if _, err := s.GetIncidentByID(id); err != nil {
+		return nil, err
+	}
+	entries, err := s.db.GetClient().LRange(s.db.GetContext(), fmt.Sprintf("incidents:%d:timeline", id), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get incident timeline: %w", err)
+	}
+	timeline := []models.IncidentEvent{}
+	for _, entry := range entries {
+		var event models.IncidentEvent
+		if err := json.Unmarshal([]byte(entry), &event); err != nil {
+			continue
+		}
+		timeline = append(timeline, event)
+	}
+	return timeline, nil
+}
+
+// CreateIncident opens an incident by hand, optionally seeded with alerts
+func (s *IncidentService) CreateIncident(insertIncident *models.InsertIncident) (*models.Incident, error) {
+	alerts := make([]*models.Alert, 0, len(insertIncident.AlertIDs))
+	for _, alertID := range insertIncident.AlertIDs {
+		alert, err := s.getAlert(alertID)
+		if err != nil {
+			return nil, err
+		}
+		if alert.IncidentID != nil {
+			return nil, fmt.Errorf("alert %d already belongs to incident %d", alert.ID, *alert.IncidentID)
+		}
+		alerts = append(alerts, alert)
+	}
+
+	severity := insertIncident.Severity
+	if severity == "" {
+		severity = "info"
+	}
+	incident, err := s.newIncident(insertIncident.Title, severity, "", IncidentSourceManual)
+	if err != nil {
+		return nil, err
+	}
+	for _, alert := range alerts {
+		if err := s.attachAlert(incident, alert); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.saveIncident(incident); err != nil {
+		return nil, err
+	}
+	return incident, nil
+}
+
+// AddAlerts attaches existing alerts to an incident
+func (s *IncidentService) AddAlerts(id int, alertIDs []int) (*models.Incident, error) {
+	incident, err := s.GetIncidentByID(id)
+	if err != nil {
+		return nil, err
+	}
+	alerts := make([]*models.Alert, 0, len(alertIDs))
+	for _, alertID := range alertIDs {
+		alert, err := s.getAlert(alertID)
+		if err != nil {
+			return nil, err
+		}
+		if alert.IncidentID != nil && *alert.IncidentID != incident.ID {
+			return nil, fmt.Errorf("alert %d already belongs to incident %d", alert.ID, *alert.IncidentID)
+		}
+		alerts = append(alerts, alert)
+	}
+	for _, alert := range alerts {
+		if alert.IncidentID != nil {
+			continue
+		}
+		if err := s.attachAlert(incident, alert); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.saveIncident(incident); err != nil {
+		return nil, err
+	}
+	return incident, nil
+}
+
+func (s *IncidentService) AddComment(id int, comment *models.IncidentComment) (*models.IncidentEvent, error) {
+	incident, err := s.GetIncidentByID(id)
+	if err != nil {
+		return nil, err
+	}
+	event := models.IncidentEvent{
+		Type:      IncidentEventComment,
+		Message:   comment.Message,
+		Author:    comment.Author,
+		Timestamp: time.Now(),
+	}
+	if err := s.appendEvent(incident.ID, event); err != nil {
+		return nil, err
+	}
+	incident.UpdatedAt = event.Timestamp
+	if err := s.saveIncident(incident); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// UpdateStatus moves an incident through its lifecycle. Acknowledging or
+// resolving an incident does the same to every alert grouped under it.
+func (s *IncidentService) UpdateStatus(id int, status, author string) (*models.Incident, error) {
+	incident, err := s.GetIncidentByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if incident.Status == status {
+		return incident, nil
+	}
+
+	now := time.Now()
+	for _, alertID := range incident.AlertIDs {
+		alert, err := s.getAlert(alertID)
+		if err != nil {
+			continue
+		}
+		changed := false
+		switch status {
+		case IncidentStatusAcknowledged:
+			changed = markAcknowledged(alert, now)
+		case IncidentStatusResolved:
+			changed = markResolved(alert, now)
+		}
+		if changed {
+			if err := s.saveAlert(alert); err != nil {
+				return nil, err
+			}
+			eventType := events.AlertAcknowledged
+			if status == IncidentStatusResolved {
+				eventType = events.AlertResolved
+			}
+			s.events.Publish(eventType, alert.DeviceID, *alert)
+		}
+	}
+
+	previous := incident.Status
+	incident.Status = status
+	incident.UpdatedAt = now
+	switch status {
+	case IncidentStatusOpen:
+		incident.AcknowledgedAt = nil
+		incident.ResolvedAt = nil
+	case IncidentStatusAcknowledged:
+		incident.AcknowledgedAt = &now
+		incident.ResolvedAt = nil
+	case IncidentStatusResolved:
+		if incident.AcknowledgedAt == nil {
+			incident.AcknowledgedAt = &now
+		}
+		incident.ResolvedAt = &now
+	}
+	if err := s.saveIncident(incident); err != nil {
+		return nil, err
+	}
+	event := models.IncidentEvent{
+		Type:      IncidentEventStatusChanged,
+		Message:   fmt.Sprintf("Status changed from %s to %s", previous, status),
+		Author:    author,
+		Timestamp: now,
+	}
+	if err := s.appendEvent(incident.ID, event); err != nil {
+		return nil, err
+	}
+	return incident, nil
+}
+
+// CorrelateAlert files a newly created alert into an incident. An alert
+// correlated to an upstream alert joins (or opens) the upstream alert's
+// incident; otherwise it joins a recent open incident at the same location,
+// or opens one together with other recent ungrouped alerts from there.
+func (s *IncidentService) CorrelateAlert(alert *models.Alert, upstream *models.Alert) error {
+	if upstream != nil {
+		return s.correlateByTopology(alert, upstream)
+	}
+
+	device, err := s.getDevice(alert.DeviceID)
+	if err != nil || device.Location == "" {
+		return nil
+	}
+	since := alert.CreatedAt.Add(-s.window)
+
+	incidents, err := s.GetAllIncidents("")
+	if err != nil {
+		return err
+	}
+	var target *models.Incident
+	for i := range incidents {
+		incident := &incidents[i]
+		if incident.Status == IncidentStatusResolved || incident.Location != device.Location || incident.LastAlertAt.Before(since) {
+			continue
+		}
+		if target == nil || incident.LastAlertAt.After(target.LastAlertAt) {
+			target = incident
+		}
+	}
+	if target != nil {
+		if err := s.attachAlert(target, alert); err != nil {
+			return err
+		}
+		return s.saveIncident(target)
+	}
+
+	neighbours, err := s.recentUngroupedAlerts(alert, device.Location, since)
+	if err != nil || len(neighbours) == 0 {
+		return err
+	}
+	incident, err := s.newIncident(fmt.Sprintf("Multiple alerts at %s", device.Location), alert.Severity, device.Location, IncidentSourceLocation)
+	if err != nil {
+		return err
+	}
+	for _, neighbour := range append(neighbours, alert) {
+		if err := s.attachAlert(incident, neighbour); err != nil {
+			return err
+		}
+	}
+	return s.saveIncident(incident)
+}
+
+// RecordAlertEvent notes an alert's acknowledgement or resolution on the
+// timeline of the incident it belongs to, if any.
+func (s *IncidentService) RecordAlertEvent(alert *models.Alert, eventType string) error {
+	if alert.IncidentID == nil {
+		return nil
+	}
+	verb := "acknowledged"
+	if eventType == IncidentEventAlertResolved {
+		verb = "resolved"
+	}
+	alertID := alert.ID
+	return s.appendEvent(*alert.IncidentID, models.IncidentEvent{
+		Type:      eventType,
+		Message:   fmt.Sprintf("Alert %d (%s) %s", alert.ID, alert.Type, verb),
+		AlertID:   &alertID,
+		Timestamp: time.Now(),
+	})
+}
+
+func (s *IncidentService) correlateByTopology(alert, upstream *models.Alert) error {
+	if upstream.IncidentID != nil {
+		incident, err := s.GetIncidentByID(*upstream.IncidentID)
+		if err == nil && incident.Status != IncidentStatusResolved {
+			if err := s.attachAlert(incident, alert); err != nil {
+				return err
+			}
+			return s.saveIncident(incident)
+		}
+	}
+
+	title := fmt.Sprintf("%s on device %d", upstream.Type, upstream.DeviceID)
+	location := ""
+	if device, err := s.getDevice(upstream.DeviceID); err == nil {
+		title = fmt.Sprintf("%s on %s", upstream.Type, device.Name)
+		location = device.Location
+	}
+	incident, err := s.newIncident(title, upstream.Severity, location, IncidentSourceTopology)
+	if err != nil {
+		return err
+	}
+	for _, member := range []*models.Alert{upstream, alert} {
+		if err := s.attachAlert(incident, member); err != nil {
+			return err
+		}
+	}
+	return s.saveIncident(incident)
+}
+
+// recentUngroupedAlerts finds open alerts without an incident raised at
+// location since the given time, other than alert itself.
+func (s *IncidentService) recentUngroupedAlerts(alert *models.Alert, location string, since time.Time) ([]*models.Alert, error) {
+	alertIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), "alerts:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alert IDs: %w", err)
+	}
+	locations := map[int]string{}
+	var alerts []*models.Alert
+	for _, idStr := range alertIDs {
+		alertData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("alerts:%s", idStr), "data").Result()
+		if err != nil {
+			continue
+		}
+		candidate := &models.Alert{}
+		if err := json.Unmarshal([]byte(alertData), candidate); err != nil {
+			continue
+		}
+		if candidate.ID == alert.ID || candidate.Resolved || candidate.IncidentID != nil || candidate.CreatedAt.Before(since) {
+			continue
+		}
+		candidateLocation, ok := locations[candidate.DeviceID]
+		if !ok {
+			if device, err := s.getDevice(candidate.DeviceID); err == nil {
+				candidateLocation = device.Location
+			}
+			locations[candidate.DeviceID] = candidateLocation
+		}
+		if candidateLocation == location {
+			alerts = append(alerts, candidate)
+		}
+	}
+	return alerts, nil
+}
+
+func (s *IncidentService) newIncident(title, severity, location, source string) (*models.Incident, error) {
+	nextID, err := s.db.GetClient().Incr(s.db.GetContext(), "incidents:next_id").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate incident ID: %w", err)
+	}
+	now := time.Now()
+	incident := &models.Incident{
+		ID:        int(nextID),
+		Title:     title,
+		Status:    IncidentStatusOpen,
+		Severity:  severity,
+		Location:  location,
+		Source:    source,
+		AlertIDs:  []int{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.storeIncident(incident); err != nil {
+		return nil, err
+	}
+	if err := s.db.GetClient().SAdd(s.db.GetContext(), "incidents:all", incident.ID).Err(); err != nil {
+		return nil, fmt.Errorf("failed to add incident to set: %w", err)
+	}
+	s.events.Publish(events.IncidentCreated, 0, *incident)
+	if err := s.appendEvent(incident.ID, models.IncidentEvent{
+		Type:      IncidentEventCreated,
+		Message:   fmt.Sprintf("Incident opened (%s)", source),
+		Timestamp: now,
+	}); err != nil {
+		return nil, err
+	}
+	return incident, nil
+}
+
+// attachAlert links alert to incident and records it on the timeline. The
+// caller saves the incident.
+func (s *IncidentService) attachAlert(incident *models.Incident, alert *models.Alert) error {
+	incidentID := incident.ID
+	alert.IncidentID = &incidentID
+	if err := s.saveAlert(alert); err != nil {
+		return err
+	}
+
+	incident.AlertIDs = append(incident.AlertIDs, alert.ID)
+	if severityRank[alert.Severity] > severityRank[incident.Severity] {
+		incident.Severity = alert.Severity
+	}
+	if alert.CreatedAt.After(incident.LastAlertAt) {
+		incident.LastAlertAt = alert.CreatedAt
+	}
+	incident.UpdatedAt = time.Now()
+
+	alertID := alert.ID
+	return s.appendEvent(incident.ID, models.IncidentEvent{
+		Type:      IncidentEventAlertAdded,
+		Message:   fmt.Sprintf("Alert %d (%s) added: %s", alert.ID, alert.Type, alert.Message),
+		AlertID:   &alertID,
+		Timestamp: incident.UpdatedAt,
+	})
+}
+
+func (s *IncidentService) appendEvent(incidentID int, event models.IncidentEvent) error {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal incident event: %w", err)
+	}
+	if err := s.db.GetClient().RPush(s.db.GetContext(), fmt.Sprintf("incidents:%d:timeline", incidentID), eventJSON).Err(); err != nil {
+		return fmt.Errorf("failed to append incident event: %w", err)
+	}
+	return nil
+}
+
+// saveIncident stores an existing incident and announces the change
+func (s *IncidentService) saveIncident(incident *models.Incident) error {
+	if err := s.storeIncident(incident); err != nil {
+		return err
+	}
+	s.events.Publish(events.IncidentUpdated, 0, *incident)
+	return nil
+}
+
+func (s *IncidentService) storeIncident(incident *models.Incident) error {
+	incidentJSON, err := json.Marshal(incident)
+	if err != nil {
+		return fmt.Errorf("failed to marshal incident: %w", err)
+	}
+	if err := s.db.GetClient().HSet(s.db.GetContext(), fmt.Sprintf("incidents:%d", incident.ID), "data", incidentJSON).Err(); err != nil {
+		return fmt.Errorf("failed to store incident: %w", err)
+	}
+	return nil
+}
+
+func (s *IncidentService) getAlert(id int) (*models.Alert, error) {
+	alertData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("alerts:%d", id), "data").Result()
+	if err != nil {
+		return nil, fmt.Errorf("alert not found")
+	}
+	var alert models.Alert
+	if err := json.Unmarshal([]byte(alertData), &alert); err != nil {
+		return nil, fmt.Errorf("failed to parse alert data: %w", err)
+	}
+	return &alert, nil
+}
+
+func (s *IncidentService) saveAlert(alert *models.Alert) error {
+	alertJSON, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	if err := s.db.GetClient().HSet(s.db.GetContext(), fmt.Sprintf("alerts:%d", alert.ID), "data", alertJSON).Err(); err != nil {
+		return fmt.Errorf("failed to update alert: %w", err)
+	}
+	return nil
+}
+
+func (s *IncidentService) getDevice(id int) (*models.Device, error) {
+	deviceData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("devices:%d", id), "data").Result()
+	if err != nil {
+		return nil, fmt.Errorf("device not found")
+	}
+	var device models.Device
+	if err := json.Unmarshal([]byte(deviceData), &device); err != nil {
+		return nil, fmt.Errorf("failed to parse device data: %w", err)
+	}
+	return &device, nil
+}
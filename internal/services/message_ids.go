@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"edgefleet-commander/internal/database"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// maxMessageIDLength bounds the client message IDs kept for deduplication
+const maxMessageIDLength = 128
+
+// ErrMessageInFlight is returned when a record with the same message ID is
+// still being stored by another request
+var ErrMessageInFlight = errors.New("a request with this message ID is still being processed")
+
+// messageIDs remembers the client message IDs of recently created records,
+// so a device retrying a request gets the original record back instead of a
+// duplicate. Each ID is held in {prefix}:msg:{deviceID}:{messageID} with the
+// record's ID and expires after the window.
+type messageIDs struct {
+	db     *database.RedisClient
+	prefix string
+	window time.Duration
+}
+
+// messageClaim is one record about to be stored under a message ID
+type messageClaim struct {
+	deviceID  int
+	messageID string
+	id        int
+}
+
+func validateMessageID(messageID string) error {
+	if len(messageID) > maxMessageIDLength {
+		return &ValidationError{fmt.Sprintf("messageId may be at most %d characters", maxMessageIDLength)}
+	}
+	return nil
+}
+
+func (m messageIDs) enabled() bool {
+	return m.window > 0
+}
+
+func (m messageIDs) key(deviceID int, messageID string) string {
+	return fmt.Sprintf("%s:msg:%d:%s", m.prefix, deviceID, messageID)
+}
+
+// claim records the message IDs of records about to be stored, in one round
+// trip. For each claim it returns 0 if the message ID is new, or the ID of
+// the record first stored under it.
+func (m messageIDs) claim(ctx context.Context, claims []messageClaim) ([]int, error) {
+	originals := make([]int, len(claims))
+	if len(claims) == 0 {
+		return originals, nil
+	}
+	setCmds := make([]*redis.BoolCmd, len(claims))
+	_, err := m.db.GetClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, claim := range claims {
+			setCmds[i] = pipe.SetNX(ctx, m.key(claim.deviceID, claim.messageID), claim.id, m.window)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim message IDs: %w", err)
+	}
+
+	getCmds := make([]*redis.StringCmd, len(claims))
+	_, err = m.db.GetClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, claim := range claims {
+			if !setCmds[i].Val() {
+				getCmds[i] = pipe.Get(ctx, m.key(claim.deviceID, claim.messageID))
+			}
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to look up message IDs: %w", err)
+	}
+	for i, cmd := range getCmds {
+		if cmd != nil {
+			// A claim that expired in between counts as seen, with no record
+			// to return; callers treat it as still in flight
+			originals[i], _ = cmd.Int()
+			if originals[i] == 0 {
+				originals[i] = -1
+			}
+		}
+	}
+	return originals, nil
+}
+
+// release forgets message IDs whose records failed to store, so the device
+// can retry them
+func (m messageIDs) release(ctx context.Context, claims []messageClaim) {
+	if len(claims) == 0 {
+		return
+	}
+	keys := make([]string, len(claims))
+	for i, claim := range claims {
+		keys[i] = m.key(claim.deviceID, claim.messageID)
+	}
+	m.db.GetClient().Del(ctx, keys...)
+}
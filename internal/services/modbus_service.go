@@ -0,0 +1,153 @@
+package services
+
+import (
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/models"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultModbusPort is used when a config's host has no port
+const defaultModbusPort = "502"
+
+// ModbusService stores the Modbus polling configuration of devices and the
+// outcome of their polls
+type ModbusService struct {
+	db *database.RedisClient
+	pollRecorder
+}
+
+func NewModbusService(db *database.RedisClient) *ModbusService {
+	return &ModbusService{db: db, pollRecorder: pollRecorder{db: db, prefix: "modbus"}}
+}
+
+// GetAllConfigs returns every device's Modbus config, with its poll status
+func (s *ModbusService) GetAllConfigs() ([]models.ModbusConfig, error) {
+	deviceIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), "modbus:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Modbus device IDs: %w", err)
+	}
+
+	configs := []models.ModbusConfig{}
+	for _, idStr := range deviceIDs {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		config, err := s.GetConfig(id)
+		if err != nil {
+			continue
+		}
+		configs = append(configs, *config)
+	}
+	return configs, nil
+}
+
+func (s *ModbusService) GetConfig(deviceID int) (*models.ModbusConfig, error) {
+	configData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("modbus:%d", deviceID), "data").Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("modbus config not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Modbus config: %w", err)
+	}
+
+	var config models.ModbusConfig
+	if err := json.Unmarshal([]byte(configData), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse Modbus config: %w", err)
+	}
+	status, err := s.GetStatus(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	config.Status = status
+	return &config, nil
+}
+
+// SaveConfig creates or replaces a device's Modbus config. Polls start on
+// the collector's next refresh.
+func (s *ModbusService) SaveConfig(deviceID int, insertConfig *models.InsertModbusConfig) (*models.ModbusConfig, error) {
+	exists, err := s.db.GetClient().Exists(s.db.GetContext(), fmt.Sprintf("devices:%d", deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check device: %w", err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("device not found")
+	}
+
+	host := insertConfig.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, defaultModbusPort)
+	}
+	config := &models.ModbusConfig{
+		DeviceID:        deviceID,
+		Host:            host,
+		UnitID:          insertConfig.UnitID,
+		IntervalSeconds: insertConfig.IntervalSeconds,
+		Enabled:         insertConfig.Enabled == nil || *insertConfig.Enabled,
+		Registers:       insertConfig.Registers,
+		UpdatedAt:       time.Now(),
+	}
+	if err := normalizeModbusRegisters(config.Registers); err != nil {
+		return nil, err
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Modbus config: %w", err)
+	}
+	if err := s.db.GetClient().HSet(s.db.GetContext(), fmt.Sprintf("modbus:%d", deviceID), "data", configJSON).Err(); err != nil {
+		return nil, fmt.Errorf("failed to store Modbus config: %w", err)
+	}
+	if err := s.db.GetClient().SAdd(s.db.GetContext(), "modbus:all", deviceID).Err(); err != nil {
+		return nil, fmt.Errorf("failed to add Modbus config to set: %w", err)
+	}
+
+	config.Status, err = s.GetStatus(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (s *ModbusService) DeleteConfig(deviceID int) error {
+	err := s.db.GetClient().Del(s.db.GetContext(), fmt.Sprintf("modbus:%d", deviceID), fmt.Sprintf("modbus:%d:status", deviceID)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete Modbus config: %w", err)
+	}
+	if err := s.db.GetClient().SRem(s.db.GetContext(), "modbus:all", deviceID).Err(); err != nil {
+		return fmt.Errorf("failed to remove Modbus config from set: %w", err)
+	}
+	return nil
+}
+
+// normalizeModbusRegisters fills in defaults and checks each mapping is one
+// the collector can read
+func normalizeModbusRegisters(registers []models.ModbusRegister) error {
+	for i := range registers {
+		r := &registers[i]
+		if !metricNamePattern.MatchString(r.Metric) {
+			return &ValidationError{fmt.Sprintf("invalid metric name %q", r.Metric)}
+		}
+		if r.Table == "" {
+			r.Table = "holding"
+		}
+		isBitTable := r.Table == "coil" || r.Table == "discrete"
+		switch {
+		case r.Type == "":
+			if isBitTable {
+				r.Type = "bool"
+			} else {
+				r.Type = "uint16"
+			}
+		case isBitTable != (r.Type == "bool"):
+			return &ValidationError{fmt.Sprintf("metric %q: type %s cannot be read from %s table", r.Metric, r.Type, r.Table)}
+		}
+	}
+	return nil
+}
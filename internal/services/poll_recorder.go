@@ -0,0 +1,107 @@
+package services
+
+import (
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/models"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// pollRecorder keeps the poll claims and status of devices the collector
+// polls, under {prefix}:{id}:poll and {prefix}:{id}:status
+type pollRecorder struct {
+	db     *database.RedisClient
+	prefix string
+}
+
+func (r pollRecorder) statusKey(deviceID int) string {
+	return fmt.Sprintf("%s:%d:status", r.prefix, deviceID)
+}
+
+// GetStatus returns the poll counters for a device
+func (r pollRecorder) GetStatus(deviceID int) (*models.PollStatus, error) {
+	fields, err := r.db.GetClient().HGetAll(r.db.GetContext(), r.statusKey(deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll status: %w", err)
+	}
+
+	status := &models.PollStatus{LastError: fields["last_error"]}
+	status.ConsecutiveFailures, _ = strconv.Atoi(fields["consecutive_failures"])
+	status.TotalPolls, _ = strconv.Atoi(fields["total_polls"])
+	status.TotalFailures, _ = strconv.Atoi(fields["total_failures"])
+	if t, err := time.Parse(time.RFC3339Nano, fields["last_poll_at"]); err == nil {
+		status.LastPollAt = &t
+	}
+	if t, err := time.Parse(time.RFC3339Nano, fields["last_success_at"]); err == nil {
+		status.LastSuccessAt = &t
+	}
+	if id, err := strconv.Atoi(fields["alert_id"]); err == nil {
+		status.AlertID = &id
+	}
+	return status, nil
+}
+
+// ClaimPoll reserves the next poll of a device for this replica, so devices
+// are polled once per interval however many replicas run the collector
+func (r pollRecorder) ClaimPoll(deviceID int, owner string, interval time.Duration) (bool, error) {
+	ok, err := r.db.GetClient().SetNX(r.db.GetContext(), fmt.Sprintf("%s:%d:poll", r.prefix, deviceID), owner, interval).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim poll: %w", err)
+	}
+	return ok, nil
+}
+
+// RecordSuccess resets the failure count after a good poll
+func (r pollRecorder) RecordSuccess(deviceID int, at time.Time) (*models.PollStatus, error) {
+	key := r.statusKey(deviceID)
+	_, err := r.db.GetClient().TxPipelined(r.db.GetContext(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(r.db.GetContext(), key,
+			"last_poll_at", at.Format(time.RFC3339Nano),
+			"last_success_at", at.Format(time.RFC3339Nano),
+			"consecutive_failures", 0,
+		)
+		pipe.HDel(r.db.GetContext(), key, "last_error")
+		pipe.HIncrBy(r.db.GetContext(), key, "total_polls", 1)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record poll: %w", err)
+	}
+	return r.GetStatus(deviceID)
+}
+
+// RecordFailure counts a failed poll
+func (r pollRecorder) RecordFailure(deviceID int, at time.Time, pollErr error) (*models.PollStatus, error) {
+	key := r.statusKey(deviceID)
+	_, err := r.db.GetClient().TxPipelined(r.db.GetContext(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(r.db.GetContext(), key,
+			"last_poll_at", at.Format(time.RFC3339Nano),
+			"last_error", pollErr.Error(),
+		)
+		pipe.HIncrBy(r.db.GetContext(), key, "consecutive_failures", 1)
+		pipe.HIncrBy(r.db.GetContext(), key, "total_polls", 1)
+		pipe.HIncrBy(r.db.GetContext(), key, "total_failures", 1)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record poll: %w", err)
+	}
+	return r.GetStatus(deviceID)
+}
+
+// SetAlert records the open poll-failure alert for a device, or clears it
+func (r pollRecorder) SetAlert(deviceID int, alertID *int) error {
+	var err error
+	if alertID == nil {
+		err = r.db.GetClient().HDel(r.db.GetContext(), r.statusKey(deviceID), "alert_id").Err()
+	} else {
+		err = r.db.GetClient().HSet(r.db.GetContext(), r.statusKey(deviceID), "alert_id", *alertID).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update poll alert: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/models"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Profile enforcement modes
+const (
+	EnforcementReject = "reject"
+	EnforcementFlag   = "flag"
+)
+
+type ProfileService struct {
+	db *database.RedisClient
+}
+
+func NewProfileService(db *database.RedisClient) *ProfileService {
+	return &ProfileService{db: db}
+}
+
+func (s *ProfileService) GetAllProfiles() ([]models.DeviceProfile, error) {
+	types, err := s.db.GetClient().SMembers(s.db.GetContext(), "profiles:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile types: %w", err)
+	}
+	sort.Strings(types)
+
+	profiles := []models.DeviceProfile{}
+	for _, deviceType := range types {
+		profile, err := s.GetProfile(deviceType)
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, *profile)
+	}
+	return profiles, nil
+}
+
+func (s *ProfileService) GetProfile(deviceType string) (*models.DeviceProfile, error) {
+	profileData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("profiles:%s", deviceType), "data").Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("profile not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	var profile models.DeviceProfile
+	if err := json.Unmarshal([]byte(profileData), &profile); err != nil {
+		return nil, fmt.Errorf("failed to parse profile data: %w", err)
+	}
+	return &profile, nil
+}
+
+// SaveProfile creates or replaces the profile for a device type
+func (s *ProfileService) SaveProfile(deviceType string, insertProfile *models.InsertDeviceProfile) (*models.DeviceProfile, error) {
+	if err := validateProfile(insertProfile); err != nil {
+		return nil, err
+	}
+
+	profile := &models.DeviceProfile{
+		Type:                deviceType,
+		Description:         insertProfile.Description,
+		Metrics:             insertProfile.Metrics,
+		ReportingInterval:   insertProfile.ReportingInterval,
+		AllowUnknownMetrics: insertProfile.AllowUnknownMetrics,
+		Enforcement:         insertProfile.Enforcement,
+		UpdatedAt:           time.Now(),
+	}
+	if profile.Metrics == nil {
+		profile.Metrics = []models.MetricSpec{}
+	}
+	if profile.Enforcement == "" {
+		profile.Enforcement = EnforcementReject
+	}
+
+	profileJSON, err := json.Marshal(profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal profile: %w", err)
+	}
+	if err := s.db.GetClient().HSet(s.db.GetContext(), fmt.Sprintf("profiles:%s", deviceType), "data", profileJSON).Err(); err != nil {
+		return nil, fmt.Errorf("failed to store profile: %w", err)
+	}
+	if err := s.db.GetClient().SAdd(s.db.GetContext(), "profiles:all", deviceType).Err(); err != nil {
+		return nil, fmt.Errorf("failed to add profile to set: %w", err)
+	}
+	return profile, nil
+}
+
+func (s *ProfileService) DeleteProfile(deviceType string) error {
+	if err := s.db.GetClient().Del(s.db.GetContext(), fmt.Sprintf("profiles:%s", deviceType)).Err(); err != nil {
+		return fmt.Errorf("failed to delete profile: %w", err)
+	}
+	if err := s.db.GetClient().SRem(s.db.GetContext(), "profiles:all", deviceType).Err(); err != nil {
+		return fmt.Errorf("failed to remove profile from set: %w", err)
+	}
+	return nil
+}
+
+func validateProfile(profile *models.InsertDeviceProfile) error {
+	seen := map[string]bool{}
+	for _, spec := range profile.Metrics {
+		if !metricNamePattern.MatchString(spec.Name) {
+			return &ValidationError{fmt.Sprintf("invalid metric name %q", spec.Name)}
+		}
+		if seen[spec.Name] {
+			return &ValidationError{fmt.Sprintf("metric %q is declared more than once", spec.Name)}
+		}
+		seen[spec.Name] = true
+		if spec.Min != nil && spec.Max != nil && *spec.Min > *spec.Max {
+			return &ValidationError{fmt.Sprintf("metric %q has min greater than max", spec.Name)}
+		}
+		if builtin, ok := builtinMetrics[spec.Name]; ok && spec.Unit != "" && spec.Unit != builtin.unit {
+			return &ValidationError{fmt.Sprintf("metric %q is always reported in %s", spec.Name, builtin.unit)}
+		}
+	}
+	return nil
+}
+
+// checkReading compares a reading against a profile and returns one issue
+// per mismatch. Named metrics sent without a unit take the declared one.
+func checkReading(profile *models.DeviceProfile, t *models.Telemetry) []string {
+	var issues []string
+	declared := make(map[string]bool, len(profile.Metrics))
+	for _, spec := range profile.Metrics {
+		declared[spec.Name] = true
+
+		var value float64
+		if builtin, ok := builtinMetrics[spec.Name]; ok {
+			value = builtin.value(t)
+		} else {
+			metric, ok := t.Metrics[spec.Name]
+			if !ok {
+				if spec.Required {
+					issues = append(issues, fmt.Sprintf("missing required metric %q", spec.Name))
+				}
+				continue
+			}
+			if metric.Unit == "" && spec.Unit != "" {
+				metric.Unit = spec.Unit
+				t.Metrics[spec.Name] = metric
+			} else if spec.Unit != "" && metric.Unit != spec.Unit {
+				issues = append(issues, fmt.Sprintf("metric %q unit %q, expected %q", spec.Name, metric.Unit, spec.Unit))
+			}
+			value = metric.Value
+		}
+
+		if spec.Min != nil && value < *spec.Min {
+			issues = append(issues, fmt.Sprintf("metric %q value %g below minimum %g", spec.Name, value, *spec.Min))
+		}
+		if spec.Max != nil && value > *spec.Max {
+			issues = append(issues, fmt.Sprintf("metric %q value %g above maximum %g", spec.Name, value, *spec.Max))
+		}
+	}
+
+	if !profile.AllowUnknownMetrics {
+		var unknown []string
+		for name := range t.Metrics {
+			if !declared[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		sort.Strings(unknown)
+		for _, name := range unknown {
+			issues = append(issues, fmt.Sprintf("metric %q is not declared for %s devices", name, profile.Type))
+		}
+	}
+	return issues
+}
+
+// checkInterval compares the time since a device's previous reading with
+// the profile's reporting interval. Under half the interval is too soon and
+// over twice the interval means readings went missing.
+func checkInterval(profile *models.DeviceProfile, gap time.Duration) string {
+	interval := time.Duration(profile.ReportingInterval) * time.Second
+	switch {
+	case gap < interval/2:
+		return fmt.Sprintf("reading came %s after the previous one, sooner than the %s reporting interval", gap.Round(time.Second), interval)
+	case gap > 2*interval:
+		return fmt.Sprintf("reading came %s after the previous one, later than the %s reporting interval", gap.Round(time.Second), interval)
+	}
+	return ""
+}
+
+// schemaError joins the issues found by checkReading into one rejection
+func schemaError(deviceType string, issues []string) error {
+	return &ValidationError{fmt.Sprintf("reading does not match %s profile: %s", deviceType, strings.Join(issues, "; "))}
+}
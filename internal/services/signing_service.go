@@ -0,0 +1,266 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Nonces must be long enough to be unique per device and short enough to
+// key on
+const (
+	minNonceLength = 8
+	maxNonceLength = 128
+)
+
+var (
+	// ErrInvalidSignature is returned for a payload whose signature doesn't
+	// verify, or that names no usable timestamp or nonce
+	ErrInvalidSignature = errors.New("invalid payload signature")
+	// ErrReplayedPayload is returned for a signed payload whose nonce was
+	// already used, or whose timestamp is too far from now to tell
+	ErrReplayedPayload = errors.New("signed payload is stale or was already received")
+	// ErrSignatureRequired is returned for an unsigned payload from, or
+	// naming, a device whose key requires signing
+	ErrSignatureRequired = errors.New("device must sign its payloads")
+)
+
+// SigningService keeps the keys devices sign their telemetry with and
+// verifies signatures. A device signs "<timestamp>\n<nonce>\n<body>", where
+// the timestamp is in Unix seconds and the nonce is never reused. Payloads
+// with timestamps more than maxSkew from now are refused, and nonces are
+// remembered for as long as their payload could still be accepted.
+type SigningService struct {
+	db      *database.RedisClient
+	maxSkew time.Duration
+}
+
+func NewSigningService(db *database.RedisClient, maxSkew time.Duration) *SigningService {
+	return &SigningService{db: db, maxSkew: maxSkew}
+}
+
+func signingKey(deviceID int) string {
+	return fmt.Sprintf("devices:%d:signing", deviceID)
+}
+
+// SetSigningKey replaces the device's signing key. For HMAC-SHA256 a secret
+// is generated and returned, only here; for Ed25519 the device's public key
+// is registered.
+func (s *SigningService) SetSigningKey(deviceID int, request *models.SigningKeyRequest) (*models.SigningKey, error) {
+	ctx := s.db.GetContext()
+	exists, err := s.db.GetClient().Exists(ctx, fmt.Sprintf("devices:%d", deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check device: %w", err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("device not found")
+	}
+
+	key := &models.SigningKey{
+		DeviceID:  deviceID,
+		Algorithm: request.Algorithm,
+		Required:  request.Required,
+		CreatedAt: time.Now(),
+	}
+	var secret string
+	switch request.Algorithm {
+	case models.SigningHMACSHA256:
+		if secret, err = generateSecret(); err != nil {
+			return nil, err
+		}
+	case models.SigningEd25519:
+		publicKey, err := parseEd25519PublicKey(request.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+	default:
+		return nil, &ValidationError{fmt.Sprintf("unsupported algorithm %q", request.Algorithm)}
+	}
+
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signing key: %w", err)
+	}
+	_, err = s.db.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, signingKey(deviceID))
+		pipe.HSet(ctx, signingKey(deviceID), "data", keyJSON)
+		if secret != "" {
+			pipe.HSet(ctx, signingKey(deviceID), "secret", secret)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store signing key: %w", err)
+	}
+	key.Secret = secret
+	return key, nil
+}
+
+// parseEd25519PublicKey accepts a raw key in base64 or a PEM public key
+func parseEd25519PublicKey(encoded string) (ed25519.PublicKey, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, &ValidationError{"publicKey is required for ed25519"}
+	}
+	if block, _ := pem.Decode([]byte(encoded)); block != nil {
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, &ValidationError{fmt.Sprintf("invalid publicKey: %v", err)}
+		}
+		publicKey, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, &ValidationError{"publicKey is not an ed25519 key"}
+		}
+		return publicKey, nil
+	}
+	raw, err := decodeBase64(encoded)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, &ValidationError{fmt.Sprintf("publicKey must be %d bytes of base64 or a PEM public key", ed25519.PublicKeySize)}
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// decodeBase64 accepts standard and URL-safe base64, padded or not
+func decodeBase64(encoded string) ([]byte, error) {
+	encoded = strings.TrimRight(encoded, "=")
+	if strings.ContainsAny(encoded, "-_") {
+		return base64.RawURLEncoding.DecodeString(encoded)
+	}
+	return base64.RawStdEncoding.DecodeString(encoded)
+}
+
+// GetSigningKey returns the device's signing key without its secret, or nil
+// if the device doesn't sign its telemetry
+func (s *SigningService) GetSigningKey(deviceID int) (*models.SigningKey, error) {
+	data, err := s.db.GetClient().HGet(s.db.GetContext(), signingKey(deviceID), "data").Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing key: %w", err)
+	}
+	var key models.SigningKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal signing key: %w", err)
+	}
+	return &key, nil
+}
+
+func (s *SigningService) DeleteSigningKey(deviceID int) error {
+	deleted, err := s.db.GetClient().Del(s.db.GetContext(), signingKey(deviceID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete signing key: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("signing key not found")
+	}
+	return nil
+}
+
+// SignatureRequired reports whether the device's unsigned payloads are to be
+// refused
+func (s *SigningService) SignatureRequired(deviceID int) (bool, error) {
+	key, err := s.GetSigningKey(deviceID)
+	if err != nil {
+		return false, err
+	}
+	return key != nil && key.Required, nil
+}
+
+// AllowUnsigned returns ErrSignatureRequired if the device's unsigned payloads
+// are to be refused
+func (s *SigningService) AllowUnsigned(deviceID int) error {
+	required, err := s.SignatureRequired(deviceID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return fmt.Errorf("%w: device %d", ErrSignatureRequired, deviceID)
+	}
+	return nil
+}
+
+// VerifySignature checks a payload's signature against the device's key and
+// records its nonce so the payload can't be replayed
+func (s *SigningService) VerifySignature(deviceID int, timestamp, nonce, signature string, body []byte) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: timestamp must be Unix seconds", ErrInvalidSignature)
+	}
+	if len(nonce) < minNonceLength || len(nonce) > maxNonceLength || strings.ContainsAny(nonce, " \t\r\n") {
+		return fmt.Errorf("%w: nonce must be %d to %d characters without whitespace", ErrInvalidSignature, minNonceLength, maxNonceLength)
+	}
+	signed, err := decodeBase64(signature)
+	if err != nil {
+		return fmt.Errorf("%w: signature must be base64", ErrInvalidSignature)
+	}
+	skew := time.Since(time.Unix(unix, 0))
+	if skew > s.maxSkew || skew < -s.maxSkew {
+		return ErrReplayedPayload
+	}
+
+	ctx := s.db.GetContext()
+	stored, err := s.db.GetClient().HMGet(ctx, signingKey(deviceID), "data", "secret").Result()
+	if err != nil {
+		return fmt.Errorf("failed to get signing key: %w", err)
+	}
+	data, ok := stored[0].(string)
+	if !ok {
+		return fmt.Errorf("%w: device %d has no signing key", ErrInvalidSignature, deviceID)
+	}
+	var key models.SigningKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return fmt.Errorf("failed to unmarshal signing key: %w", err)
+	}
+
+	message := make([]byte, 0, len(timestamp)+len(nonce)+2+len(body))
+	message = append(message, timestamp...)
+	message = append(message, '\n')
+	message = append(message, nonce...)
+	message = append(message, '\n')
+	message = append(message, body...)
+
+	switch key.Algorithm {
+	case models.SigningHMACSHA256:
+		secret, _ := stored[1].(string)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(message)
+		if secret == "" || !hmac.Equal(mac.Sum(nil), signed) {
+			return ErrInvalidSignature
+		}
+	case models.SigningEd25519:
+		publicKey, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid stored public key for device %d", deviceID)
+		}
+		if !ed25519.Verify(publicKey, message, signed) {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q for device %d", key.Algorithm, deviceID)
+	}
+
+	// A nonce only needs remembering while its timestamp is acceptable
+	fresh, err := s.db.GetClient().SetNX(ctx, fmt.Sprintf("devices:%d:nonce:%s", deviceID, nonce), timestamp, 2*s.maxSkew).Result()
+	if err != nil {
+		return fmt.Errorf("failed to record nonce: %w", err)
+	}
+	if !fresh {
+		return ErrReplayedPayload
+	}
+	return nil
+}
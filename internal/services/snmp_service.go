@@ -0,0 +1,221 @@
+package services
+
+import (
+	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/models"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultSNMPPort is used when a config's host has no port
+const defaultSNMPPort = "161"
+
+// minPassphraseLength is the shortest SNMPv3 passphrase agents accept
+// (RFC 3414 section 11.2)
+const minPassphraseLength = 8
+
+var oidPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)+$`)
+
+// SNMPService stores the SNMP polling and trap configuration of devices and
+// the outcome of their polls
+type SNMPService struct {
+	db *database.RedisClient
+	pollRecorder
+}
+
+func NewSNMPService(db *database.RedisClient) *SNMPService {
+	return &SNMPService{db: db, pollRecorder: pollRecorder{db: db, prefix: "snmp"}}
+}
+
+// GetAllConfigs returns every device's SNMP config, with its poll status
+func (s *SNMPService) GetAllConfigs() ([]models.SNMPConfig, error) {
+	deviceIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), "snmp:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SNMP device IDs: %w", err)
+	}
+
+	configs := []models.SNMPConfig{}
+	for _, idStr := range deviceIDs {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		config, err := s.GetConfig(id)
+		if err != nil {
+			continue
+		}
+		configs = append(configs, *config)
+	}
+	return configs, nil
+}
+
+// GetConfig returns a device's SNMP config including its community and
+// passphrases
+func (s *SNMPService) GetConfig(deviceID int) (*models.SNMPConfig, error) {
+	configData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("snmp:%d", deviceID), "data").Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("snmp config not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SNMP config: %w", err)
+	}
+
+	var config models.SNMPConfig
+	if err := json.Unmarshal([]byte(configData), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse SNMP config: %w", err)
+	}
+	status, err := s.GetStatus(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	config.Status = status
+	return &config, nil
+}
+
+// SaveConfig creates or replaces a device's SNMP config. Polls start on the
+// collector's next refresh.
+func (s *SNMPService) SaveConfig(deviceID int, insertConfig *models.InsertSNMPConfig) (*models.SNMPConfig, error) {
+	exists, err := s.db.GetClient().Exists(s.db.GetContext(), fmt.Sprintf("devices:%d", deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check device: %w", err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("device not found")
+	}
+
+	host := insertConfig.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, defaultSNMPPort)
+	}
+	config := &models.SNMPConfig{
+		DeviceID:        deviceID,
+		Host:            host,
+		Version:         insertConfig.Version,
+		IntervalSeconds: insertConfig.IntervalSeconds,
+		Enabled:         insertConfig.Enabled == nil || *insertConfig.Enabled,
+		OIDs:            insertConfig.OIDs,
+		Traps:           insertConfig.Traps,
+		UpdatedAt:       time.Now(),
+	}
+	switch config.Version {
+	case "2c":
+		if insertConfig.Community == "" {
+			return nil, &ValidationError{"community is required for SNMP v2c"}
+		}
+		config.Community = insertConfig.Community
+	case "3":
+		if insertConfig.User == nil {
+			return nil, &ValidationError{"user is required for SNMP v3"}
+		}
+		user := *insertConfig.User
+		if err := normalizeSNMPUser(&user); err != nil {
+			return nil, err
+		}
+		config.User = &user
+	}
+	if err := normalizeSNMPMappings(config); err != nil {
+		return nil, err
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal SNMP config: %w", err)
+	}
+	if err := s.db.GetClient().HSet(s.db.GetContext(), fmt.Sprintf("snmp:%d", deviceID), "data", configJSON).Err(); err != nil {
+		return nil, fmt.Errorf("failed to store SNMP config: %w", err)
+	}
+	if err := s.db.GetClient().SAdd(s.db.GetContext(), "snmp:all", deviceID).Err(); err != nil {
+		return nil, fmt.Errorf("failed to add SNMP config to set: %w", err)
+	}
+
+	config.Status, err = s.GetStatus(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (s *SNMPService) DeleteConfig(deviceID int) error {
+	err := s.db.GetClient().Del(s.db.GetContext(), fmt.Sprintf("snmp:%d", deviceID), fmt.Sprintf("snmp:%d:status", deviceID)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete SNMP config: %w", err)
+	}
+	if err := s.db.GetClient().SRem(s.db.GetContext(), "snmp:all", deviceID).Err(); err != nil {
+		return fmt.Errorf("failed to remove SNMP config from set: %w", err)
+	}
+	return nil
+}
+
+// normalizeSNMPUser derives the security level from the passphrases given
+// when it isn't set, defaults the protocols and checks the passphrases the
+// level needs are present
+func normalizeSNMPUser(user *models.SNMPUser) error {
+	if user.SecurityLevel == "" {
+		switch {
+		case user.PrivPassphrase != "":
+			user.SecurityLevel = "authPriv"
+		case user.AuthPassphrase != "":
+			user.SecurityLevel = "authNoPriv"
+		default:
+			user.SecurityLevel = "noAuthNoPriv"
+		}
+	}
+
+	if user.SecurityLevel == "noAuthNoPriv" {
+		user.AuthProtocol, user.AuthPassphrase = "", ""
+	} else {
+		if len(user.AuthPassphrase) < minPassphraseLength {
+			return &ValidationError{fmt.Sprintf("authPassphrase must be at least %d characters for %s", minPassphraseLength, user.SecurityLevel)}
+		}
+		if user.AuthProtocol == "" {
+			user.AuthProtocol = "SHA"
+		}
+	}
+
+	if user.SecurityLevel != "authPriv" {
+		user.PrivProtocol, user.PrivPassphrase = "", ""
+	} else {
+		if len(user.PrivPassphrase) < minPassphraseLength {
+			return &ValidationError{fmt.Sprintf("privPassphrase must be at least %d characters for authPriv", minPassphraseLength)}
+		}
+		if user.PrivProtocol == "" {
+			user.PrivProtocol = "AES"
+		}
+	}
+	return nil
+}
+
+// normalizeSNMPMappings strips leading dots from OIDs and checks the OIDs,
+// metric names and trap rules are usable
+func normalizeSNMPMappings(config *models.SNMPConfig) error {
+	seen := map[string]bool{}
+	for i := range config.OIDs {
+		m := &config.OIDs[i]
+		m.OID = strings.TrimPrefix(m.OID, ".")
+		if !oidPattern.MatchString(m.OID) {
+			return &ValidationError{fmt.Sprintf("invalid OID %q", m.OID)}
+		}
+		if !metricNamePattern.MatchString(m.Metric) {
+			return &ValidationError{fmt.Sprintf("invalid metric name %q", m.Metric)}
+		}
+		if seen[m.OID] {
+			return &ValidationError{fmt.Sprintf("OID %s is mapped more than once", m.OID)}
+		}
+		seen[m.OID] = true
+	}
+	for i := range config.Traps {
+		rule := &config.Traps[i]
+		rule.OID = strings.TrimPrefix(rule.OID, ".")
+		if !oidPattern.MatchString(rule.OID) {
+			return &ValidationError{fmt.Sprintf("invalid trap OID %q", rule.OID)}
+		}
+	}
+	return nil
+}
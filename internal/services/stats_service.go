@@ -5,6 +5,8 @@ import (
 	"edgefleet-commander/internal/models"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 )
 
 type StatsService struct {
@@ -92,3 +94,130 @@ func (s *StatsService) GetStats() (*models.Stats, error) {
 		AvgCPUUsage:   avgCPU,
 	}, nil
 }
+
+// GetAlertStats reports alert counts, response times and volume for alerts
+// created within the last window, grouped into buckets of the given size.
+func (s *StatsService) GetAlertStats(window, bucket time.Duration, top int) (*models.AlertStats, error) {
+	to := time.Now()
+	from := to.Add(-window)
+
+	alertIDs, err := s.db.GetClient().SMembers(s.db.GetContext(), "alerts:all").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alert IDs: %w", err)
+	}
+
+	devices := make(map[int]*models.Device)
+	lookupDevice := func(id int) *models.Device {
+		if device, ok := devices[id]; ok {
+			return device
+		}
+		var device *models.Device
+		deviceData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("devices:%d", id), "data").Result()
+		if err == nil {
+			var d models.Device
+			if err := json.Unmarshal([]byte(deviceData), &d); err == nil {
+				device = &d
+			}
+		}
+		devices[id] = device
+		return device
+	}
+
+	numBuckets := int(window / bucket)
+	if window%bucket != 0 {
+		numBuckets++
+	}
+	stats := &models.AlertStats{
+		From:            from,
+		To:              to,
+		BySeverity:      make(map[string]int),
+		ByType:          make(map[string]int),
+		ByDevice:        make(map[int]int),
+		ByLocation:      make(map[string]int),
+		TopNoisyDevices: []models.DeviceAlertCount{},
+		Volume:          make([]models.AlertVolumeBucket, numBuckets),
+	}
+	for i := range stats.Volume {
+		stats.Volume[i] = models.AlertVolumeBucket{
+			Start:      from.Add(time.Duration(i) * bucket),
+			BySeverity: make(map[string]int),
+		}
+	}
+
+	var ackTotal, resolveTotal time.Duration
+	ackCount, resolveCount := 0, 0
+	for _, idStr := range alertIDs {
+		alertData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("alerts:%s", idStr), "data").Result()
+		if err != nil {
+			continue
+		}
+		var alert models.Alert
+		if err := json.Unmarshal([]byte(alertData), &alert); err != nil {
+			continue
+		}
+		if alert.CreatedAt.Before(from) || alert.CreatedAt.After(to) {
+			continue
+		}
+
+		stats.TotalAlerts++
+		stats.BySeverity[alert.Severity]++
+		stats.ByType[alert.Type]++
+		stats.ByDevice[alert.DeviceID]++
+		location := "Unknown"
+		if device := lookupDevice(alert.DeviceID); device != nil {
+			location = device.Location
+		}
+		stats.ByLocation[location]++
+
+		switch {
+		case alert.Resolved:
+			stats.ResolvedAlerts++
+		case alert.Acknowledged:
+			stats.AcknowledgedAlerts++
+		default:
+			stats.OpenAlerts++
+		}
+		if alert.AcknowledgedAt != nil {
+			ackTotal += alert.AcknowledgedAt.Sub(alert.CreatedAt)
+			ackCount++
+		}
+		if alert.ResolvedAt != nil {
+			resolveTotal += alert.ResolvedAt.Sub(alert.CreatedAt)
+			resolveCount++
+		}
+
+		idx := int(alert.CreatedAt.Sub(from) / bucket)
+		if idx >= numBuckets {
+			idx = numBuckets - 1
+		}
+		stats.Volume[idx].Count++
+		stats.Volume[idx].BySeverity[alert.Severity]++
+	}
+
+	if ackCount > 0 {
+		stats.MTTASeconds = (ackTotal / time.Duration(ackCount)).Seconds()
+	}
+	if resolveCount > 0 {
+		stats.MTTRSeconds = (resolveTotal / time.Duration(resolveCount)).Seconds()
+	}
+
+	for deviceID, count := range stats.ByDevice {
+		entry := models.DeviceAlertCount{DeviceID: deviceID, Count: count}
+		if device := lookupDevice(deviceID); device != nil {
+			entry.DeviceName = device.Name
+			entry.Location = device.Location
+		}
+		stats.TopNoisyDevices = append(stats.TopNoisyDevices, entry)
+	}
+	sort.Slice(stats.TopNoisyDevices, func(i, j int) bool {
+		if stats.TopNoisyDevices[i].Count != stats.TopNoisyDevices[j].Count {
+			return stats.TopNoisyDevices[i].Count > stats.TopNoisyDevices[j].Count
+		}
+		return stats.TopNoisyDevices[i].DeviceID < stats.TopNoisyDevices[j].DeviceID
+	})
+	if top > 0 && len(stats.TopNoisyDevices) > top {
+		stats.TopNoisyDevices = stats.TopNoisyDevices[:top]
+	}
+
+	return stats, nil
+}
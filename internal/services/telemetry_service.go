@@ -1,19 +1,59 @@
 package services
 
 import (
+	"context"
 	"edgefleet-commander/internal/database"
+	"edgefleet-commander/internal/events"
 	"edgefleet-commander/internal/models"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/go-redis/redis/v8"
 )
 
+// TelemetryOptions bounds the device-supplied timestamps accepted at ingest
+type TelemetryOptions struct {
+	// MaxFutureSkew is how far ahead of the server clock a reading may be
+	MaxFutureSkew time.Duration
+	// MaxAge is how old a buffered reading may be when it arrives
+	MaxAge time.Duration
+	// DedupeWindow is how long message IDs are remembered; 0 turns
+	// deduplication off
+	DedupeWindow time.Duration
+}
+
 type TelemetryService struct {
-	db *database.RedisClient
+	db       *database.RedisClient
+	events   *events.Bus
+	profiles *ProfileService
+	options  TelemetryOptions
+	messageIDs
+}
+
+func NewTelemetryService(db *database.RedisClient, bus *events.Bus, profiles *ProfileService, options TelemetryOptions) *TelemetryService {
+	return &TelemetryService{
+		db:         db,
+		events:     bus,
+		profiles:   profiles,
+		options:    options,
+		messageIDs: messageIDs{db: db, prefix: "telemetry", window: options.DedupeWindow},
+	}
+}
+
+// ValidationError reports a reading rejected for its content, as opposed to
+// a storage failure
+type ValidationError struct {
+	Reason string
 }
 
-func NewTelemetryService(db *database.RedisClient) *TelemetryService {
-	return &TelemetryService{db: db}
+func (e *ValidationError) Error() string {
+	return e.Reason
 }
 
 func (s *TelemetryService) GetAllTelemetry(limit int) ([]models.Telemetry, error) {
@@ -42,7 +82,7 @@ func (s *TelemetryService) GetAllTelemetry(limit int) ([]models.Telemetry, error
 }
 
 func (s *TelemetryService) GetTelemetryByDevice(deviceID int, limit int) ([]models.Telemetry, error) {
-	telemetryIDs, err := s.db.GetClient().LRange(s.db.GetContext(), fmt.Sprintf("device:%d:telemetry", deviceID), 0, int64(limit-1)).Result()
+	telemetryIDs, err := s.db.GetClient().ZRevRange(s.db.GetContext(), fmt.Sprintf("device:%d:telemetry", deviceID), 0, int64(limit-1)).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get telemetry IDs: %w", err)
 	}
@@ -62,7 +102,7 @@ func (s *TelemetryService) GetTelemetryByDevice(deviceID int, limit int) ([]mode
 }
 
 func (s *TelemetryService) GetLatestTelemetry(deviceID int) (*models.Telemetry, error) {
-	telemetryIDs, err := s.db.GetClient().LRange(s.db.GetContext(), fmt.Sprintf("device:%d:telemetry", deviceID), 0, 0).Result()
+	telemetryIDs, err := s.db.GetClient().ZRevRange(s.db.GetContext(), fmt.Sprintf("device:%d:telemetry", deviceID), 0, 0).Result()
 	if err != nil || len(telemetryIDs) == 0 {
 		return nil, nil
 	}
@@ -77,36 +117,376 @@ func (s *TelemetryService) GetLatestTelemetry(deviceID int) (*models.Telemetry,
 	return &t, nil
 }
 
+// CreateTelemetry stores a reading. The device's own timestamp is kept when
+// it is within the configured skew and age bounds; readings without one are
+// stamped with the time they were received. A reading repeating the
+// messageId of one stored within the dedupe window isn't stored again;
+// telemetry is filled in with the original instead.
 func (s *TelemetryService) CreateTelemetry(telemetry *models.Telemetry) error {
-	nextID, err := s.db.GetClient().Incr(s.db.GetContext(), "telemetry:next_id").Result()
+	_, err := s.CreateTelemetryDeduped(telemetry)
+	return err
+}
+
+// CreateTelemetryDeduped is CreateTelemetry, also reporting whether the
+// reading was a repeat of an earlier message ID
+func (s *TelemetryService) CreateTelemetryDeduped(telemetry *models.Telemetry) (bool, error) {
+	batch := []models.Telemetry{*telemetry}
+	itemErrs, replayed, err := s.CreateTelemetryBatchDeduped(batch)
 	if err != nil {
-		return fmt.Errorf("failed to generate telemetry ID: %w", err)
+		return false, err
+	}
+	if itemErrs[0] != nil {
+		return false, itemErrs[0]
+	}
+	*telemetry = batch[0]
+	return replayed[0], nil
+}
+
+// CreateTelemetryBatch validates and stores many readings in one pipelined
+// round trip. The returned slice holds the validation error for each reading,
+// or nil if it was stored (with its ID filled in) or repeated a stored
+// message ID (replaced by the original). The error is set only if the store
+// couldn't be read or written, in which case nothing should be assumed
+// stored.
+func (s *TelemetryService) CreateTelemetryBatch(batch []models.Telemetry) ([]error, error) {
+	itemErrs, _, err := s.CreateTelemetryBatchDeduped(batch)
+	return itemErrs, err
+}
+
+// CreateTelemetryBatchDeduped is CreateTelemetryBatch, also reporting which
+// readings were repeats of an earlier message ID
+func (s *TelemetryService) CreateTelemetryBatchDeduped(batch []models.Telemetry) ([]error, []bool, error) {
+	return s.createTelemetryBatch(batch, false)
+}
+
+// ReplayTelemetryBatch is CreateTelemetryBatchDeduped for readings that were
+// spooled while the store was down. Their ReceivedAt is kept, and their age
+// is judged from when they were received rather than from now.
+func (s *TelemetryService) ReplayTelemetryBatch(batch []models.Telemetry) ([]error, []bool, error) {
+	return s.createTelemetryBatch(batch, true)
+}
+
+func (s *TelemetryService) createTelemetryBatch(batch []models.Telemetry, spooled bool) ([]error, []bool, error) {
+	itemErrs := make([]error, len(batch))
+	replayed := make([]bool, len(batch))
+	valid := 0
+	now := time.Now()
+	lookup := newIngestLookup()
+	for i := range batch {
+		itemErrs[i] = validateTelemetry(&batch[i])
+		if itemErrs[i] == nil {
+			itemErrs[i] = validateMessageID(batch[i].MessageID)
+		}
+		if itemErrs[i] == nil {
+			itemErrs[i] = validateMetrics(batch[i].Metrics)
+		}
+		if itemErrs[i] == nil {
+			itemErrs[i] = s.checkSchema(&batch[i], lookup)
+			// A lookup that failed says nothing about the reading
+			var validationErr *ValidationError
+			if itemErrs[i] != nil && !errors.As(itemErrs[i], &validationErr) {
+				return nil, nil, itemErrs[i]
+			}
+		}
+		if itemErrs[i] == nil {
+			received := now
+			if spooled && !batch[i].ReceivedAt.IsZero() {
+				received = batch[i].ReceivedAt
+			}
+			itemErrs[i] = s.applyTimestamp(&batch[i], received)
+		}
+		if itemErrs[i] == nil {
+			if err := s.checkReportingInterval(&batch[i], lookup); err != nil {
+				return nil, nil, err
+			}
+		}
+		if itemErrs[i] == nil {
+			valid++
+		}
+	}
+	if valid == 0 {
+		return itemErrs, replayed, nil
 	}
-	telemetry.ID = int(nextID)
-	telemetry.Timestamp = time.Now()
-	telemetryJSON, err := json.Marshal(telemetry)
+
+	ctx := s.db.GetContext()
+	lastID, err := s.db.GetClient().IncrBy(ctx, "telemetry:next_id", int64(valid)).Result()
 	if err != nil {
-		return fmt.Errorf("failed to marshal telemetry: %w", err)
+		return nil, nil, fmt.Errorf("failed to generate telemetry IDs: %w", err)
 	}
-	if err := s.db.GetClient().HSet(s.db.GetContext(), fmt.Sprintf("telemetry:%d", telemetry.ID), "data", telemetryJSON).Err(); err != nil {
-		return fmt.Errorf("failed to store telemetry: %w", err)
+	nextID := int(lastID) - valid + 1
+	for i := range batch {
+		if itemErrs[i] == nil {
+			batch[i].ID = nextID
+			nextID++
+		}
 	}
-	if err := s.db.GetClient().LPush(s.db.GetContext(), fmt.Sprintf("device:%d:telemetry", telemetry.DeviceID), telemetry.ID).Err(); err != nil {
-		return fmt.Errorf("failed to add telemetry to device list: %w", err)
+
+	// Readings repeating a message ID earlier in the same batch take the
+	// outcome of the first; the rest are claimed against the store
+	var claims []messageClaim
+	var claimed []int
+	sameAs := map[int]int{}
+	if s.messageIDs.enabled() {
+		first := map[string]int{}
+		for i := range batch {
+			if itemErrs[i] != nil || batch[i].MessageID == "" {
+				continue
+			}
+			key := s.messageIDs.key(batch[i].DeviceID, batch[i].MessageID)
+			if j, ok := first[key]; ok {
+				sameAs[i] = j
+				continue
+			}
+			first[key] = i
+			claims = append(claims, messageClaim{batch[i].DeviceID, batch[i].MessageID, batch[i].ID})
+			claimed = append(claimed, i)
+		}
 	}
-	if err := s.db.GetClient().SAdd(s.db.GetContext(), "telemetry:all", telemetry.ID).Err(); err != nil {
-		return fmt.Errorf("failed to add telemetry to set: %w", err)
+	originals, err := s.messageIDs.claim(ctx, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	var newClaims []messageClaim
+	originalIDs := map[int]int{}
+	for c, i := range claimed {
+		if originals[c] == 0 {
+			newClaims = append(newClaims, claims[c])
+		} else {
+			originalIDs[i] = originals[c]
+		}
+	}
+	if err := s.loadOriginals(batch, originalIDs, itemErrs, replayed); err != nil {
+		s.messageIDs.release(ctx, newClaims)
+		return nil, nil, err
+	}
+
+	_, err = s.db.GetClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range batch {
+			if _, repeat := sameAs[i]; itemErrs[i] != nil || replayed[i] || repeat {
+				continue
+			}
+			t := &batch[i]
+			telemetryJSON, err := json.Marshal(t)
+			if err != nil {
+				return fmt.Errorf("failed to marshal telemetry: %w", err)
+			}
+			pipe.HSet(ctx, fmt.Sprintf("telemetry:%d", t.ID), "data", telemetryJSON)
+			pipe.ZAdd(ctx, fmt.Sprintf("device:%d:telemetry", t.DeviceID), telemetryIndexEntry(t))
+			pipe.SAdd(ctx, "telemetry:all", t.ID)
+			indexMetrics(ctx, pipe, t)
+		}
+		return nil
+	})
+	if err != nil {
+		s.messageIDs.release(ctx, newClaims)
+		return nil, nil, fmt.Errorf("failed to store telemetry batch: %w", err)
+	}
+
+	for i := range batch {
+		if _, repeat := sameAs[i]; itemErrs[i] == nil && !replayed[i] && !repeat {
+			s.events.Publish(events.TelemetryCreated, batch[i].DeviceID, batch[i])
+		}
+	}
+	for i, j := range sameAs {
+		if itemErrs[i] = itemErrs[j]; itemErrs[i] == nil {
+			batch[i] = batch[j]
+			replayed[i] = true
+		}
+	}
+	return itemErrs, replayed, nil
+}
+
+// loadOriginals replaces readings that repeated a stored message ID with the
+// readings first stored under it. If the original isn't there yet, its
+// request is still in flight.
+func (s *TelemetryService) loadOriginals(batch []models.Telemetry, originalIDs map[int]int, itemErrs []error, replayed []bool) error {
+	if len(originalIDs) == 0 {
+		return nil
+	}
+	ctx := s.db.GetContext()
+	cmds := map[int]*redis.StringCmd{}
+	_, err := s.db.GetClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range originalIDs {
+			cmds[i] = pipe.HGet(ctx, fmt.Sprintf("telemetry:%d", id), "data")
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to get original telemetry: %w", err)
+	}
+	for i, cmd := range cmds {
+		var original models.Telemetry
+		if cmd.Err() != nil || json.Unmarshal([]byte(cmd.Val()), &original) != nil {
+			itemErrs[i] = ErrMessageInFlight
+			continue
+		}
+		batch[i] = original
+		replayed[i] = true
 	}
 	return nil
 }
 
+// validateTelemetry applies the same bounds as models.InsertTelemetry
+func validateTelemetry(t *models.Telemetry) error {
+	switch {
+	case t.DeviceID <= 0:
+		return &ValidationError{"deviceId is required"}
+	case t.BatteryLevel < 0 || t.BatteryLevel > 100:
+		return &ValidationError{"batteryLevel must be between 0 and 100"}
+	case t.CPUUsage < 0 || t.CPUUsage > 100:
+		return &ValidationError{"cpuUsage must be between 0 and 100"}
+	case t.MemoryUsage < 0:
+		return &ValidationError{"memoryUsage must not be negative"}
+	case t.MemoryTotal < 0:
+		return &ValidationError{"memoryTotal must not be negative"}
+	}
+	return nil
+}
+
+// ingestLookup caches the devices and profiles consulted while admitting
+// readings, so a batch looks each up once
+type ingestLookup struct {
+	devices  map[int]*models.Device
+	profiles map[string]*models.DeviceProfile
+	// latest is the newest reading time seen per device, stored or earlier
+	// in the batch
+	latest map[int]time.Time
+}
+
+func newIngestLookup() *ingestLookup {
+	return &ingestLookup{
+		devices:  map[int]*models.Device{},
+		profiles: map[string]*models.DeviceProfile{},
+		latest:   map[int]time.Time{},
+	}
+}
+
+// checkSchema rejects readings from unknown or decommissioned devices and
+// checks the rest against their device type's profile, if one exists. In
+// flag mode mismatches are recorded on the reading instead of rejecting it.
+func (s *TelemetryService) checkSchema(t *models.Telemetry, lookup *ingestLookup) error {
+	device, ok := lookup.devices[t.DeviceID]
+	if !ok {
+		deviceData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("devices:%d", t.DeviceID), "data").Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("failed to get device: %w", err)
+		}
+		if err == nil {
+			device = &models.Device{}
+			if err := json.Unmarshal([]byte(deviceData), device); err != nil {
+				return fmt.Errorf("failed to parse device data: %w", err)
+			}
+		}
+		lookup.devices[t.DeviceID] = device
+	}
+	if device == nil {
+		return &ValidationError{fmt.Sprintf("unknown device %d", t.DeviceID)}
+	}
+	if device.Status == "decommissioned" {
+		return &ValidationError{fmt.Sprintf("device %d is decommissioned", t.DeviceID)}
+	}
+
+	profile, ok := lookup.profiles[device.Type]
+	if !ok {
+		var err error
+		profile, err = s.profiles.GetProfile(device.Type)
+		if err != nil {
+			if err.Error() != "profile not found" {
+				return err
+			}
+			profile = nil
+		}
+		lookup.profiles[device.Type] = profile
+	}
+	if profile == nil {
+		return nil
+	}
+
+	t.Flags = nil
+	issues := checkReading(profile, t)
+	if len(issues) == 0 {
+		return nil
+	}
+	if profile.Enforcement == EnforcementFlag {
+		t.Flags = issues
+		return nil
+	}
+	return schemaError(profile.Type, issues)
+}
+
+// checkReportingInterval flags a reading that came much sooner or later after
+// the device's previous one than its profile's reporting interval. Such
+// readings are always stored, whatever the profile's enforcement, since
+// refusing them would only widen a gap. Readings older than the device's
+// newest are backfill and aren't checked.
+func (s *TelemetryService) checkReportingInterval(t *models.Telemetry, lookup *ingestLookup) error {
+	device := lookup.devices[t.DeviceID]
+	if device == nil {
+		return nil
+	}
+	profile := lookup.profiles[device.Type]
+	if profile == nil || profile.ReportingInterval <= 0 {
+		return nil
+	}
+
+	previous, ok := lookup.latest[t.DeviceID]
+	if !ok {
+		latest, err := s.db.GetClient().ZRevRangeWithScores(s.db.GetContext(), fmt.Sprintf("device:%d:telemetry", t.DeviceID), 0, 0).Result()
+		if err != nil {
+			return fmt.Errorf("failed to get latest telemetry: %w", err)
+		}
+		if len(latest) > 0 {
+			previous = time.UnixMilli(int64(latest[0].Score))
+		}
+	}
+	if !t.Timestamp.After(previous) {
+		lookup.latest[t.DeviceID] = previous
+		return nil
+	}
+	lookup.latest[t.DeviceID] = t.Timestamp
+	if previous.IsZero() {
+		return nil
+	}
+	if issue := checkInterval(profile, t.Timestamp.Sub(previous)); issue != "" {
+		t.Flags = append(t.Flags, issue)
+	}
+	return nil
+}
+
+// applyTimestamp records when the reading was received and checks the
+// device-supplied timestamp against the configured bounds, defaulting it to
+// the receive time when the device sent none.
+func (s *TelemetryService) applyTimestamp(t *models.Telemetry, now time.Time) error {
+	t.ReceivedAt = now
+	if t.Timestamp.IsZero() {
+		t.Timestamp = now
+		return nil
+	}
+	if s.options.MaxFutureSkew > 0 && t.Timestamp.After(now.Add(s.options.MaxFutureSkew)) {
+		return &ValidationError{fmt.Sprintf("timestamp is more than %s in the future", s.options.MaxFutureSkew)}
+	}
+	if s.options.MaxAge > 0 && t.Timestamp.Before(now.Add(-s.options.MaxAge)) {
+		return &ValidationError{fmt.Sprintf("timestamp is more than %s in the past", s.options.MaxAge)}
+	}
+	return nil
+}
+
+// telemetryIndexEntry places a reading in its device's time-ordered index,
+// so late or out-of-order uploads land where they belong
+func telemetryIndexEntry(t *models.Telemetry) *redis.Z {
+	return &redis.Z{Score: float64(t.Timestamp.UnixMilli()), Member: t.ID}
+}
+
 func (s *TelemetryService) GetTelemetryForPeriod(deviceID int, hours int) ([]models.Telemetry, error) {
-	telemetryIDs, err := s.db.GetClient().LRange(s.db.GetContext(), fmt.Sprintf("device:%d:telemetry", deviceID), 0, -1).Result()
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	telemetryIDs, err := s.db.GetClient().ZRevRangeByScore(s.db.GetContext(), fmt.Sprintf("device:%d:telemetry", deviceID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get telemetry IDs: %w", err)
 	}
 	var telemetry []models.Telemetry
-	since := time.Now().Add(-time.Duration(hours) * time.Hour)
 	for _, idStr := range telemetryIDs {
 		telemetryData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("telemetry:%s", idStr), "data").Result()
 		if err != nil {
@@ -122,3 +502,147 @@ func (s *TelemetryService) GetTelemetryForPeriod(deviceID int, hours int) ([]mod
 	}
 	return telemetry, nil
 }
+
+// Built-in metrics are the fixed Telemetry fields, exposed under their JSON
+// names so they can be queried like any named metric
+var builtinMetrics = map[string]struct {
+	unit  string
+	value func(t *models.Telemetry) float64
+	field func(t *models.Telemetry) *float64
+}{
+	"batteryLevel": {"%", func(t *models.Telemetry) float64 { return t.BatteryLevel }, func(t *models.Telemetry) *float64 { return &t.BatteryLevel }},
+	"temperature":  {"°C", func(t *models.Telemetry) float64 { return t.Temperature }, func(t *models.Telemetry) *float64 { return &t.Temperature }},
+	"cpuUsage":     {"%", func(t *models.Telemetry) float64 { return t.CPUUsage }, func(t *models.Telemetry) *float64 { return &t.CPUUsage }},
+	"memoryUsage":  {"MB", func(t *models.Telemetry) float64 { return t.MemoryUsage }, func(t *models.Telemetry) *float64 { return &t.MemoryUsage }},
+	"memoryTotal":  {"MB", func(t *models.Telemetry) float64 { return t.MemoryTotal }, func(t *models.Telemetry) *float64 { return &t.MemoryTotal }},
+}
+
+// SetMetric records a value on a reading by metric name, filling the
+// built-in field of that name or else adding a named metric. It is for
+// protocol adapters that receive metrics as name/value pairs.
+func SetMetric(t *models.Telemetry, name string, value float64, unit string) {
+	if builtin, ok := builtinMetrics[name]; ok {
+		*builtin.field(t) = value
+		return
+	}
+	if t.Metrics == nil {
+		t.Metrics = models.Metrics{}
+	}
+	t.Metrics[name] = models.Metric{Value: value, Unit: unit}
+}
+
+// MetricName turns a foreign metric name (e.g. "Motor 1/Speed RPM") into
+// one accepted in Telemetry.Metrics, or "" if nothing usable is left
+func MetricName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			b.WriteRune(r)
+		case r == '/':
+			b.WriteByte('.')
+		default:
+			b.WriteByte('_')
+		}
+	}
+	cleaned := strings.Trim(b.String(), "_.-")
+	if cleaned == "" {
+		return ""
+	}
+	if c := cleaned[0]; !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+		cleaned = "m_" + cleaned
+	}
+	if len(cleaned) > 64 {
+		cleaned = cleaned[:64]
+	}
+	return cleaned
+}
+
+// maxMetricsPerReading bounds how many named metrics one reading may carry
+const maxMetricsPerReading = 100
+
+var metricNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]{0,63}$`)
+
+func validateMetrics(metrics models.Metrics) error {
+	if len(metrics) > maxMetricsPerReading {
+		return &ValidationError{fmt.Sprintf("a reading may carry at most %d metrics", maxMetricsPerReading)}
+	}
+	for name := range metrics {
+		if !metricNamePattern.MatchString(name) {
+			return &ValidationError{fmt.Sprintf("invalid metric name %q", name)}
+		}
+		if _, ok := builtinMetrics[name]; ok {
+			return &ValidationError{fmt.Sprintf("metric %q must be sent as a top-level field", name)}
+		}
+	}
+	return nil
+}
+
+// indexMetrics records each named metric of a stored reading in the
+// device's per-metric time index and its set of known metric names
+func indexMetrics(ctx context.Context, pipe redis.Pipeliner, t *models.Telemetry) {
+	for name := range t.Metrics {
+		pipe.ZAdd(ctx, fmt.Sprintf("device:%d:metric:%s", t.DeviceID, name), telemetryIndexEntry(t))
+		pipe.SAdd(ctx, fmt.Sprintf("device:%d:metrics", t.DeviceID), name)
+	}
+}
+
+// GetDeviceMetricNames lists the metrics a device reports: the built-in
+// fields plus every named metric it has sent
+func (s *TelemetryService) GetDeviceMetricNames(deviceID int) ([]string, error) {
+	names, err := s.db.GetClient().SMembers(s.db.GetContext(), fmt.Sprintf("device:%d:metrics", deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metric names: %w", err)
+	}
+	for name := range builtinMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetMetricSeries returns up to limit values of one metric for a device over
+// the last hours, newest first
+func (s *TelemetryService) GetMetricSeries(deviceID int, metric string, hours int, limit int) ([]models.MetricPoint, error) {
+	builtin, isBuiltin := builtinMetrics[metric]
+	key := fmt.Sprintf("device:%d:metric:%s", deviceID, metric)
+	if isBuiltin {
+		key = fmt.Sprintf("device:%d:telemetry", deviceID)
+	}
+
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	telemetryIDs, err := s.db.GetClient().ZRevRangeByScore(s.db.GetContext(), key, &redis.ZRangeBy{
+		Min:   strconv.FormatInt(since.UnixMilli(), 10),
+		Max:   "+inf",
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get telemetry IDs: %w", err)
+	}
+
+	points := []models.MetricPoint{}
+	for _, idStr := range telemetryIDs {
+		telemetryData, err := s.db.GetClient().HGet(s.db.GetContext(), fmt.Sprintf("telemetry:%s", idStr), "data").Result()
+		if err != nil {
+			continue
+		}
+		var t models.Telemetry
+		if err := json.Unmarshal([]byte(telemetryData), &t); err != nil {
+			continue
+		}
+		point := models.MetricPoint{TelemetryID: t.ID, Timestamp: t.Timestamp}
+		if isBuiltin {
+			point.Value = builtin.value(&t)
+			point.Unit = builtin.unit
+		} else {
+			m, ok := t.Metrics[metric]
+			if !ok {
+				continue
+			}
+			point.Value = m.Value
+			point.Unit = m.Unit
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
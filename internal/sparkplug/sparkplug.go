@@ -0,0 +1,351 @@
+// Package sparkplug decodes the parts of Sparkplug B the server ingests:
+// topic names and the protobuf payload of birth, data and death messages.
+package sparkplug
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Namespace is the first topic level of every Sparkplug B message
+const Namespace = "spBv1.0"
+
+// Message types
+const (
+	NBIRTH = "NBIRTH"
+	NDEATH = "NDEATH"
+	DBIRTH = "DBIRTH"
+	DDEATH = "DDEATH"
+	NDATA  = "NDATA"
+	DDATA  = "DDATA"
+	NCMD   = "NCMD"
+	DCMD   = "DCMD"
+)
+
+// Metric data types (Sparkplug B specification, section 6.4.16)
+const (
+	TypeInt8     = 1
+	TypeInt16    = 2
+	TypeInt32    = 3
+	TypeInt64    = 4
+	TypeUInt8    = 5
+	TypeUInt16   = 6
+	TypeUInt32   = 7
+	TypeUInt64   = 8
+	TypeFloat    = 9
+	TypeDouble   = 10
+	TypeBoolean  = 11
+	TypeString   = 12
+	TypeDateTime = 13
+	TypeText     = 14
+)
+
+// Topic is a parsed spBv1.0/<group>/<type>/<edge node>[/<device>] topic
+type Topic struct {
+	GroupID     string
+	MessageType string
+	EdgeNodeID  string
+	DeviceID    string
+}
+
+// ParseTopic splits a Sparkplug B topic. STATE topics and anything outside
+// the namespace are reported as not ok.
+func ParseTopic(topic string) (Topic, bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || len(parts) > 5 || parts[0] != Namespace {
+		return Topic{}, false
+	}
+	t := Topic{GroupID: parts[1], MessageType: parts[2], EdgeNodeID: parts[3]}
+	if len(parts) == 5 {
+		t.DeviceID = parts[4]
+	}
+	for _, part := range parts[1:] {
+		if part == "" || part == "+" || part == "#" {
+			return Topic{}, false
+		}
+	}
+	isDeviceMessage := strings.HasPrefix(t.MessageType, "D")
+	if isDeviceMessage != (t.DeviceID != "") {
+		return Topic{}, false
+	}
+	return t, true
+}
+
+// NodeKey identifies the edge node across groups
+func (t Topic) NodeKey() string {
+	return t.GroupID + "/" + t.EdgeNodeID
+}
+
+// DeviceKey identifies a device attached to an edge node
+func (t Topic) DeviceKey() string {
+	return t.GroupID + "/" + t.EdgeNodeID + "/" + t.DeviceID
+}
+
+// Payload is a decoded Sparkplug B payload
+type Payload struct {
+	Timestamp uint64
+	Seq       uint64
+	Metrics   []Metric
+}
+
+// Metric is one decoded metric. Numeric and boolean values are in Value
+// with Numeric set; string values are in Text.
+type Metric struct {
+	Name       string
+	Alias      uint64
+	HasAlias   bool
+	Timestamp  uint64
+	DataType   uint32
+	IsNull     bool
+	Numeric    bool
+	Value      float64
+	Text       string
+	Properties map[string]string
+
+	// Integer values as sent, reinterpreted by finalize once the data type
+	// is known, which for aliased data is only after Resolve
+	intValue, longValue uint64
+	hasInt, hasLong     bool
+}
+
+// Decode parses a Sparkplug B payload. Dataset, template and extension
+// values are skipped.
+func Decode(data []byte) (*Payload, error) {
+	p := &Payload{}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			p.Timestamp = v
+		case num == 2 && typ == protowire.BytesType:
+			m, err := decodeMetric(raw)
+			if err != nil {
+				return err
+			}
+			p.Metrics = append(p.Metrics, m)
+		case num == 3 && typ == protowire.VarintType:
+			p.Seq = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid sparkplug payload: %w", err)
+	}
+	return p, nil
+}
+
+func decodeMetric(data []byte) (Metric, error) {
+	m := Metric{}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error {
+		switch num {
+		case 1:
+			m.Name = string(raw)
+		case 2:
+			m.Alias, m.HasAlias = v, true
+		case 3:
+			m.Timestamp = v
+		case 4:
+			m.DataType = uint32(v)
+		case 7:
+			m.IsNull = v != 0
+		case 9:
+			props, err := decodeProperties(raw)
+			if err != nil {
+				return err
+			}
+			m.Properties = props
+		case 10:
+			m.intValue, m.hasInt = v, true
+		case 11:
+			m.longValue, m.hasLong = v, true
+		case 12:
+			m.Numeric, m.Value = true, float64(math.Float32frombits(uint32(v)))
+		case 13:
+			m.Numeric, m.Value = true, math.Float64frombits(v)
+		case 14:
+			m.Numeric, m.Value = true, 0
+			if v != 0 {
+				m.Value = 1
+			}
+		case 15:
+			m.Text = string(raw)
+		}
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+	m.finalize()
+	return m, nil
+}
+
+// finalize sets the value of an integer metric. Integer types travel as
+// unsigned fields and are reinterpreted by the declared data type.
+func (m *Metric) finalize() {
+	switch {
+	case m.hasInt:
+		m.Numeric = true
+		switch m.DataType {
+		case TypeInt8:
+			m.Value = float64(int8(m.intValue))
+		case TypeInt16:
+			m.Value = float64(int16(m.intValue))
+		case TypeInt32:
+			m.Value = float64(int32(m.intValue))
+		default:
+			m.Value = float64(uint32(m.intValue))
+		}
+	case m.hasLong:
+		m.Numeric = m.DataType != TypeDateTime
+		if m.DataType == TypeInt64 {
+			m.Value = float64(int64(m.longValue))
+		} else {
+			m.Value = float64(m.longValue)
+		}
+	}
+}
+
+// decodeProperties keeps the string-valued entries of a PropertySet, which
+// is where units ("engUnit") and similar metadata are carried
+func decodeProperties(data []byte) (map[string]string, error) {
+	var keys []string
+	var values []string
+	err := walk(data, func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			keys = append(keys, string(raw))
+		case num == 2 && typ == protowire.BytesType:
+			var text string
+			err := walk(raw, func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error {
+				if num == 8 && typ == protowire.BytesType {
+					text = string(raw)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			values = append(values, text)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	props := make(map[string]string, len(keys))
+	for i, key := range keys {
+		if i < len(values) && values[i] != "" {
+			props[key] = values[i]
+		}
+	}
+	return props, nil
+}
+
+// walk calls fn for each field of a protobuf message. Varint and fixed
+// values are passed in v; length-delimited values in raw.
+func walk(data []byte, fn func(num protowire.Number, typ protowire.Type, raw []byte, v uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var raw []byte
+		var v uint64
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(data)
+			v = uint64(v32)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			raw, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, raw, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebirthRequest encodes the NCMD payload asking an edge node to republish
+// its birth certificates
+func RebirthRequest(timestamp uint64) []byte {
+	var metric []byte
+	metric = protowire.AppendTag(metric, 1, protowire.BytesType)
+	metric = protowire.AppendString(metric, "Node Control/Rebirth")
+	metric = protowire.AppendTag(metric, 3, protowire.VarintType)
+	metric = protowire.AppendVarint(metric, timestamp)
+	metric = protowire.AppendTag(metric, 4, protowire.VarintType)
+	metric = protowire.AppendVarint(metric, TypeBoolean)
+	metric = protowire.AppendTag(metric, 14, protowire.VarintType)
+	metric = protowire.AppendVarint(metric, 1)
+
+	var payload []byte
+	payload = protowire.AppendTag(payload, 1, protowire.VarintType)
+	payload = protowire.AppendVarint(payload, timestamp)
+	payload = protowire.AppendTag(payload, 2, protowire.BytesType)
+	payload = protowire.AppendBytes(payload, metric)
+	return payload
+}
+
+// ErrUnknownAlias is returned by Resolve for data referencing an alias the
+// birth certificate never declared
+var ErrUnknownAlias = errors.New("metric alias not declared in birth certificate")
+
+// Aliases remembers the names and units a birth certificate declared, so
+// data messages that send only aliases can be resolved
+type Aliases map[uint64]Metric
+
+// Learn records the aliased metrics of a birth certificate
+func (a Aliases) Learn(metrics []Metric) {
+	for _, m := range metrics {
+		if m.HasAlias && m.Name != "" {
+			a[m.Alias] = Metric{Name: m.Name, DataType: m.DataType, Properties: m.Properties}
+		}
+	}
+}
+
+// Resolve fills in the name, data type and properties of aliased metrics
+// from the birth certificate, and reinterprets their values by the declared
+// data type, which data messages usually leave out
+func (a Aliases) Resolve(metrics []Metric) error {
+	for i := range metrics {
+		m := &metrics[i]
+		if !m.HasAlias {
+			continue
+		}
+		declared, ok := a[m.Alias]
+		if !ok {
+			if m.Name == "" {
+				return ErrUnknownAlias
+			}
+			continue
+		}
+		if m.Name == "" {
+			m.Name = declared.Name
+		}
+		if m.DataType == 0 {
+			m.DataType = declared.DataType
+		}
+		if m.Properties == nil {
+			m.Properties = declared.Properties
+		}
+		m.finalize()
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+// Package spool is an on-disk write-ahead log holding telemetry that couldn't
+// be stored while the datastore was unavailable, so it can be replayed in
+// order once the store is back.
+//
+// The log is a directory of numbered segment files. Each record is a batch
+// of readings framed as a 4-byte length, a 4-byte CRC-32 and the JSON batch.
+// The replay position is checkpointed in a "position" file after every
+// record, so a restart resumes where replay stopped.
+package spool
+
+import (
+	"bufio"
+	"edgefleet-commander/internal/models"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segmentBytes is the size at which a new segment file is started
+const segmentBytes = 8 << 20
+
+const (
+	segmentSuffix = ".wal"
+	positionFile  = "position"
+	headerBytes   = 8
+)
+
+// ErrFull is returned by Append when the spool has reached its size limit
+var ErrFull = errors.New("telemetry spool is full")
+
+type segment struct {
+	seq  int64
+	size int64
+}
+
+// Spool is safe for concurrent use. Records are appended by any goroutine
+// and replayed by one.
+type Spool struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	segments []segment
+	file     *os.File
+	// readSeq and readOffset are where the next record to replay starts
+	readSeq    int64
+	readOffset int64
+	records    int
+	readings   int
+	bytes      int64
+	oldest     time.Time
+}
+
+// Open opens or creates the spool in dir, finding the records left by a
+// previous run. A record torn by a crash at the end of the log is dropped.
+func Open(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	s := &Spool{dir: dir, maxBytes: maxBytes}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, segment{seq: seq})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	if data, err := os.ReadFile(filepath.Join(dir, positionFile)); err == nil {
+		fmt.Sscanf(string(data), "%d %d", &s.readSeq, &s.readOffset)
+	}
+	if len(s.segments) > 0 && s.readSeq < s.segments[0].seq {
+		s.readSeq, s.readOffset = s.segments[0].seq, 0
+	}
+
+	// Count what is left to replay, and cut off a torn tail
+	for i := range s.segments {
+		seg := &s.segments[i]
+		start := int64(0)
+		if seg.seq == s.readSeq {
+			start = s.readOffset
+		}
+		count, readings, end, first, err := scan(s.path(seg.seq), start)
+		if err != nil {
+			return nil, err
+		}
+		if seg.seq < s.readSeq {
+			count, readings = 0, 0
+		}
+		seg.size = end
+		if i == len(s.segments)-1 {
+			if err := os.Truncate(s.path(seg.seq), end); err != nil {
+				return nil, fmt.Errorf("failed to truncate spool segment: %w", err)
+			}
+		}
+		s.records += count
+		s.readings += readings
+		if count > 0 {
+			s.bytes += end - start
+			if s.oldest.IsZero() {
+				s.oldest = first
+			}
+		}
+	}
+	if len(s.segments) == 0 {
+		s.segments = []segment{{seq: 1}}
+		s.readSeq, s.readOffset = 1, 0
+	}
+
+	last := s.segments[len(s.segments)-1]
+	s.file, err = os.OpenFile(s.path(last.seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Spool) path(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+// scan counts the intact records and readings of a segment from offset,
+// returning where the last record ends and the receive time of the first
+func scan(path string, offset int64) (int, int, int64, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, time.Time{}, fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, time.Time{}, fmt.Errorf("failed to stat spool segment: %w", err)
+	}
+	offset = min(offset, info.Size())
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, 0, time.Time{}, err
+	}
+	reader := bufio.NewReader(f)
+	count, readings := 0, 0
+	var first time.Time
+	for {
+		batch, n, err := readRecord(reader)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Warning: spool segment %s ends in a damaged record at offset %d: %v", path, offset, err)
+			}
+			return count, readings, offset, first, nil
+		}
+		if count == 0 && len(batch) > 0 {
+			first = batch[0].ReceivedAt
+		}
+		count++
+		readings += len(batch)
+		offset += n
+	}
+}
+
+// readRecord reads one framed record, returning its size on disk
+func readRecord(r io.Reader) ([]models.Telemetry, int64, error) {
+	header := make([]byte, headerBytes)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("truncated header")
+		}
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > segmentBytes {
+		return nil, 0, fmt.Errorf("record length %d out of range", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, fmt.Errorf("truncated record")
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, fmt.Errorf("checksum mismatch")
+	}
+	var batch []models.Telemetry
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, 0, fmt.Errorf("invalid record: %w", err)
+	}
+	return batch, int64(headerBytes + len(data)), nil
+}
+
+// Append writes a batch of readings to the log and syncs it to disk.
+// Readings without a timestamp are stamped now, and ReceivedAt records when
+// they were spooled.
+func (s *Spool) Append(readings []models.Telemetry) error {
+	now := time.Now()
+	batch := make([]models.Telemetry, len(readings))
+	copy(batch, readings)
+	for i := range batch {
+		if batch[i].Timestamp.IsZero() {
+			batch[i].Timestamp = now
+		}
+		batch[i].ReceivedAt = now
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spooled telemetry: %w", err)
+	}
+	if len(data) > segmentBytes {
+		return fmt.Errorf("batch too large to spool")
+	}
+	record := make([]byte, headerBytes+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(data))
+	copy(record[headerBytes:], data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bytes+int64(len(record)) > s.maxBytes {
+		return ErrFull
+	}
+	last := &s.segments[len(s.segments)-1]
+	if last.size > 0 && last.size+int64(len(record)) > segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last = &s.segments[len(s.segments)-1]
+	}
+	if _, err := s.file.Write(record); err != nil {
+		// Don't leave a partial record for the next one to follow
+		s.file.Truncate(last.size)
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		s.file.Truncate(last.size)
+		return fmt.Errorf("failed to sync spool: %w", err)
+	}
+	last.size += int64(len(record))
+	s.bytes += int64(len(record))
+	if s.records == 0 {
+		s.oldest = now
+	}
+	s.records++
+	s.readings += len(batch)
+	return nil
+}
+
+// rotate starts a new segment; s.mu must be held
+func (s *Spool) rotate() error {
+	seq := s.segments[len(s.segments)-1].seq + 1
+	f, err := os.OpenFile(s.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	s.file.Close()
+	s.file = f
+	s.segments = append(s.segments, segment{seq: seq})
+	return nil
+}
+
+// Pending reports whether any records are waiting to be replayed
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records > 0
+}
+
+// Replay hands the spooled batches to store in the order they were
+// appended, until the spool is empty or store fails. A batch is only
+// removed once store has succeeded, so Pending stays true until the last
+// one is stored. It returns the number of batches replayed.
+func (s *Spool) Replay(store func([]models.Telemetry) error) (int, error) {
+	replayed := 0
+	for {
+		s.mu.Lock()
+		if s.records == 0 {
+			s.mu.Unlock()
+			return replayed, nil
+		}
+		batch, size, err := s.next()
+		s.mu.Unlock()
+		if err != nil {
+			return replayed, err
+		}
+
+		if err := store(batch); err != nil {
+			return replayed, err
+		}
+
+		s.mu.Lock()
+		err = s.advance(size, len(batch))
+		s.mu.Unlock()
+		if err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+}
+
+// next reads the record at the replay position, moving on to the next
+// segment when the current one is used up; s.mu must be held
+func (s *Spool) next() ([]models.Telemetry, int64, error) {
+	for {
+		seg := s.segments[0]
+		if s.readOffset < seg.size {
+			f, err := os.Open(s.path(seg.seq))
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to open spool segment: %w", err)
+			}
+			defer f.Close()
+			if _, err := f.Seek(s.readOffset, io.SeekStart); err != nil {
+				return nil, 0, err
+			}
+			batch, size, err := readRecord(bufio.NewReader(f))
+			if err != nil {
+				// Only a damaged segment gets here; skip the rest of it
+				log.Printf("Warning: skipping damaged spool segment %d at offset %d: %v", seg.seq, s.readOffset, err)
+				s.readOffset = seg.size
+				continue
+			}
+			return batch, size, nil
+		}
+		if len(s.segments) == 1 {
+			return nil, 0, fmt.Errorf("spool has no record to replay")
+		}
+		if err := s.dropHead(); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// advance moves the replay position past a stored record and checkpoints
+// it. Once everything is replayed the log starts over in a fresh segment.
+// s.mu must be held.
+func (s *Spool) advance(size int64, readings int) error {
+	s.readOffset += size
+	s.records--
+	s.readings -= readings
+	s.bytes -= size
+	if s.records > 0 {
+		return s.checkpoint()
+	}
+
+	// Everything has been replayed: start over in a new segment
+	s.bytes = 0
+	s.readings = 0
+	s.oldest = time.Time{}
+	if err := s.rotate(); err != nil {
+		return err
+	}
+	for len(s.segments) > 1 {
+		if err := s.dropHead(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropHead deletes the oldest segment once it has been replayed; s.mu must
+// be held
+func (s *Spool) dropHead() error {
+	if err := os.Remove(s.path(s.segments[0].seq)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove spool segment: %w", err)
+	}
+	s.segments = s.segments[1:]
+	s.readSeq, s.readOffset = s.segments[0].seq, 0
+	return s.checkpoint()
+}
+
+// checkpoint records the replay position; s.mu must be held
+func (s *Spool) checkpoint() error {
+	position := fmt.Sprintf("%d %d", s.readSeq, s.readOffset)
+	tmp := filepath.Join(s.dir, positionFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(position), 0o644); err != nil {
+		return fmt.Errorf("failed to checkpoint spool: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, positionFile)); err != nil {
+		return fmt.Errorf("failed to checkpoint spool: %w", err)
+	}
+	return nil
+}
+
+// Stats reports how much is waiting to be replayed
+func (s *Spool) Stats() models.SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := models.SpoolStats{
+		Enabled:  true,
+		Records:  s.records,
+		Readings: s.readings,
+		Bytes:    s.bytes,
+		MaxBytes: s.maxBytes,
+		Segments: len(s.segments),
+	}
+	if s.records > 0 {
+		oldest := s.oldest
+		stats.Oldest = &oldest
+	}
+	return stats
+}